@@ -13,19 +13,46 @@
 // limitations under the License.
 
 // Package bplist implements a parser and writer for binary property list files.
+// It can also read and write property lists in Apple's XML format; see
+// ParseXML and XMLEncoder.
+//
+// # Constrained environments
+//
+// The parser is designed to run with little memory. Parse and the Handler
+// interface do not use reflection, so a Handler that inspects property lists
+// from devices need not either. Parse allocates space for the offset table of
+// the input, proportional to the number of objects, and otherwise allocates
+// per element only to decode strings and to box each datum as an interface
+// value for the handler. With the ZeroCopy option, strings are not decoded,
+// leaving only the interface conversion. The Transform, Warn, and
+// ContinueOnError options may allocate further.
+//
+// Other parts of the package, such as Marshal, Unmarshal, and the JSON
+// support of Value, do use reflection, and the package imports reflect,
+// encoding/json, and net/url, so they are linked into any program that uses
+// it. The package is not tested with TinyGo or other restricted toolchains.
 package bplist
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
+	"slices"
+	"strings"
 	"time"
 	"unicode/utf16"
 )
 
-const macEpoch = 978307200 // 01-Jan-2001
+const (
+	macEpoch     = 978307200 // 01-Jan-2001
+	magic        = "bplist"  // file header prefix
+	trailerBytes = 32        // size of the file trailer
+)
 
 // References:
 //   https://opensource.apple.com/source/CF/CF-550/CFBinaryPList.c
@@ -60,6 +87,52 @@ type Handler interface {
 	Close(Collection) error
 }
 
+// A RefHandler is a Handler that is told the object ID of each object before
+// it is delivered. A binary property list may store an object once and refer
+// to it from several collections; a RefHandler can use the IDs to reconstruct
+// this sharing, rather than receiving a separate copy of the object for each
+// reference. If the Handler passed to Parse implements RefHandler, its Ref
+// method is called before each object, including the root and dict keys.
+type RefHandler interface {
+	Handler
+
+	// Called with the object ID of the next object. If Ref reports true, the
+	// object is not delivered, and the handler should use the object it
+	// previously received with that ID in its place. Otherwise the object is
+	// delivered as usual, by the next call to Value or Open.
+	//
+	// A handler that needs the ID of every object, for example to resolve the
+	// object indexes used by an NSKeyedArchiver, can record id and report
+	// false to receive every object in full.
+	Ref(id int) (bool, error)
+}
+
+// A SpanHandler is a Handler that is told the location of each object in the
+// input. If the Handler passed to Parse implements SpanHandler, its Span
+// method is called before each object is delivered.
+type SpanHandler interface {
+	Handler
+
+	// Called with the byte offset and length in the input of the next object,
+	// after Ref and before Value or Open. For a collection, the span covers
+	// only the collection object itself, with its element references, and
+	// not the elements.
+	Span(offset, length int) error
+}
+
+// A KeyHandler is a Handler that receives the keys of a dictionary separately
+// from its values. If the Handler passed to Parse implements KeyHandler, its
+// Key method is called in place of Value for each key of a dict.
+type KeyHandler interface {
+	Handler
+
+	// Called for each primitive key of a dict, in place of Value. The type and
+	// datum are as for Value; in a valid property list the type is TString or
+	// TUnicode. A key that is not a primitive value, which is not valid, is
+	// delivered with Open and Close as usual.
+	Key(typ Type, datum any) error
+}
+
 // Type enumerates the types of primitive elements in the property list.
 type Type int
 
@@ -70,7 +143,8 @@ const (
 	// TBool represents a Boolean value. Its datum is a bool.
 	TBool
 
-	// TInteger represents an integer value. Its datum is an int64.
+	// TInteger represents an integer value. Its datum is an int64, except
+	// that a value outside the range of int64 is a uint64 or a *big.Int.
 	TInteger
 
 	// TFloat represents a floating-point value. Its datum is a float64.
@@ -88,8 +162,17 @@ const (
 	// TUnicode represents a UTF-16 string. Its datum is a []rune.
 	TUnicode
 
-	// TUID represents a UID value. Its datum is a []byte.
+	// TUID represents a UID value. Its datum is a []byte of 1 to 16 bytes,
+	// giving the value of the UID in big-endian order. A Builder accepts
+	// 1 to 8 bytes, and encodes each UID with the fewest of 1, 2, 4, or 8
+	// bytes that hold its value.
 	TUID
+
+	// TRaw represents an object of a type this package does not model, as
+	// its encoding. Its datum is a []byte beginning with the marker byte of
+	// the object. The parser reports TRaw values only if the Raw option is
+	// set, and a Builder writes them without interpretation.
+	TRaw
 )
 
 func (t Type) String() string {
@@ -112,6 +195,8 @@ func (t Type) String() string {
 		return "unicode"
 	case TUID:
 		return "uid"
+	case TRaw:
+		return "raw"
 	}
 	return "unknown"
 }
@@ -121,9 +206,10 @@ type Collection int
 
 // Constants defining the collection types.
 const (
-	Array Collection = iota + 1 // an ordered sequence
-	Set                         // an unordered group
-	Dict                        // a collection of key/value pairs
+	Array      Collection = iota + 1 // an ordered sequence
+	Set                              // an unordered group
+	Dict                             // a collection of key/value pairs
+	OrderedSet                       // an ordered group of distinct items
 )
 
 func (c Collection) String() string {
@@ -134,168 +220,1044 @@ func (c Collection) String() string {
 		return "set"
 	case Dict:
 		return "dict"
+	case OrderedSet:
+		return "orderedset"
 	}
 	return "unknown"
 }
 
+// ErrStop is a sentinel error that a Handler may report to stop parsing early.
+// When a handler method reports ErrStop, or an error wrapping it, parsing
+// ends and the parser reports success, apart from any problems recorded
+// under the ContinueOnError option.
+var ErrStop = errors.New("stop parsing")
+
+// ErrUnsupportedVersion is reported by Parse for a property list whose format
+// version is recognized but cannot be decoded. This includes the "1x" versions
+// such as "15" and "16", which this package does not decode.
+var ErrUnsupportedVersion = errors.New("unsupported format version")
+
 // Parse parses data as a binary property list, calling the methods of h to
 // deliver the results. An error from h terminates parsing and is reported to
-// the caller of Parse.
+// the caller of Parse, wrapped in a *HandlerError giving its location, unless
+// the error is ErrStop.
+//
+// Only version "00" of the binary property list schema is supported. The "1x"
+// versions (such as "15" and "16") use a different file structure, which this
+// package does not decode. Parse delivers their version to h, so that h can
+// report its own error or ErrStop, and otherwise reports ErrUnsupportedVersion.
 //
-// Only version "00" of the binary property list schema is fully understood.
-func Parse(data []byte, h Handler) error {
-	const magic = "bplist"
-	const trailerBytes = 32
-	if !bytes.HasPrefix(data, []byte(magic)) {
-		return errors.New("invalid magic number")
-	} else if len(data) < len(magic)+2+trailerBytes {
-		return errors.New("invalid file structure")
+// Every offset, size, and object reference read from data is checked against
+// the bounds of the input, and a malformed input is reported as an error.
+// The property list must span all of data; use ParsePrefix to parse a
+// property list followed by other data.
+//
+// Parse uses default options; see ParseOptions for other settings.
+func Parse(data []byte, h Handler) error { return ParseOptions{}.Parse(data, h) }
+
+// ParseOptions control the behavior of the parser. The zero value provides
+// default settings, and is the configuration used by the Parse function.
+type ParseOptions struct {
+	// If Strict is true, the parser reports an error for anomalies in the
+	// file structure that are otherwise tolerated. By default the reserved
+	// bytes of the trailer are ignored; in strict mode they must be zero.
+	// Likewise, in strict mode the trailer must immediately follow the offsets
+	// table. For XML input, strict mode requires dates in exactly the format
+	// Apple's tools write, and Boolean elements without any content.
+	Strict bool
+
+	// If LenientXML is true, ParseXML also accepts forms found in XML property
+	// lists written by older or non-Apple tools: a date without a time zone,
+	// which is taken as UTC, or without a time; base64 data without padding;
+	// an integer in hexadecimal, with a "0x" prefix; and text inside a true
+	// or false element, which is ignored. It has no effect in strict mode.
+	LenientXML bool
+
+	// XMLDialect selects the dialect of XML input. With GNUstepXML, the
+	// escapes of that dialect are decoded in strings and keys. The document
+	// type declaration of the input is not checked in either dialect.
+	XMLDialect XMLDialect
+
+	// In the binary format, 1-, 2-, and 4-byte integers are unsigned, 8-byte
+	// integers are signed, and 16-byte integers are unsigned. If UnsignedInt64
+	// is true, 8-byte integers with the high-order bit set are reported as
+	// uint64 values rather than as negative int64 values.
+	UnsignedInt64 bool
+
+	// If Transform is non-nil, a datum whose type has an entry in the map is
+	// passed to the corresponding function before it is delivered to the
+	// handler, and the handler receives the result in place of the original.
+	// This allows a handler to receive domain types directly, for example a
+	// decoded structure for TBytes data with a known format. An error from a
+	// transform function terminates parsing.
+	Transform map[Type]func(datum any) (any, error)
+
+	// If ContinueOnError is true, the parser does not stop at an object that
+	// cannot be decoded, such as one with an unrecognized tag or a reference
+	// to a nonexistent object. Instead, it records the problem, delivers a
+	// TNull in place of the object, and continues. If any problems were
+	// recorded, Parse returns them at the end, combined with errors.Join.
+	// Errors reported by the handler still stop the parse immediately.
+	ContinueOnError bool
+
+	// If Warn is non-nil, it is called for each anomaly the parser finds that
+	// does not prevent decoding, such as a non-minimal integer or size
+	// encoding, a duplicate dictionary key, or an object that is not reachable
+	// from the root. Warnings do not affect whether parsing succeeds.
+	Warn func(Warning)
+
+	// If ZeroCopy is true, TString and TUnicode data are delivered as []byte
+	// slices of the input rather than as newly-allocated values: A TString is
+	// the raw ASCII or UTF-8 bytes, and a TUnicode is the raw big-endian UTF-16
+	// code units. The handler must not modify these slices, and must copy any
+	// it wishes to retain after it returns. TBytes and TUID data are always
+	// slices of the input. See also "Constrained environments" in the package
+	// documentation.
+	ZeroCopy bool
+
+	// If MaxDepth is positive, the parser reports an error for a collection
+	// nested more than MaxDepth levels deep, counting the root as level 1.
+	// By default, nesting depth is not limited.
+	MaxDepth int
+
+	// If Raw is true, an object whose marker byte this package does not
+	// recognize is delivered to the handler as a TRaw value, rather than
+	// reported as an error. The extent of such an object cannot be decoded,
+	// so it is taken to end where the next object, or the offset table,
+	// begins. This allows a property list containing objects of newer types
+	// to be passed through to a Builder unchanged.
+	Raw bool
+
+	// If Recover is true and the trailer of the input is missing or damaged,
+	// the parser attempts to reconstruct the offset table by decoding the
+	// objects of the input in sequence. This can salvage the contents of a
+	// truncated file, but objects lost from the end of the input are reported
+	// as errors; combine Recover with ContinueOnError to deliver whatever
+	// remains. A successful recovery is reported as a warning.
+	Recover bool
+}
+
+// A ParseOption is a setting for ParseWith.
+type ParseOption func(*ParseOptions)
+
+// WithStrict is a ParseOption that sets ParseOptions.Strict.
+func WithStrict() ParseOption { return func(o *ParseOptions) { o.Strict = true } }
+
+// WithZeroCopy is a ParseOption that sets ParseOptions.ZeroCopy.
+func WithZeroCopy() ParseOption { return func(o *ParseOptions) { o.ZeroCopy = true } }
+
+// WithMaxDepth is a ParseOption that sets ParseOptions.MaxDepth to n.
+func WithMaxDepth(n int) ParseOption { return func(o *ParseOptions) { o.MaxDepth = n } }
+
+// ParseWith parses data as a binary property list, calling the methods of h
+// to deliver the results. The options are applied in order to a zero
+// ParseOptions value, which is then used as for ParseOptions.Parse.
+func ParseWith(data []byte, h Handler, opts ...ParseOption) error {
+	var o ParseOptions
+	for _, opt := range opts {
+		opt(&o)
 	}
+	return o.Parse(data, h)
+}
+
+// A Warning describes a non-fatal anomaly found during parsing.
+type Warning struct {
+	Object  int    // the ID of the object concerned, or -1 if none
+	Offset  int    // the byte offset of the object in the input
+	Message string // a human-readable description of the anomaly
+}
+
+func (w Warning) String() string {
+	if w.Object < 0 {
+		return w.Message
+	}
+	return fmt.Sprintf("object %d at offset %d: %s", w.Object, w.Offset, w.Message)
+}
+
+// Parse parses data as a binary property list using the options in o, calling
+// the methods of h to deliver the results. An error from h terminates parsing
+// and is reported to the caller.
+func (o ParseOptions) Parse(data []byte, h Handler) error {
+	if err := o.checkFraming(data); err != nil {
+		return err
+	}
+	return o.parse(&parser{data: data, size: len(data)}, h)
+}
+
+// ParseContext parses data as a binary property list, as described for Parse,
+// but checks ctx between objects and stops with the error from ctx.Err if ctx
+// ends before parsing is complete. It uses default options; see
+// ParseOptions.ParseContext for other settings.
+func ParseContext(ctx context.Context, data []byte, h Handler) error {
+	return ParseOptions{}.ParseContext(ctx, data, h)
+}
 
+// ParseContext parses data as a binary property list using the options in o,
+// as described for Parse, but checks ctx between objects and stops with the
+// error from ctx.Err if ctx ends before parsing is complete.
+func (o ParseOptions) ParseContext(ctx context.Context, data []byte, h Handler) error {
+	if err := o.checkFraming(data); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	return o.parse(&parser{data: data, size: len(data), ctx: ctx}, h)
+}
+
+// parse parses the input of p, which must have been checked for framing
+// unless o.Recover is set, and delivers the results to h.
+func (o ParseOptions) parse(p *parser, h Handler) error {
 	// Call the Version handler eagerly, to give the caller a chance to bail out
 	// for an incompatible version before we do more work.
-	pos := len(magic)
-	if err := h.Version(string(data[pos : pos+2])); err != nil {
+	ver, err := p.slice(len(magic), 2)
+	if err != nil {
 		return err
 	}
+	if err := h.Version(string(ver)); err != nil {
+		if errors.Is(err, ErrStop) {
+			return nil
+		}
+		return err
+	}
+	if err := o.prepare(p, h, ver); err != nil {
+		return err
+	}
+	if o.Warn != nil {
+		p.seen = make([]bool, len(p.offsets))
+	}
+	if err := p.walk(p.t.RootObject); errors.Is(err, ErrStop) {
+		return errors.Join(p.errs...)
+	} else if err != nil {
+		return err
+	}
+	for id, ok := range p.seen {
+		if !ok {
+			p.warn(id, "object is not reachable from the root")
+		}
+	}
+	return errors.Join(p.errs...)
+}
 
-	t := parseTrailer(data[len(data)-32:])
-	if t.tableEnd() > len(data)-32 {
-		return errors.New("invalid offsets table")
+// prepare checks the version of the input of p, decodes its trailer and
+// offset table, and prepares p to deliver objects to h. If the trailer is
+// damaged and o.Recover is set, it attempts to reconstruct the offset table.
+func (o ParseOptions) prepare(p *parser, h Handler, ver []byte) error {
+	if ver[0] == '1' {
+		return structErr(len(magic), fmt.Errorf("%w %q", ErrUnsupportedVersion, ver))
+	}
+	if err := o.setup(p, h); err != nil {
+		if !o.Recover || o.recover(p, h) != nil {
+			return err
+		}
+		p.warn(-1, "damaged trailer (%v); recovered %d objects", err, len(p.offsets))
 	}
+	return nil
+}
 
-	offsets := make([]int, t.NumObjects)
-	for i := 0; i < len(offsets); i++ {
-		base := t.OffsetTable + t.OffsetBytes*i
-		offsets[i] = int(parseInt(data[base : base+t.OffsetBytes]))
+// newParser decodes the trailer and offset table of data, and returns a parser
+// ready to deliver objects to h.
+// Precondition: checkFraming(data) == nil
+func (o ParseOptions) newParser(data []byte, h Handler) (*parser, error) {
+	p := &parser{data: data, size: len(data)}
+	if err := o.setup(p, h); err != nil {
+		return nil, err
 	}
+	return p, nil
+}
 
-	var parseObj func(int) error
-	parseObj = func(id int) error {
-		off := offsets[id]
-		tag := data[off]
+// setup decodes the trailer and offset table of the input of p, and prepares
+// p to deliver objects to h.
+func (o ParseOptions) setup(p *parser, h Handler) error {
+	toff := p.size - trailerBytes
+	tbuf, err := p.slice(toff, trailerBytes)
+	if err != nil {
+		return structErr(toff, err)
+	}
+	t := parseTrailer(tbuf)
+	if err := t.check(p.size); err != nil {
+		return structErr(toff, err)
+	}
+	if o.Strict && (t.Unused != [5]byte{} || t.SortVersion != 0) {
+		return structErr(toff, fmt.Errorf("nonzero reserved trailer bytes % x", tbuf[:6]))
+	}
+	table, err := p.slice(t.OffsetTable, t.needBytes())
+	if err != nil {
+		return structErr(t.OffsetTable, fmt.Errorf("invalid offsets table: %w", err))
+	}
+	gap := toff - t.tableEnd()
+	if gap != 0 && o.Strict {
+		return structErr(t.tableEnd(), fmt.Errorf("%d unused bytes after offsets table", gap))
+	}
+	p.opts, p.h, p.t = o, h, t
+	if gap != 0 {
+		p.warn(-1, "%d unused bytes after offsets table", gap)
+	}
+	p.offsets = make([]int, t.NumObjects)
+	decodeOffsets(p.offsets, table, t.OffsetBytes)
+	return nil
+}
 
-		switch sel := tag >> 4; sel {
-		case 0: // null, bool, fill
-			switch tag & 0xf {
-			case 0:
-				return h.Value(TNull, nil)
-			case 8:
-				return h.Value(TBool, false)
-			case 9:
-				return h.Value(TBool, true)
-			}
+// A parser holds the state of a single call to Parse.
+type parser struct {
+	opts    ParseOptions
+	data    []byte      // the input, if it is in memory
+	ra      io.ReaderAt // the input, if data == nil
+	size    int         // the length of the input in bytes
+	h       Handler
+	t       *Trailer
+	offsets []int           // :: objid → offset
+	sorted  []int           // the offsets in increasing order (only for Raw)
+	errs    []error         // recoverable errors (with ContinueOnError)
+	seen    []bool          // :: objid → visited (only if warnings are enabled)
+	active  map[int]bool    // :: objid → collection is being parsed
+	depth   int             // the number of collections being parsed
+	isKey   bool            // the object being parsed is a dict key
+	ctx     context.Context // if non-nil, checked between objects
+}
+
+// slice returns the n bytes of input beginning at offset off, or an error if
+// they are not all within the input. If the input is in memory, the result
+// is a slice of it; otherwise it is a new slice.
+func (p *parser) slice(off, n int) ([]byte, error) {
+	if off < 0 || n < 0 || off > p.size || n > p.size-off {
+		return nil, fmt.Errorf("%d bytes at offset %d exceed input size %d", n, off, p.size)
+	}
+	if p.ra == nil {
+		return p.data[off : off+n : off+n], nil
+	}
+	buf := make([]byte, n)
+	if nr, err := p.ra.ReadAt(buf, int64(off)); nr < n {
+		return nil, fmt.Errorf("read at offset %d: %w", off, err)
+	}
+	return buf, nil
+}
 
-		case 1: // int
-			size := 1 << (tag & 0xf)
-			return h.Value(TInteger, parseInt(data[off+1:off+1+size]))
+// warn reports a warning about the specified object, if warnings are enabled.
+func (p *parser) warn(id int, msg string, args ...any) {
+	if p.opts.Warn != nil {
+		w := Warning{Object: id, Offset: -1, Message: fmt.Sprintf(msg, args...)}
+		if id >= 0 && id < len(p.offsets) {
+			w.Offset = p.offsets[id]
+		}
+		p.opts.Warn(w)
+	}
+}
 
-		case 2: // real
-			size := 1 << (tag & 0xf)
-			return h.Value(TFloat, parseFloat(data[off+1:off+1+size]))
+// A frame records the progress of the parser through an open collection.
+type frame struct {
+	id   int        // the object ID of the collection
+	coll Collection // the type of the collection, or 0 for no collection
+	size int        // the number of elements (pairs, for a dict)
+	refs []byte     // the object references of the elements
+	next int        // the number of element references already parsed
+}
 
-		case 3: // date
-			if tag&0xf == 3 {
-				sec := parseFloat(data[off+1 : off+9])
-				return h.Value(TTime, time.Unix(int64(sec)+macEpoch, 0).In(time.UTC))
+// nextRef returns the object ID of the next element of f, or -1 if all the
+// elements of f have been parsed. The elements of a dict are visited in the
+// order key1, value1, key2, value2, and so on.
+func (f *frame) nextRef(p *parser) int {
+	i := f.next
+	if f.coll == Dict {
+		if i >= 2*f.size {
+			return -1
+		}
+		f.next++
+		if i%2 == 1 {
+			return p.ref(f.refs, f.size+i/2)
+		}
+		return p.ref(f.refs, i/2)
+	}
+	if i >= f.size {
+		return -1
+	}
+	f.next++
+	return p.ref(f.refs, i)
+}
+
+// walk parses the object with the given ID and all the objects it contains,
+// delivering them to the handler in order. Nested collections are tracked on
+// an explicit stack rather than by recursion, so the depth of nesting is not
+// limited by the size of the goroutine stack.
+func (p *parser) walk(id int) error {
+	var stk []frame
+	p.isKey = false
+	for {
+		if p.ctx != nil {
+			if err := p.ctx.Err(); err != nil {
+				return err
 			}
+		}
+		f, err := p.parseElem(id)
+		if err != nil {
+			return p.handlerErr(stk, id, err)
+		} else if f.coll != 0 {
+			stk = append(stk, f)
+		}
 
-		case 4: // data
-			size, shift := sizeAndShift(tag, data[off+1:])
-			start := off + 1 + shift
-			end := start + size
-			return h.Value(TBytes, data[start:end])
-
-		case 5, 7: // ASCII or UTF-8 string
-			size, shift := sizeAndShift(tag, data[off+1:])
-			start := off + 1 + shift
-			end := start + size
-			return h.Value(TString, string(data[start:end]))
-
-		case 6: // Unicode string
-			size, shift := sizeAndShift(tag, data[off+1:])
-			start := off + 1 + shift
-			runes := make([]uint16, size)
-			for i := 0; i < size; i++ {
-				runes[i] = binary.BigEndian.Uint16(data[start:])
-				start += 2
+		// Find the next element to parse, closing any finished collections.
+		for {
+			if len(stk) == 0 {
+				return nil
 			}
-			return h.Value(TUnicode, utf16.Decode(runes))
-
-		case 8: // UID
-			size, shift := sizeAndShift(tag, data[off+1:])
-			start := off + 1 + shift
-			end := start + size
-			return h.Value(TUID, data[start:end])
-
-		case 10, 11, 12: // array or set
-			coll := Array
-			if sel == 11 || sel == 12 {
-				coll = Set
+			top := &stk[len(stk)-1]
+			if id = top.nextRef(p); id >= 0 {
+				p.isKey = top.coll == Dict && top.next%2 == 1
+				break
 			}
-			size, shift := sizeAndShift(tag, data[off+1:])
-			if err := h.Open(coll, size); err != nil {
-				return err
+			p.leave(top.id)
+			if err := p.h.Close(top.coll); err != nil {
+				return p.handlerErr(stk[:len(stk)-1], top.id, err)
+			}
+			stk = stk[:len(stk)-1]
+		}
+	}
+}
+
+// handlerErr returns err annotated with the location of the object with the
+// given ID, whose enclosing collections are stk, if it was reported by the
+// handler. Other errors are returned unmodified.
+func (p *parser) handlerErr(stk []frame, id int, err error) error {
+	if _, ok := err.(*ParseError); ok {
+		return err
+	}
+	he := &HandlerError{Offset: -1, Err: err}
+	if id >= 0 && id < len(p.offsets) {
+		he.Offset = p.offsets[id]
+	}
+	for i := range stk {
+		f := &stk[i]
+		j := f.next - 1 // the element in progress
+		if f.coll != Dict {
+			he.Path = append(he.Path, j)
+			continue
+		} else if j%2 == 0 {
+			break // a dict key has the path of the dict
+		}
+		key, ok := p.keyString(p.ref(f.refs, j/2))
+		if !ok {
+			key = "?"
+		}
+		he.Path = append(he.Path, key)
+	}
+	return he
+}
+
+// A HandlerError reports an error from a Handler method during parsing, with
+// the location of the object being delivered when it occurred. The Version
+// method is not covered, since it is not called for an object.
+type HandlerError struct {
+	Path   []any // the path of the object, as for PathTracker.Path
+	Offset int   // the byte offset of the object in the input, or -1
+	Err    error // the error reported by the handler
+}
+
+func (e *HandlerError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("at %s: %v", formatPath(e.Path), e.Err)
+	}
+	return fmt.Sprintf("at %s (offset %#x): %v", formatPath(e.Path), e.Offset, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error { return e.Err }
+
+// parseElem parses the object with the given ID as the root or as an element
+// of a collection, after offering its ID to the handler if it is a RefHandler.
+// If the object is a collection, parseElem opens it and returns a frame for
+// its elements. If the object cannot be decoded and the ContinueOnError
+// option is set, the problem is recorded and a TNull placeholder is delivered
+// to the handler in its place.
+func (p *parser) parseElem(id int) (frame, error) {
+	if rh, ok := p.h.(RefHandler); ok {
+		if skip, err := rh.Ref(id); err != nil || skip {
+			return frame{}, err
+		}
+	}
+	f, err := p.parseObj(id)
+	if pe, ok := err.(*ParseError); ok && p.opts.ContinueOnError {
+		p.errs = append(p.errs, pe)
+		return frame{}, p.value(TNull, nil)
+	}
+	return f, err
+}
+
+// objTag returns the offset and tag byte of the object with the given ID.
+func (p *parser) objTag(id int) (int, byte, error) {
+	if id < 0 || id >= len(p.offsets) {
+		return 0, 0, fmt.Errorf("reference out of range (%d objects)", len(p.offsets))
+	}
+	off := p.offsets[id]
+	buf, err := p.slice(off, 1)
+	if err != nil {
+		return 0, 0, fmt.Errorf("offset %d out of range", off)
+	}
+	return off, buf[0], nil
+}
+
+// parseObj parses the object with the given ID and delivers it to the handler.
+// If the object is a collection, parseObj opens it and returns a frame for its
+// elements, which the caller must parse.
+// Problems decoding the object itself are reported as *ParseError values;
+// other errors, such as those from the handler, are returned unmodified.
+func (p *parser) parseObj(id int) (frame, error) {
+	off, tag, err := p.objTag(id)
+	if err != nil {
+		return frame{}, p.objErr(id, err)
+	}
+	if p.seen != nil {
+		p.seen[id] = true
+	}
+	if sh, ok := p.h.(SpanHandler); ok {
+		// If the length is invalid, decoding will report it below.
+		if n, err := p.objLen(off, p.t.RefBytes); err == nil {
+			if err := sh.Span(off, n); err != nil {
+				return frame{}, err
 			}
-			start := off + 1 + shift
-			for i := 0; i < size; i++ {
-				ref := int(parseInt(data[start : start+t.RefBytes]))
-				if err := parseObj(ref); err != nil {
-					return err
-				}
-				start += t.RefBytes
+		}
+	}
+	switch sel := tag >> 4; sel {
+	case 0: // null, bool, fill
+		switch tag & 0xf {
+		case 0:
+			return frame{}, p.value(TNull, nil)
+		case 8:
+			return frame{}, p.value(TBool, false)
+		case 9:
+			return frame{}, p.value(TBool, true)
+		}
+
+	case 1: // int
+		buf, err := p.slice(off+1, 1<<(tag&0xf))
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		v, err := p.intValue(buf)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		if p.opts.Warn != nil && !isMinimalInt(buf) {
+			p.warn(id, "non-minimal %d-byte integer encoding", len(buf))
+		}
+		return frame{}, p.value(TInteger, v)
+
+	case 2: // real
+		buf, err := p.slice(off+1, 1<<(tag&0xf))
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		v, err := floatValue(buf)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		return frame{}, p.value(TFloat, v)
+
+	case 3: // date
+		if tag&0xf == 3 {
+			buf, err := p.slice(off+1, 8)
+			if err != nil {
+				return frame{}, p.objErr(id, err)
 			}
-			return h.Close(coll)
+			return frame{}, p.value(TTime, parseDate(parseFloat(buf)))
+		}
 
-		case 13: // dict
-			size, shift := sizeAndShift(tag, data[off+1:])
-			if err := h.Open(Dict, size); err != nil {
-				return err
+	case 4: // data
+		buf, err := p.payload(id, off, tag, 1)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		return frame{}, p.value(TBytes, buf)
+
+	case 8: // UID; the size is one less than the width
+		buf, err := p.slice(off+1, int(tag&0xf)+1)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		return frame{}, p.value(TUID, buf)
+
+	case 5, 7: // ASCII or UTF-8 string
+		buf, err := p.payload(id, off, tag, 1)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		if p.opts.ZeroCopy {
+			return frame{}, p.value(TString, buf)
+		}
+		return frame{}, p.value(TString, string(buf))
+
+	case 6: // Unicode string
+		buf, err := p.payload(id, off, tag, 2)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		if p.opts.ZeroCopy {
+			return frame{}, p.value(TUnicode, buf)
+		}
+		return frame{}, p.value(TUnicode, decodeUTF16(buf))
+
+	case 10, 11, 12: // array, ordered set, or set
+		coll := Array
+		if sel == 11 {
+			coll = OrderedSet
+		} else if sel == 12 {
+			coll = Set
+		}
+		size, refs, err := p.refs(id, off, tag, 1)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		return p.open(id, coll, size, refs)
+
+	case 13: // dict
+		size, refs, err := p.refs(id, off, tag, 2)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		if p.opts.Warn != nil {
+			p.checkKeys(id, refs[:size*p.t.RefBytes])
+		}
+		return p.open(id, Dict, size, refs)
+	}
+	if p.opts.Raw {
+		buf, err := p.slice(off, p.rawEnd(off)-off)
+		if err != nil {
+			return frame{}, p.objErr(id, err)
+		}
+		return frame{}, p.value(TRaw, buf)
+	}
+	return frame{}, p.objErr(id, fmt.Errorf("unrecognized tag %02x", tag))
+}
+
+// rawEnd returns the offset where the object at off is taken to end, if its
+// extent cannot be decoded: the offset of the next object, or of the offset
+// table if there is no next object.
+func (p *parser) rawEnd(off int) int {
+	if p.sorted == nil {
+		p.sorted = slices.Clone(p.offsets)
+		slices.Sort(p.sorted)
+	}
+	end := p.t.OffsetTable
+	if i, _ := slices.BinarySearch(p.sorted, off+1); i < len(p.sorted) && p.sorted[i] < end {
+		end = p.sorted[i]
+	}
+	return max(end, off+1)
+}
+
+// open opens the collection with the given ID, type, size, and element
+// references, and returns a frame for its elements.
+func (p *parser) open(id int, coll Collection, size int, refs []byte) (frame, error) {
+	if err := p.enter(id); err != nil {
+		return frame{}, err
+	}
+	if err := p.h.Open(coll, size); err != nil {
+		return frame{}, err
+	}
+	return frame{id: id, coll: coll, size: size, refs: refs}, nil
+}
+
+// enter marks the collection with the given ID as being parsed, or reports an
+// error if it already is, meaning the collection contains itself, or if it
+// would exceed the maximum nesting depth.
+func (p *parser) enter(id int) error {
+	if p.active[id] {
+		return p.objErr(id, errors.New("reference cycle: collection contains itself"))
+	} else if p.opts.MaxDepth > 0 && p.depth >= p.opts.MaxDepth {
+		return p.objErr(id, fmt.Errorf("collections nested more than %d deep", p.opts.MaxDepth))
+	}
+	if p.active == nil {
+		p.active = make(map[int]bool)
+	}
+	p.active[id] = true
+	p.depth++
+	return nil
+}
+
+// leave reverses the effect of a successful call to enter for id.
+func (p *parser) leave(id int) {
+	delete(p.active, id)
+	p.depth--
+}
+
+// objSize decodes the size of the object at off with the given tag, and the
+// number of bytes of extended size following the tag.
+func (p *parser) objSize(off int, tag byte) (size, shift int, err error) {
+	size = int(tag & 0xf)
+	if size != 15 {
+		return size, 0, nil
+	}
+	hdr, err := p.slice(off+1, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	width := 1 << int(hdr[0]&0xf)
+	if width > 8 {
+		return 0, 0, fmt.Errorf("invalid %d-byte size", width)
+	}
+	buf, err := p.slice(off+2, width)
+	if err != nil {
+		return 0, 0, err
+	}
+	z := parseInt(buf)
+	if z < 0 || z > int64(p.size) {
+		return 0, 0, fmt.Errorf("size %d exceeds input", z)
+	}
+	return int(z), 1 + width, nil
+}
+
+// objLen returns the length in bytes of the object at off, including its tag,
+// assuming object references of rb bytes each.
+func (p *parser) objLen(off, rb int) (int, error) {
+	buf, err := p.slice(off, 1)
+	if err != nil {
+		return 0, err
+	}
+	tag := buf[0]
+	switch sel := tag >> 4; sel {
+	case 0: // null, bool, fill
+		switch tag & 0xf {
+		case 0, 8, 9, 15:
+			return 1, nil
+		}
+	case 1: // int
+		if tag&0xf <= 4 {
+			return 1 + 1<<(tag&0xf), nil
+		}
+	case 2: // real
+		if n := tag & 0xf; n == 2 || n == 3 {
+			return 1 + 1<<n, nil
+		}
+	case 3: // date
+		if tag&0xf == 3 {
+			return 9, nil
+		}
+	case 8: // UID
+		return 2 + int(tag&0xf), nil
+	case 4, 5, 6, 7, 10, 11, 12, 13:
+		size, shift, err := p.objSize(off, tag)
+		if err != nil {
+			return 0, err
+		}
+		per := 1
+		switch sel {
+		case 6:
+			per = 2
+		case 10, 11, 12:
+			per = rb
+		case 13:
+			per = 2 * rb
+		}
+		if size > (p.size-off)/per {
+			return 0, fmt.Errorf("size %d exceeds input", size)
+		}
+		return 1 + shift + size*per, nil
+	}
+	return 0, fmt.Errorf("unrecognized tag %02x", tag)
+}
+
+// sizeAndShift decodes the size of the object at off with the given tag,
+// and reports a warning if the size is not minimally encoded.
+func (p *parser) sizeAndShift(id, off int, tag byte) (size, shift int, err error) {
+	size, shift, err = p.objSize(off, tag)
+	if err != nil || p.opts.Warn == nil {
+		return
+	}
+	if shift != 0 && size < 15 {
+		p.warn(id, "non-minimal size encoding for size %d", size)
+	} else if shift > 2 {
+		if buf, _ := p.slice(off+2, shift-1); !isMinimalInt(buf) {
+			p.warn(id, "non-minimal %d-byte size encoding", shift-1)
+		}
+	}
+	return
+}
+
+// payload returns the contents of the variable-length object at off with the
+// given tag, whose size counts units of the given width in bytes.
+func (p *parser) payload(id, off int, tag byte, width int) ([]byte, error) {
+	size, shift, err := p.sizeAndShift(id, off, tag)
+	if err != nil {
+		return nil, err
+	} else if size > p.size/width {
+		return nil, fmt.Errorf("size %d exceeds input", size)
+	}
+	return p.slice(off+1+shift, size*width)
+}
+
+// refs returns the size and the object references of the collection at off
+// with the given tag, which has per references for each of its elements.
+func (p *parser) refs(id, off int, tag byte, per int) (int, []byte, error) {
+	size, shift, err := p.sizeAndShift(id, off, tag)
+	if err != nil {
+		return 0, nil, err
+	} else if rb := p.t.RefBytes; rb < 1 || rb > 8 {
+		return 0, nil, fmt.Errorf("invalid reference size %d", rb)
+	} else if size > p.size/(per*rb) {
+		return 0, nil, fmt.Errorf("size %d exceeds input", size)
+	}
+	buf, err := p.slice(off+1+shift, size*per*p.t.RefBytes)
+	return size, buf, err
+}
+
+// ref decodes object reference i from refs.
+func (p *parser) ref(refs []byte, i int) int {
+	rb := p.t.RefBytes
+	return int(parseInt(refs[i*rb : (i+1)*rb]))
+}
+
+// checkKeys reports warnings for duplicate keys in the dictionary with the
+// given key references.
+func (p *parser) checkKeys(id int, refs []byte) {
+	ids := make(map[int]bool)
+	strs := make(map[string]bool)
+	for i := 0; i < len(refs)/p.t.RefBytes; i++ {
+		kref := p.ref(refs, i)
+		if ids[kref] {
+			p.warn(id, "duplicate key reference to object %d", kref)
+			continue
+		}
+		ids[kref] = true
+		if s, ok := p.keyString(kref); ok {
+			if strs[s] {
+				p.warn(id, "duplicate key %q", s)
 			}
-			keyStart := off + 1 + shift
-			valStart := keyStart + (size * t.RefBytes)
-			for i := 0; i < size; i++ {
-				kref := int(parseInt(data[keyStart : keyStart+t.RefBytes]))
-				if err := parseObj(kref); err != nil {
-					return err
-				}
-				keyStart += t.RefBytes
-
-				vref := int(parseInt(data[valStart : valStart+t.RefBytes]))
-				if err := parseObj(vref); err != nil {
-					return err
-				}
-				valStart += t.RefBytes
+			strs[s] = true
+		}
+	}
+}
+
+// keyString reports the value of the object with the given ID if it is a
+// well-formed string object. It does not deliver anything to the handler.
+func (p *parser) keyString(id int) (string, bool) {
+	off, tag, err := p.objTag(id)
+	if err != nil {
+		return "", false
+	}
+	sel := tag >> 4
+	if sel != 5 && sel != 6 && sel != 7 {
+		return "", false
+	}
+	size, shift, err := p.objSize(off, tag)
+	if err != nil {
+		return "", false
+	}
+	start := off + 1 + shift
+	if sel == 6 {
+		if size > p.size/2 {
+			return "", false
+		}
+		buf, err := p.slice(start, 2*size)
+		if err != nil {
+			return "", false
+		}
+		return string(decodeUTF16(buf)), true
+	}
+	buf, err := p.slice(start, size)
+	if err != nil {
+		return "", false
+	}
+	return string(buf), true
+}
+
+// decodeUTF16 decodes big-endian UTF-16 code units.
+func decodeUTF16(buf []byte) []rune {
+	u16 := make([]uint16, len(buf)/2)
+	for i := range u16 {
+		u16[i] = binary.BigEndian.Uint16(buf[2*i:])
+	}
+	return utf16.Decode(u16)
+}
+
+// objErr returns a *ParseError reporting err for the object with the given ID,
+// with its offset and tag if they are available.
+func (p *parser) objErr(id int, err error) error {
+	pe := &ParseError{Offset: -1, Object: id, Tag: -1, Err: err}
+	if off, tag, err := p.objTag(id); err == nil {
+		pe.Offset, pe.Tag = off, int(tag)
+	}
+	return pe
+}
+
+// structErr returns a *ParseError reporting err for the file structure at the
+// given offset, not associated with any one object.
+func structErr(off int, err error) error {
+	return &ParseError{Offset: off, Object: -1, Tag: -1, Err: err}
+}
+
+// A ParseError reports a problem decoding a binary property list, and where
+// in the input it was found.
+type ParseError struct {
+	Offset int   // the byte offset of the problem, or -1 if unknown
+	Object int   // the ID of the object concerned, or -1 if none
+	Tag    int   // the tag byte of the object, or -1 if unknown
+	Err    error // the underlying error
+}
+
+func (e *ParseError) Error() string {
+	var sb strings.Builder
+	if e.Object >= 0 {
+		fmt.Fprintf(&sb, "object %d", e.Object)
+	}
+	if e.Offset >= 0 {
+		if sb.Len() != 0 {
+			sb.WriteString(" at ")
+		}
+		fmt.Fprintf(&sb, "offset %d", e.Offset)
+	}
+	if e.Tag >= 0 {
+		fmt.Fprintf(&sb, " (tag %02x)", e.Tag)
+	}
+	if sb.Len() == 0 {
+		return e.Err.Error()
+	}
+	return sb.String() + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// value delivers a datum of the given type to the handler, after applying
+// the transform for typ, if any. A dict key is delivered to Key rather than
+// Value if the handler is a KeyHandler.
+func (p *parser) value(typ Type, datum any) error {
+	if f, ok := p.opts.Transform[typ]; ok {
+		v, err := f(datum)
+		if err != nil {
+			return fmt.Errorf("transform %v: %w", typ, err)
+		}
+		datum = v
+	}
+	if p.isKey {
+		if kh, ok := p.h.(KeyHandler); ok {
+			return kh.Key(typ, datum)
+		}
+	}
+	return p.h.Value(typ, datum)
+}
+
+// floatValue decodes the payload of a real object. Only 4-byte and 8-byte
+// reals are defined by the format.
+func floatValue(buf []byte) (float64, error) {
+	switch len(buf) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	}
+	return 0, fmt.Errorf("unsupported real size %d", len(buf))
+}
+
+// intValue decodes the payload of an integer object, following the signedness
+// rules of the format (see ParseOptions.UnsignedInt64).
+func (p *parser) intValue(buf []byte) (any, error) {
+	switch len(buf) {
+	case 1, 2, 4:
+		return parseInt(buf), nil
+	case 8:
+		v := binary.BigEndian.Uint64(buf)
+		if p.opts.UnsignedInt64 && v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case 16:
+		if hi := binary.BigEndian.Uint64(buf); hi == 0 {
+			lo := binary.BigEndian.Uint64(buf[8:])
+			if lo <= math.MaxInt64 {
+				return int64(lo), nil
 			}
-			return h.Close(Dict)
+			return lo, nil
 		}
-		return fmt.Errorf("unrecognized tag %02x", tag)
+		return new(big.Int).SetBytes(buf), nil
 	}
+	return nil, fmt.Errorf("unsupported integer size %d", len(buf))
+}
 
-	return parseObj(t.RootObject)
+// Info describes the framing of a binary property list file.
+type Info struct {
+	Version string  // the format version from the header, e.g., "00"
+	Trailer Trailer // the contents of the trailer
+}
+
+// ReadInfo reports the version and trailer of the property list in data,
+// without parsing any of its objects.
+func ReadInfo(data []byte) (*Info, error) {
+	if err := checkFraming(data); err != nil {
+		return nil, err
+	}
+	return &Info{
+		Version: string(data[len(magic) : len(magic)+2]),
+		Trailer: *parseTrailer(data[len(data)-trailerBytes:]),
+	}, nil
 }
 
-type trailer struct {
-	OffsetBytes int
-	RefBytes    int
-	NumObjects  int
-	RootObject  int
-	OffsetTable int
+// A Trailer is the fixed-size index at the end of a binary property list.
+// All its fields are reported, including those ignored by the parser.
+type Trailer struct {
+	Unused      [5]byte // reserved, normally zero
+	SortVersion byte    // normally zero
+	OffsetBytes int     // bytes per offset table entry
+	RefBytes    int     // bytes per object reference
+	NumObjects  int     // number of objects in the offset table
+	RootObject  int     // the object ID of the root object
+	OffsetTable int     // the byte offset of the offset table
 }
 
-func (t *trailer) needBytes() int { return t.OffsetBytes * t.NumObjects }
-func (t *trailer) tableEnd() int  { return t.OffsetTable + t.needBytes() }
+func (t *Trailer) needBytes() int { return t.OffsetBytes * t.NumObjects }
+func (t *Trailer) tableEnd() int  { return t.OffsetTable + t.needBytes() }
+
+// check reports whether t describes a valid offset table for an input of the
+// given size. If so, needBytes and tableEnd do not overflow.
+func (t *Trailer) check(size int) error {
+	limit := size - trailerBytes
+	if t.OffsetBytes < 1 || t.OffsetBytes > 8 {
+		return fmt.Errorf("invalid offset size %d", t.OffsetBytes)
+	} else if t.RefBytes < 1 || t.RefBytes > 8 {
+		return fmt.Errorf("invalid reference size %d", t.RefBytes)
+	} else if t.NumObjects < 0 || t.NumObjects > limit/t.OffsetBytes {
+		return fmt.Errorf("invalid object count %d", t.NumObjects)
+	} else if t.OffsetTable < len(magic)+2 || t.OffsetTable > limit || t.tableEnd() > limit {
+		return errors.New("invalid offsets table")
+	}
+	return nil
+}
 
 // parseTrailer unpacks the trailer.
-// Precondition: len(data) == 32
-func parseTrailer(data []byte) *trailer {
-	return &trailer{
+// Precondition: len(data) == trailerBytes
+func parseTrailer(data []byte) *Trailer {
+	t := &Trailer{
+		SortVersion: data[5],
 		OffsetBytes: int(data[6]),
 		RefBytes:    int(data[7]),
 		NumObjects:  int(binary.BigEndian.Uint64(data[8:])),
 		RootObject:  int(binary.BigEndian.Uint64(data[16:])),
 		OffsetTable: int(binary.BigEndian.Uint64(data[24:])),
 	}
+	copy(t.Unused[:], data[:5])
+	return t
+}
+
+// decodeOffsets decodes the offset table into dst, where each entry has the
+// given width in bytes.
+// Precondition: len(table) == width*len(dst)
+func decodeOffsets(dst []int, table []byte, width int) {
+	switch width {
+	case 1:
+		for i := range dst {
+			dst[i] = int(table[i])
+		}
+	case 2:
+		for i := range dst {
+			dst[i] = int(binary.BigEndian.Uint16(table[2*i:]))
+		}
+	case 4:
+		for i := range dst {
+			dst[i] = int(binary.BigEndian.Uint32(table[4*i:]))
+		}
+	case 8:
+		for i := range dst {
+			dst[i] = int(binary.BigEndian.Uint64(table[8*i:]))
+		}
+	default:
+		for i := range dst {
+			dst[i] = int(parseInt(table[width*i : width*(i+1)]))
+		}
+	}
 }
 
 func parseInt(data []byte) (v int64) {
@@ -305,16 +1267,78 @@ func parseInt(data []byte) (v int64) {
 	return
 }
 
+// isMinimalInt reports whether the big-endian unsigned value in buf could not
+// be encoded in half as many bytes. A 16-byte value is minimal if it does not
+// fit in a signed 8-byte value; an 8-byte value is always minimal if negative.
+func isMinimalInt(buf []byte) bool {
+	switch len(buf) {
+	case 1:
+		return true
+	case 16:
+		return binary.BigEndian.Uint64(buf) != 0 || buf[8]&0x80 != 0
+	case 8:
+		if buf[0]&0x80 != 0 {
+			return true
+		}
+	}
+	for _, b := range buf[:len(buf)/2] {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func parseFloat(data []byte) float64 {
 	return math.Float64frombits(uint64(parseInt(data)))
 }
 
-func sizeAndShift(tag byte, data []byte) (nb, offset int) {
-	nb = int(tag & 0xf)
-	if nb == 15 {
-		size := 1 << int(data[0]&0xf)
-		nb = int(parseInt(data[1 : 1+size]))
-		offset = 1 + size
+// parseDate converts a date in seconds since the Core Data epoch to a time in
+// UTC, keeping any fractional seconds to the nearest nanosecond.
+func parseDate(sec float64) time.Time {
+	whole := math.Floor(sec)
+	nsec := int64(math.Round((sec - whole) * 1e9))
+	return time.Unix(int64(whole)+macEpoch, nsec).In(time.UTC)
+}
+
+// checkFraming reports whether data is long enough to be a binary property
+// list and begins with the expected magic number. If o.Recover is set, only
+// the magic number is required.
+func (o ParseOptions) checkFraming(data []byte) error {
+	if err := checkFraming(data); err != nil && !(o.Recover && bytes.HasPrefix(data, []byte(magic))) {
+		return err
 	}
-	return
+	return nil
+}
+
+// checkFraming reports whether data is long enough to be a binary property
+// list and begins with the expected magic number.
+func checkFraming(data []byte) error { return checkHeader(data, len(data)) }
+
+// checkHeader reports whether an input of the given size, beginning with
+// head, is long enough to be a binary property list and begins with the
+// expected magic number.
+func checkHeader(head []byte, size int) error {
+	if !bytes.HasPrefix(head, []byte(magic)) {
+		return structErr(0, errors.New("invalid magic number"))
+	} else if size < len(magic)+2+trailerBytes {
+		return structErr(-1, errors.New("invalid file structure"))
+	}
+	return nil
+}
+
+// readerAtParser returns a parser that reads its input from r, which has the
+// given size, after checking its framing.
+func readerAtParser(r io.ReaderAt, size int64) (*parser, error) {
+	if size < 0 || int64(int(size)) != size {
+		return nil, fmt.Errorf("invalid input size %d", size)
+	}
+	p := &parser{ra: r, size: int(size)}
+	head, err := p.slice(0, min(p.size, len(magic)))
+	if err != nil {
+		return nil, err
+	} else if err := checkHeader(head, p.size); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
@@ -16,11 +16,22 @@ package bplist_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/creachadair/bplist"
 )
@@ -92,34 +103,1268 @@ func TestBuilder(t *testing.T) {
 	}
 }
 
+func TestBuilderBytes(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TString, "a")
+		b.Value(bplist.TInteger, 1)
+	})
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	got, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	if !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("Bytes: got %x, want %x", got, buf.Bytes())
+	}
+
+	// An incomplete property list reports an error and no data.
+	b.Reset()
+	if got, err := b.Bytes(); err == nil || got != nil {
+		t.Errorf("Bytes of empty builder: got (%x, %v), want (nil, error)", got, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	if err := bplist.RoundTrip([]byte(testInput)); err != nil {
+		t.Errorf("RoundTrip testInput: %v", err)
+	}
+
+	input := mkPlist(
+		[]byte{0xa5, 1, 2, 3, 4, 1},                         // array of 5 elements
+		[]byte{0x09},                                        // true
+		[]byte{0x62, 0x26, 0x03, 0x00, 0x61},                // unicode "☃a"
+		[]byte{0xd1, 5, 6},                                  // dict
+		[]byte{0x33, 0x41, 0xc2, 0x6e, 0x24, 0x40, 0, 0, 0}, // date
+		[]byte{0x53, 'k', 'e', 'y'},                         // string "key"
+		[]byte{0x42, 0xca, 0xfe},                            // data
+	)
+	if err := bplist.RoundTrip(input); err != nil {
+		t.Errorf("RoundTrip: %v", err)
+	}
+
+	if err := bplist.RoundTrip(mkPlist([]byte{0xa1, 7})); err == nil {
+		t.Error("RoundTrip invalid input: got nil, wanted an error")
+	}
+}
+
+func TestOrderedSet(t *testing.T) {
+	input := mkPlist(
+		[]byte{0xa2, 1, 2}, // array of 2 elements
+		[]byte{0xb1, 3},    // ordered set of 1 element
+		[]byte{0xc1, 3},    // set of 1 element
+		[]byte{0x51, 'x'},  // string "x"
+	)
+	var buf bytes.Buffer
+	if err := bplist.Parse(input, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<array size=2><orderedset size=1>(string=x)</orderedset>` +
+		`<set size=1>(string=x)</set></array>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+
+	// Verify that the builder preserves the distinction.
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Open(bplist.OrderedSet, func(b *bplist.Builder) { b.Value(bplist.TString, "x") })
+		b.Open(bplist.Set, func(b *bplist.Builder) { b.Value(bplist.TString, "x") })
+	})
+	var enc bytes.Buffer
+	if _, err := b.WriteTo(&enc); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	buf.Reset()
+	if err := bplist.Parse(enc.Bytes(), testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+}
+
+func TestBuilderSharing(t *testing.T) {
+	tests := []struct {
+		opts bplist.BuilderOptions
+		want int // number of objects
+	}{
+		// root, 2 empty arrays, null, true
+		{bplist.BuilderOptions{}, 5},
+		{bplist.BuilderOptions{ShareEmpty: true}, 4},
+		{bplist.BuilderOptions{DistinctSingletons: true}, 7},
+		{bplist.BuilderOptions{ShareEmpty: true, DistinctSingletons: true}, 6},
+		{bplist.BuilderOptions{ShareCollections: true}, 4},
+	}
+	for _, tc := range tests {
+		b := bplist.NewBuilder()
+		b.SetOptions(&tc.opts)
+		b.Open(bplist.Array, func(b *bplist.Builder) {
+			b.Open(bplist.Array, func(*bplist.Builder) {})
+			b.Open(bplist.Array, func(*bplist.Builder) {})
+			b.Value(bplist.TNull, nil)
+			b.Value(bplist.TNull, nil)
+			b.Value(bplist.TBool, true)
+			b.Value(bplist.TBool, true)
+		})
+		var buf bytes.Buffer
+		if _, err := b.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+		info, err := bplist.ReadInfo(buf.Bytes())
+		if err != nil {
+			t.Fatalf("ReadInfo failed: %v", err)
+		}
+		if got := info.Trailer.NumObjects; got != tc.want {
+			t.Errorf("Options %+v: got %d objects, want %d", tc.opts, got, tc.want)
+		}
+		if err := bplist.RoundTrip(buf.Bytes()); err != nil {
+			t.Errorf("Options %+v: RoundTrip: %v", tc.opts, err)
+		}
+	}
+}
+
+func TestBuilderShareCollections(t *testing.T) {
+	build := func(opts bplist.BuilderOptions) []byte {
+		b := bplist.NewBuilder()
+		b.SetOptions(&opts)
+		b.Open(bplist.Array, func(b *bplist.Builder) {
+			for range 3 {
+				b.Open(bplist.Dict, func(b *bplist.Builder) {
+					b.Value(bplist.TString, "k")
+					b.Open(bplist.Array, func(b *bplist.Builder) { b.Value(bplist.TInteger, 1) })
+				})
+			}
+			b.Open(bplist.Set, func(b *bplist.Builder) { b.Value(bplist.TInteger, 1) })
+		})
+		data, err := b.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		return data
+	}
+	tests := []struct {
+		opts bplist.BuilderOptions
+		want int // number of objects
+	}{
+		// root, 3 dicts, "k", 3 arrays, 1, set
+		{bplist.BuilderOptions{}, 10},
+		// root, dict, "k", array, 1, set
+		{bplist.BuilderOptions{ShareCollections: true}, 6},
+	}
+	for _, tc := range tests {
+		data := build(tc.opts)
+		info, err := bplist.ReadInfo(data)
+		if err != nil {
+			t.Fatalf("ReadInfo failed: %v", err)
+		}
+		if got := info.Trailer.NumObjects; got != tc.want {
+			t.Errorf("Options %+v: got %d objects, want %d", tc.opts, got, tc.want)
+		}
+		if err := bplist.RoundTrip(data); err != nil {
+			t.Errorf("Options %+v: RoundTrip: %v", tc.opts, err)
+		}
+	}
+}
+
+func TestBuilderSortKeys(t *testing.T) {
+	for _, implicit := range []bool{false, true} {
+		b := bplist.NewBuilder()
+		opts := bplist.BuilderOptions{SortKeys: true}
+		if implicit {
+			opts.ImplicitRoot = bplist.Dict
+		}
+		b.SetOptions(&opts)
+		add := func(b *bplist.Builder) {
+			b.Value(bplist.TString, "b")
+			b.Value(bplist.TInteger, 1)
+			b.Value(bplist.TInteger, 5) // not a string
+			b.Value(bplist.TInteger, 2)
+			b.Value(bplist.TString, "a")
+			b.Open(bplist.Dict, func(b *bplist.Builder) {
+				b.Value(bplist.TString, "y")
+				b.Value(bplist.TInteger, 3)
+				b.Value(bplist.TString, "x")
+				b.Value(bplist.TInteger, 4)
+			})
+		}
+		if implicit {
+			add(b)
+		} else {
+			b.Open(bplist.Dict, add)
+		}
+		data, err := b.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		var buf strings.Builder
+		if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		const want = `V"00"<dict size=3>(string=a)<dict size=2>(string=x)(int=4)(string=y)(int=3)</dict>` +
+			`(string=b)(int=1)(int=5)(int=2)</dict>`
+		if got := buf.String(); got != want {
+			t.Errorf("Implicit %v: got %s, want %s", implicit, got, want)
+		}
+	}
+}
+
+func TestBuilderStrictKeys(t *testing.T) {
+	opts := bplist.BuilderOptions{StrictKeys: true}
+	b := bplist.NewBuilder()
+	b.SetOptions(&opts)
+	b.Open(bplist.Dict, func(b *bplist.Builder) {
+		b.Value(bplist.TString, "ok")
+		b.Value(bplist.TInteger, 1)
+		b.Value(bplist.TInteger, 2) // not a string
+		b.Value(bplist.TInteger, 3)
+	})
+	if err := b.Err(); err == nil {
+		t.Error("Close with a non-string key: got nil, wanted an error")
+	} else {
+		t.Logf("Got expected error: %v", err)
+	}
+
+	opts.ImplicitRoot = bplist.Dict
+	b = bplist.NewBuilder()
+	b.SetOptions(&opts)
+	b.Open(bplist.Array, func(*bplist.Builder) {})
+	b.Value(bplist.TBool, true)
+	if _, err := b.Bytes(); err == nil {
+		t.Error("Implicit root with a non-string key: got nil, wanted an error")
+	}
+
+	// Without the option, non-string keys are accepted.
+	b = bplist.NewBuilder()
+	b.Open(bplist.Dict, func(b *bplist.Builder) {
+		b.Value(bplist.TInteger, 2)
+		b.Value(bplist.TInteger, 3)
+	})
+	if _, err := b.Bytes(); err != nil {
+		t.Errorf("Bytes: unexpected error: %v", err)
+	}
+}
+
+func TestBuilderOpenColl(t *testing.T) {
+	b := bplist.NewBuilder()
+	d := b.OpenColl(bplist.Dict)
+	b.Key("list")
+	c := b.OpenColl(bplist.Array)
+	for i := range 3 {
+		b.Value(bplist.TInteger, i)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close array: unexpected error: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close dict: unexpected error: %v", err)
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	var buf strings.Builder
+	if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<dict size=1>(string=list)<array size=3>(int=0)(int=1)(int=2)</array></dict>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+
+	// Closing out of order is an error.
+	b = bplist.NewBuilder()
+	b.OpenColl(bplist.Dict)
+	b.OpenColl(bplist.Array)
+	if err := b.CloseColl(bplist.Dict); err == nil {
+		t.Error("CloseColl(Dict) with an open array: got nil, wanted an error")
+	}
+}
+
+func TestBuilderPair(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Open(bplist.Dict, func(b *bplist.Builder) {
+		if err := b.Pair("a", bplist.TInteger, 1); err != nil {
+			t.Errorf("Pair: unexpected error: %v", err)
+		}
+		if err := b.Key("b"); err != nil {
+			t.Errorf("Key: unexpected error: %v", err)
+		}
+		b.Open(bplist.Array, func(b *bplist.Builder) { b.Value(bplist.TBool, true) })
+	})
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	var buf strings.Builder
+	if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<dict size=2>(string=a)(int=1)(string=b)<array size=1>(bool=true)</array></dict>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		name string
+		add  func(*bplist.Builder) error
+	}{
+		{"NoDict", func(b *bplist.Builder) error { return b.Key("x") }},
+		{"InArray", func(b *bplist.Builder) (err error) {
+			b.Open(bplist.Array, func(b *bplist.Builder) { err = b.Key("x") })
+			return
+		}},
+		{"KeyKey", func(b *bplist.Builder) (err error) {
+			b.Open(bplist.Dict, func(b *bplist.Builder) {
+				b.Key("x")
+				err = b.Pair("y", bplist.TNull, nil)
+			})
+			return
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.add(bplist.NewBuilder()); err == nil {
+				t.Error("Got nil, wanted an error")
+			}
+		})
+	}
+}
+
 func TestBuilderErrors(t *testing.T) {
 	b := bplist.NewBuilder()
-	if err := b.Err(); err != nil {
-		t.Errorf("Err on new builder: got %v, want nil", err)
+	if err := b.Err(); err != nil {
+		t.Errorf("Err on new builder: got %v, want nil", err)
+	}
+
+	t.Run("ResetClearsErr", func(t *testing.T) {
+		b.Reset()
+		if err := b.Err(); err != nil {
+			t.Errorf("After reset: got err %v", err)
+		}
+	})
+
+	t.Run("BadValue", func(t *testing.T) {
+		err := b.Value(bplist.TString, 101)
+		if err == nil {
+			t.Error("Value: got nil, wanted an error")
+		}
+	})
+}
+
+func TestBuilderRollback(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TInteger, 1)
+		c := b.Checkpoint()
+
+		// A failed speculative section is discarded, along with its error.
+		b.Open(bplist.Dict, func(b *bplist.Builder) {
+			b.Value(bplist.TString, "x")
+			if err := b.Value(bplist.TString, 101); err == nil {
+				t.Error("Value: got nil, wanted an error")
+			}
+		})
+		b.Rollback(c)
+		if err := b.Err(); err != nil {
+			t.Errorf("Err after Rollback: got %v, want nil", err)
+		}
+		b.Value(bplist.TInteger, 2)
+	})
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	var buf strings.Builder
+	if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<array size=2>(int=1)(int=2)</array>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+	if info, err := bplist.ReadInfo(data); err != nil {
+		t.Errorf("ReadInfo failed: %v", err)
+	} else if got := info.Trailer.NumObjects; got != 3 {
+		t.Errorf("Got %d objects, want 3", got)
+	}
+}
+
+func TestBuilderClone(t *testing.T) {
+	parse := func(b *bplist.Builder) string {
+		t.Helper()
+		data, err := b.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		var buf strings.Builder
+		if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		return buf.String()
+	}
+
+	b := bplist.NewBuilder()
+	b.SetOptions(&bplist.BuilderOptions{ImplicitRoot: bplist.Dict})
+	b.Value(bplist.TString, "common")
+	b.Value(bplist.TBool, true)
+
+	c := b.Clone()
+	b.Value(bplist.TString, "b")
+	b.Value(bplist.TInteger, 1)
+	c.Value(bplist.TString, "c")
+	c.Value(bplist.TInteger, 2)
+
+	const wantB = `V"00"<dict size=2>(string=common)(bool=true)(string=b)(int=1)</dict>`
+	if got := parse(b); got != wantB {
+		t.Errorf("Original: got %s, want %s", got, wantB)
+	}
+	const wantC = `V"00"<dict size=2>(string=common)(bool=true)(string=c)(int=2)</dict>`
+	if got := parse(c); got != wantC {
+		t.Errorf("Clone: got %s, want %s", got, wantC)
+	}
+}
+
+func TestBuilderEmbed(t *testing.T) {
+	frag := bplist.NewBuilder()
+	frag.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TString, "x")
+		b.Value(bplist.TInteger, 1)
+	})
+
+	b := bplist.NewBuilder()
+	b.Open(bplist.Dict, func(b *bplist.Builder) {
+		b.Key("a")
+		if err := b.Embed(frag); err != nil {
+			t.Errorf("Embed: unexpected error: %v", err)
+		}
+		b.Key("b")
+		if err := b.EmbedValue(bplist.NewDict(bplist.Entry{Key: "y", Value: bplist.NewValue(bplist.TBool, true)})); err != nil {
+			t.Errorf("EmbedValue: unexpected error: %v", err)
+		}
+	})
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	var buf strings.Builder
+	if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<dict size=2>(string=a)<array size=2>(string=x)(int=1)</array>` +
+		`(string=b)<dict size=1>(string=y)(bool=true)</dict></dict>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+
+	// An incomplete builder cannot be embedded.
+	if err := bplist.NewBuilder().Embed(bplist.NewBuilder()); err == nil {
+		t.Error("Embed empty builder: got nil, wanted an error")
+	}
+
+	// Changes to the source after Embed do not affect the result.
+	src := bplist.NewBuilder()
+	src.SetOptions(&bplist.BuilderOptions{ImplicitRoot: bplist.Array})
+	src.Value(bplist.TString, "x")
+	c := src.Checkpoint()
+	src.Value(bplist.TString, "y")
+	dst := bplist.NewBuilder()
+	if err := dst.Embed(src); err != nil {
+		t.Fatalf("Embed: unexpected error: %v", err)
+	}
+	src.Rollback(c)
+	src.Value(bplist.TString, "z")
+	data, err = dst.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	const wantCopy = `V"00"<array size=2>(string=x)(string=y)</array>`
+	if got := parseString(t, data); got != wantCopy {
+		t.Errorf("Parse after changing source: got %s, want %s", got, wantCopy)
+	}
+}
+
+// limitWriter is an io.Writer that accepts up to n bytes, then fails.
+type limitWriter struct{ n int }
+
+func (w *limitWriter) Write(data []byte) (int, error) {
+	if len(data) > w.n {
+		nw := w.n
+		w.n = 0
+		return nw, errors.New("write limit exceeded")
+	}
+	w.n -= len(data)
+	return len(data), nil
+}
+
+func TestBuilderWriteTo(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		for i := range 5000 {
+			b.Value(bplist.TInteger, i)
+		}
+	})
+	var buf bytes.Buffer
+	n, err := b.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	} else if n != int64(buf.Len()) {
+		t.Errorf("WriteTo: reported %d bytes, wrote %d", n, buf.Len())
+	}
+	if err := bplist.RoundTrip(buf.Bytes()); err != nil {
+		t.Errorf("RoundTrip: %v", err)
+	}
+	if size, err := b.EncodedSize(); err != nil {
+		t.Errorf("EncodedSize failed: %v", err)
+	} else if size != n {
+		t.Errorf("EncodedSize: got %d, want %d", size, n)
+	}
+	if _, err := bplist.NewBuilder().EncodedSize(); err == nil {
+		t.Error("EncodedSize of empty builder: got nil, wanted an error")
+	}
+
+	// A write error is reported with the number of bytes written.
+	const limit = 10000
+	n, err = b.WriteTo(&limitWriter{n: limit})
+	if err == nil {
+		t.Error("WriteTo with limit: got nil, wanted an error")
+	} else if n != limit {
+		t.Errorf("WriteTo with limit: reported %d bytes, want %d", n, limit)
+	}
+}
+
+func TestBuilderAccumulateErrors(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.SetOptions(&bplist.BuilderOptions{AccumulateErrors: true})
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TString, 101)
+		b.Value(bplist.TInteger, 1)
+		b.Key("x")
+		b.Open(bplist.Dict, func(b *bplist.Builder) {
+			b.Value(bplist.TString, "dangling")
+		})
+	})
+	err := b.Err()
+	if err == nil {
+		t.Fatal("Err: got nil, wanted errors")
+	}
+	for _, want := range []string{"invalid datum", "outside a dictionary", "missing value"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Err: got %q, want it to mention %q", err, want)
+		}
+	}
+	if _, werr := b.Bytes(); werr == nil || werr.Error() != err.Error() {
+		t.Errorf("Bytes: got %v, want %v", werr, err)
+	}
+}
+
+func TestBuilderUID(t *testing.T) {
+	// Each UID is encoded with the fewest of 1, 2, 4, or 8 bytes.
+	widths := []struct {
+		input []byte
+		want  []byte
+	}{
+		{[]byte{5}, []byte{0x80, 5}},
+		{[]byte{0, 0, 0, 5}, []byte{0x80, 5}},
+		{[]byte{1, 0}, []byte{0x81, 1, 0}},
+		{[]byte{0, 0xff, 0xff}, []byte{0x81, 0xff, 0xff}},
+		{[]byte{1, 0, 0}, []byte{0x83, 0, 1, 0, 0}},
+		{[]byte{1, 2, 3, 4}, []byte{0x83, 1, 2, 3, 4}},
+		{[]byte{1, 0, 0, 0, 0}, []byte{0x87, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{0x87, 1, 2, 3, 4, 5, 6, 7, 8}},
+	}
+	for _, tc := range widths {
+		b := bplist.NewBuilder()
+		b.Value(bplist.TUID, tc.input)
+		data, err := b.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes: unexpected error: %v", err)
+		}
+		if got := data[8 : 8+len(tc.want)]; !bytes.Equal(got, tc.want) {
+			t.Errorf("UID %x: got %x, want %x", tc.input, got, tc.want)
+		}
+	}
+
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TUID, []byte{5})
+		b.Value(bplist.TUID, []byte{1, 0})
+	})
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: unexpected error: %v", err)
+	}
+	if !bytes.Contains(data, []byte{0x80, 5}) || !bytes.Contains(data, []byte{0x81, 1, 0}) {
+		t.Errorf("Encoded UIDs not found in %q", data)
+	}
+
+	var buf strings.Builder
+	if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	const want = `V"00"<array size=2>(uid=1 bytes)(uid=2 bytes)</array>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+
+	for _, bad := range [][]byte{nil, make([]byte, 9), make([]byte, 16)} {
+		b.Reset()
+		if err := b.Value(bplist.TUID, bad); err == nil {
+			t.Errorf("Value(TUID, %d bytes): got nil, wanted an error", len(bad))
+		}
+	}
+}
+
+func TestBuilderVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string // expected version; "" means an error
+	}{
+		{"", "00"},
+		{"00", "00"},
+		{"01", "01"},
+		{"0x", "0x"},
+		{"1", ""},
+		{"15", ""},
+		{"0\n", ""},
+	}
+	for _, tc := range tests {
+		b := bplist.NewBuilder()
+		b.SetOptions(&bplist.BuilderOptions{Version: tc.version})
+		b.Value(bplist.TBool, true)
+
+		var buf bytes.Buffer
+		_, err := b.WriteTo(&buf)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("Version %q: got success, wanted an error", tc.version)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("Version %q: WriteTo failed: %v", tc.version, err)
+			continue
+		}
+		var got string
+		if err := bplist.Parse(buf.Bytes(), testHandler{
+			log: t.Logf,
+			buf: io.Discard,
+			ver: &got,
+		}); err != nil {
+			t.Errorf("Version %q: Parse failed: %v", tc.version, err)
+		} else if got != tc.want {
+			t.Errorf("Version %q: got %q, want %q", tc.version, got, tc.want)
+		}
+	}
+}
+
+func TestTrailerInfo(t *testing.T) {
+	info, err := bplist.ReadInfo([]byte(testInput))
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+	want := bplist.Info{
+		Version: "00",
+		Trailer: bplist.Trailer{
+			OffsetBytes: 1,
+			RefBytes:    1,
+			NumObjects:  3,
+			RootObject:  0,
+			OffsetTable: 40,
+		},
+	}
+	if *info != want {
+		t.Errorf("ReadInfo: got %+v, want %+v", *info, want)
+	}
+
+	// Verify that the reserved trailer fields written by the Builder are
+	// reported by ReadInfo.
+	b := bplist.NewBuilder()
+	b.SetOptions(&bplist.BuilderOptions{
+		TrailerUnused: [5]byte{1, 2, 3, 4, 5},
+		SortVersion:   6,
+	})
+	b.Value(bplist.TString, "ok")
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	info, err = bplist.ReadInfo(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ReadInfo failed: %v", err)
+	}
+	if got := info.Trailer.Unused; got != [5]byte{1, 2, 3, 4, 5} {
+		t.Errorf("Unused: got %v, want [1 2 3 4 5]", got)
+	}
+	if got := info.Trailer.SortVersion; got != 6 {
+		t.Errorf("SortVersion: got %d, want 6", got)
+	}
+
+	// By default the reserved bytes are ignored, but strict mode rejects them.
+	h := testHandler{log: t.Logf, buf: io.Discard}
+	if err := bplist.Parse(buf.Bytes(), h); err != nil {
+		t.Errorf("Parse: unexpected error: %v", err)
+	}
+	if err := (bplist.ParseOptions{Strict: true}).Parse(buf.Bytes(), h); err == nil {
+		t.Error("Parse strict: got nil, wanted an error")
+	}
+}
+
+func TestBuilderBool(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TBool, true)
+		b.Value(bplist.TBool, false)
+	})
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := bplist.Parse(buf.Bytes(), testHandler{log: t.Logf, buf: &out}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<array size=2>(bool=true)(bool=false)</array>`
+	if got := out.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+}
+
+func TestBuilderNested(t *testing.T) {
+	// Elements added after a nested collection is closed must not replace
+	// the contents of that collection.
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Open(bplist.Array, func(b *bplist.Builder) {
+			b.Value(bplist.TInteger, 1)
+			b.Value(bplist.TInteger, 2)
+		})
+		b.Value(bplist.TString, "x")
+		b.Value(bplist.TString, "y")
+	})
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := bplist.Parse(buf.Bytes(), testHandler{log: t.Logf, buf: &out}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<array size=3><array size=2>(int=1)(int=2)</array>(string=x)(string=y)</array>`
+	if got := out.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+}
+
+func TestBuilderReals(t *testing.T) {
+	// Reals whose bit patterns are small must still be encoded in 8 bytes.
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TFloat, 0.0)
+		b.Value(bplist.TFloat, 5e-324)
+		b.Value(bplist.TFloat, 1.5)
+	})
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte{0x23, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("Encoded zero not found in %q", buf.Bytes())
+	}
+	var out bytes.Buffer
+	if err := bplist.Parse(buf.Bytes(), testHandler{log: t.Logf, buf: &out}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<array size=3>(float=0)(float=5e-324)(float=1.5)</array>`
+	if got := out.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+}
+
+func TestIntegerSigns(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		unsigned bool
+		want     string
+	}{
+		{[]byte{0x10, 0xff}, false, "(int=255)"},
+		{[]byte{0x11, 0xff, 0xff}, false, "(int=65535)"},
+		{[]byte{0x12, 0xff, 0xff, 0xff, 0xff}, false, "(int=4294967295)"},
+		{[]byte{0x13, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, false, "(int=-2)"},
+		{[]byte{0x13, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, true, "(int=18446744073709551614)"},
+		{[]byte{0x14, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5}, false, "(int=5)"},
+		{[]byte{0x14, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, false,
+			"(int=18446744073709551615)"},
+		{[]byte{0x14, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}, false,
+			"(int=18446744073709551616)"},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		opts := bplist.ParseOptions{UnsignedInt64: tc.unsigned}
+		if err := opts.Parse(mkPlist(tc.input), testHandler{
+			log: t.Logf,
+			buf: &buf,
+		}); err != nil {
+			t.Errorf("Parse %x failed: %v", tc.input, err)
+			continue
+		}
+		if got := buf.String(); got != `V"00"`+tc.want {
+			t.Errorf("Parse %x: got %s, want %s", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestBuilderIntegers(t *testing.T) {
+	big128, _ := new(big.Int).SetString("340282366920938463463374607431768211455", 10) // 2^128-1
+	tests := []struct {
+		datum any
+		tag   byte // the expected tag of the encoding
+		want  string
+	}{
+		{uint(7), 0x10, "(int=7)"},
+		{uint64(math.MaxInt64), 0x13, "(int=9223372036854775807)"},
+		{uint64(math.MaxUint64), 0x14, "(int=18446744073709551615)"},
+		{big.NewInt(-3), 0x13, "(int=-3)"},
+		{big128, 0x14, "(int=340282366920938463463374607431768211455)"},
+		{int8(-2), 0x13, "(int=-2)"},
+		{uint16(300), 0x11, "(int=300)"},
+		{json.Number("-5"), 0x13, "(int=-5)"},
+		{json.Number("18446744073709551616"), 0x14, "(int=18446744073709551616)"},
+	}
+	for _, tc := range tests {
+		b := bplist.NewBuilder()
+		if err := b.Value(bplist.TInteger, tc.datum); err != nil {
+			t.Errorf("Value(%v): unexpected error: %v", tc.datum, err)
+			continue
+		}
+		data, err := b.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes: unexpected error: %v", err)
+		}
+		if tag := data[8]; tag != tc.tag {
+			t.Errorf("Value(%v): got tag %02x, want %02x", tc.datum, tag, tc.tag)
+		}
+		var buf bytes.Buffer
+		if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Fatalf("Parse: unexpected error: %v", err)
+		}
+		if got := buf.String(); got != `V"00"`+tc.want {
+			t.Errorf("Value(%v): got %s, want %s", tc.datum, got, tc.want)
+		}
+	}
+
+	for _, bad := range []any{
+		new(big.Int).Lsh(big.NewInt(1), 128),
+		new(big.Int).Lsh(big.NewInt(-1), 64),
+		json.Number("-9223372036854775809"),
+	} {
+		if err := bplist.NewBuilder().Value(bplist.TInteger, bad); !errors.Is(err, bplist.ErrOverflow) {
+			t.Errorf("Value(%v): got %v, want %v", bad, err, bplist.ErrOverflow)
+		}
+	}
+	if err := bplist.NewBuilder().Value(bplist.TInteger, json.Number("1.5")); err == nil {
+		t.Error("Value(1.5): got nil, wanted an error")
+	} else if errors.Is(err, bplist.ErrOverflow) {
+		t.Errorf("Value(1.5): got %v, wanted a different error", err)
+	}
+}
+
+func TestRealSizes(t *testing.T) {
+	tests := []struct {
+		input []byte
+		want  string // "" means an error is expected
+	}{
+		{[]byte{0x22, 0x3f, 0xc0, 0, 0}, "(float=1.5)"},
+		{[]byte{0x23, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, "(float=1.5)"},
+		{[]byte{0x20, 0x01}, ""},
+		{[]byte{0x21, 0x01, 0x02}, ""},
+		{[]byte{0x24, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, ""},
+		{[]byte{0x2f, 0}, ""}, // larger than the input
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		err := bplist.Parse(mkPlist(tc.input), testHandler{log: t.Logf, buf: &buf})
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("Parse %x: got %s, wanted an error", tc.input, buf.String())
+			} else {
+				t.Logf("Parse %x: got expected error: %v", tc.input, err)
+			}
+		} else if err != nil {
+			t.Errorf("Parse %x failed: %v", tc.input, err)
+		} else if got := buf.String(); got != `V"00"`+tc.want {
+			t.Errorf("Parse %x: got %s, want %s", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestBuilderIntWidth(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.SetOptions(&bplist.BuilderOptions{IntWidth: 8})
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TInteger, 5)
+		b.Value(bplist.TInteger, -1)
+	})
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	if !bytes.Contains(data, []byte{0x13, 0, 0, 0, 0, 0, 0, 0, 5}) {
+		t.Errorf("Fixed-width integer not found in %q", data)
+	}
+	if err := bplist.RoundTrip(data); err != nil {
+		t.Errorf("RoundTrip: %v", err)
+	}
+
+	b.SetOptions(&bplist.BuilderOptions{IntWidth: 3})
+	if _, err := b.Bytes(); err == nil {
+		t.Error("Bytes with IntWidth 3: got nil, wanted an error")
+	}
+}
+
+func TestBuilderUIDWidth(t *testing.T) {
+	tests := []struct {
+		width int
+		id    []byte
+		want  []byte
+	}{
+		{0, []byte{5}, []byte{0x80, 5}},
+		{1, []byte{5}, []byte{0x80, 5}},
+		{2, []byte{5}, []byte{0x81, 0, 5}},
+		{4, []byte{5}, []byte{0x83, 0, 0, 0, 5}},
+		{8, []byte{5}, []byte{0x87, 0, 0, 0, 0, 0, 0, 0, 5}},
+
+		// A value too wide for the pinned width uses a wider encoding.
+		{2, []byte{1, 0, 0}, []byte{0x83, 0, 1, 0, 0}},
+	}
+	for _, tc := range tests {
+		b := bplist.NewBuilder()
+		b.SetOptions(&bplist.BuilderOptions{UIDWidth: tc.width})
+		b.Value(bplist.TUID, tc.id)
+		data, err := b.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		if got := data[8 : 8+len(tc.want)]; !bytes.Equal(got, tc.want) {
+			t.Errorf("UIDWidth %d, UID %x: got %x, want %x", tc.width, tc.id, got, tc.want)
+		}
+	}
+
+	b := bplist.NewBuilder()
+	b.SetOptions(&bplist.BuilderOptions{UIDWidth: 3})
+	b.Value(bplist.TUID, []byte{5})
+	if _, err := b.Bytes(); err == nil {
+		t.Error("Bytes with UIDWidth 3: got nil, wanted an error")
 	}
+}
 
-	t.Run("ResetClearsErr", func(t *testing.T) {
-		b.Reset()
-		if err := b.Err(); err != nil {
-			t.Errorf("After reset: got err %v", err)
+func TestBuilderFloat32(t *testing.T) {
+	// A float32 and a float64 with the same value are encoded separately.
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TFloat, float32(1.5))
+		b.Value(bplist.TFloat, 1.5)
+	})
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: unexpected error: %v", err)
+	}
+	if !bytes.Contains(data, []byte{0x22, 0x3f, 0xc0, 0, 0}) {
+		t.Errorf("Encoded 4-byte real not found in %q", data)
+	}
+	if !bytes.Contains(data, []byte{0x23, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("Encoded 8-byte real not found in %q", data)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	// [<unknown 0x93 object>, 5]
+	input := mkPlist([]byte{0xa2, 1, 2}, []byte{0x93, 1, 2, 3}, []byte{0x10, 0x05})
+	if err := bplist.Parse(input, nopHandler{}); err == nil {
+		t.Error("Parse without Raw: got nil, wanted an error")
+	}
+
+	opts := bplist.ParseOptions{Raw: true}
+	var buf strings.Builder
+	if err := opts.Parse(input, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<array size=2>(raw=4 bytes)(int=5)</array>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+
+	// The raw object passes through a Builder unchanged.
+	b := bplist.NewBuilder()
+	if err := opts.Parse(input, bplist.BuilderHandler(b)); err != nil {
+		t.Fatalf("Parse to builder failed: %v", err)
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	if !bytes.Contains(data, []byte{0x93, 1, 2, 3}) {
+		t.Errorf("Raw object not found in %q", data)
+	}
+	buf.Reset()
+	if err := opts.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse output failed: %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("Parse output: got %s, want %s", got, want)
+	}
+
+	for _, bad := range [][]byte{nil, {0xa0}} {
+		if err := bplist.NewBuilder().Value(bplist.TRaw, bad); err == nil {
+			t.Errorf("Value(TRaw, %x): got nil, wanted an error", bad)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	type point struct{ X, Y byte }
+
+	input := mkPlist(
+		[]byte{0xa3, 1, 2, 3},  // array of 3 elements
+		[]byte{0x10, 0x19},     // int 25
+		[]byte{0x42, 0x0a, 20}, // data [10 20]
+		[]byte{0x51, 'x'},      // string "x"
+	)
+	opts := bplist.ParseOptions{
+		Transform: map[bplist.Type]func(any) (any, error){
+			bplist.TInteger: func(v any) (any, error) { return v.(int64) * 2, nil },
+			bplist.TBytes: func(v any) (any, error) {
+				b := v.([]byte)
+				return point{X: b[0], Y: b[1]}, nil
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := opts.Parse(input, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<array size=3>(int=50)(bytes={10 20})(string=x)</array>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+
+	opts.Transform[bplist.TString] = func(any) (any, error) { return nil, errors.New("bogus") }
+	if err := opts.Parse(input, testHandler{log: t.Logf, buf: io.Discard}); err == nil {
+		t.Error("Parse: got nil, wanted an error from the transform")
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	input := mkPlist(
+		[]byte{0xa4, 1, 2, 9, 3}, // array of 4 elements, one bad ref
+		[]byte{0x10, 0x19},       // int 25
+		[]byte{0x21, 0, 0},       // invalid real size
+		[]byte{0x51, 'x'},        // string "x"
+	)
+
+	// By default, the first problem stops the parse.
+	if err := bplist.Parse(input, testHandler{log: t.Logf, buf: io.Discard}); err == nil {
+		t.Error("Parse: got nil, wanted an error")
+	}
+
+	var buf bytes.Buffer
+	opts := bplist.ParseOptions{ContinueOnError: true}
+	err := opts.Parse(input, testHandler{log: t.Logf, buf: &buf})
+	if err == nil {
+		t.Fatal("Parse: got nil, wanted an error")
+	}
+	if errs := err.(interface{ Unwrap() []error }).Unwrap(); len(errs) != 2 {
+		t.Errorf("Parse: got %d errors, want 2: %v", len(errs), err)
+	}
+	const want = `V"00"<array size=4>(int=25)(null=<nil>)(null=<nil>)(string=x)</array>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	input := mkPlist(
+		[]byte{0xd2, 1, 1, 2, 3},             // dict with a duplicate key
+		[]byte{0x51, 'k'},                    // string "k"
+		[]byte{0x11, 0x00, 0x05},             // non-minimal int 5
+		[]byte{0x4f, 0x10, 0x01, 0xff},       // non-minimal data size
+		[]byte{0x12, 0x00, 0x00, 0x01, 0x00}, // unreachable object
+	)
+	var warnings []string
+	opts := bplist.ParseOptions{
+		Warn: func(w bplist.Warning) { warnings = append(warnings, w.String()) },
+	}
+	if err := opts.Parse(input, testHandler{log: t.Logf, buf: io.Discard}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := []string{
+		"object 0 at offset 8: duplicate key reference to object 1",
+		"object 2 at offset 15: non-minimal 2-byte integer encoding",
+		"object 3 at offset 18: non-minimal size encoding for size 1",
+		"object 4 at offset 22: object is not reachable from the root",
+	}
+	if !slices.Equal(warnings, want) {
+		t.Errorf("Warnings:\ngot  %q\nwant %q", warnings, want)
+	}
+}
+
+func TestZeroCopy(t *testing.T) {
+	const n = 100
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		for i := 0; i < n; i++ {
+			b.Value(bplist.TString, fmt.Sprintf("string value %d", i))
+			b.Value(bplist.TUnicode, fmt.Sprintf("\u2603 value %d", i))
 		}
 	})
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	data := buf.Bytes()
 
-	t.Run("BadValue", func(t *testing.T) {
-		err := b.Value(bplist.TString, 101)
+	// Verify that strings are delivered as slices.
+	opts := bplist.ParseOptions{ZeroCopy: true}
+	check := opts
+	check.Transform = make(map[bplist.Type]func(any) (any, error))
+	for _, typ := range []bplist.Type{bplist.TString, bplist.TUnicode} {
+		check.Transform[typ] = func(v any) (any, error) {
+			if _, ok := v.([]byte); !ok {
+				t.Errorf("Datum for %v: got %T, want []byte", typ, v)
+			}
+			return v, nil
+		}
+	}
+	if err := check.Parse(data, nopHandler{}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Each element should cost at most one allocation, for the interface
+	// conversion of its datum; by default decoding costs more.
+	allocs := testing.AllocsPerRun(10, func() {
+		if err := opts.Parse(data, nopHandler{}); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+	})
+	if perElt := allocs / (2 * n); perElt > 1.1 {
+		t.Errorf("Parse with ZeroCopy: got %.2f allocations per element, want ≤ 1", perElt)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, n := range []int{1 << 10, 1 << 16, 1 << 21} {
+		bld := bplist.NewBuilder()
+		bld.Open(bplist.Array, func(bld *bplist.Builder) {
+			for i := 0; i < n; i++ {
+				bld.Value(bplist.TInteger, i)
+			}
+		})
+		var buf bytes.Buffer
+		if _, err := bld.WriteTo(&buf); err != nil {
+			b.Fatalf("WriteTo failed: %v", err)
+		}
+		data := buf.Bytes()
+
+		b.Run(fmt.Sprintf("Objects-%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := bplist.Parse(data, nopHandler{}); err != nil {
+					b.Fatalf("Parse failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+type nopHandler struct{}
+
+func (nopHandler) Version(string) error              { return nil }
+func (nopHandler) Value(bplist.Type, any) error      { return nil }
+func (nopHandler) Open(bplist.Collection, int) error { return nil }
+func (nopHandler) Close(bplist.Collection) error     { return nil }
+
+// mkPlist constructs a binary property list containing the given encoded
+// objects, with one-byte offsets and references. The root is object 0.
+func mkPlist(objs ...[]byte) []byte {
+	buf := []byte("bplist00")
+	var offsets []byte
+	for _, obj := range objs {
+		offsets = append(offsets, byte(len(buf)))
+		buf = append(buf, obj...)
+	}
+	table := len(buf)
+	buf = append(buf, offsets...)
+	buf = append(buf, 0, 0, 0, 0, 0, 0, 1, 1)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(objs)))
+	buf = binary.BigEndian.AppendUint64(buf, 0)
+	return binary.BigEndian.AppendUint64(buf, uint64(table))
+}
+
+func TestBuilderRoots(t *testing.T) {
+	addValues := func(b *bplist.Builder) {
+		b.Value(bplist.TString, "a")
+		b.Value(bplist.TInteger, 1)
+		b.Open(bplist.Array, func(b *bplist.Builder) {
+			b.Value(bplist.TBool, true)
+		})
+		b.Value(bplist.TString, "b")
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		b := bplist.NewBuilder()
+		addValues(b)
+		_, err := b.WriteTo(io.Discard)
 		if err == nil {
-			t.Error("Value: got nil, wanted an error")
+			t.Fatal("WriteTo: got nil, wanted an error")
+		}
+		const want = `have 4 top-level elements [string(a), int(1), array(1), string(b)], want 1`
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("WriteTo: got error %q, want %q", err, want)
 		}
 	})
+
+	tests := []struct {
+		root bplist.Collection
+		want string
+	}{
+		{bplist.Array, `<array size=4>(string=a)(int=1)<array size=1>(bool=true)</array>(string=b)</array>`},
+		{bplist.Dict, `<dict size=2>(string=a)(int=1)<array size=1>(bool=true)</array>(string=b)</dict>`},
+	}
+	for _, tc := range tests {
+		t.Run("Implicit"+tc.root.String(), func(t *testing.T) {
+			b := bplist.NewBuilder()
+			b.SetOptions(&bplist.BuilderOptions{ImplicitRoot: tc.root})
+			addValues(b)
+
+			var buf bytes.Buffer
+			if _, err := b.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo failed: %v", err)
+			}
+			var out bytes.Buffer
+			if err := bplist.Parse(buf.Bytes(), testHandler{log: t.Logf, buf: &out}); err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if got := out.String(); got != `V"00"`+tc.want {
+				t.Errorf("Parse: got %s, want %s", got, tc.want)
+			}
+		})
+	}
 }
 
 type testHandler struct {
 	log func(string, ...any)
 	buf io.Writer
+	ver *string // if set, receives the version string
 }
 
 func (h testHandler) Version(s string) error {
 	h.log("Version %q", s)
+	if h.ver != nil {
+		*h.ver = s
+	}
 	fmt.Fprintf(h.buf, "V%q", s)
 	return nil
 }
@@ -145,3 +1390,609 @@ func (h testHandler) Close(coll bplist.Collection) error {
 	fmt.Fprintf(h.buf, "</%s>", coll)
 	return nil
 }
+
+type refHandler struct {
+	testHandler
+	seen map[int]bool
+}
+
+func (h refHandler) Ref(id int) (bool, error) {
+	if h.seen[id] {
+		fmt.Fprintf(h.buf, "@%d", id)
+		return true, nil
+	}
+	h.seen[id] = true
+	fmt.Fprintf(h.buf, "#%d", id)
+	return false, nil
+}
+
+func TestRefHandler(t *testing.T) {
+	// ["x", ["x"], "x"], with the strings shared.
+	input := mkPlist([]byte{0xa3, 1, 2, 1}, []byte{0x51, 'x'}, []byte{0xa1, 1})
+
+	var buf strings.Builder
+	h := refHandler{testHandler{log: t.Logf, buf: &buf}, make(map[int]bool)}
+	if err := bplist.Parse(input, h); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	const want = `V"00"#0<array size=3>#1(string=x)#2<array size=1>@1</array>@1</array>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %q, want %q", got, want)
+	}
+
+	t.Run("AllIDs", func(t *testing.T) {
+		var buf strings.Builder
+		h := idHandler{testHandler{log: t.Logf, buf: &buf}}
+		if err := bplist.Parse(input, h); err != nil {
+			t.Fatalf("Parse: unexpected error: %v", err)
+		}
+		const want = `V"00"#0<array size=3>#1(string=x)#2<array size=1>#1(string=x)</array>#1(string=x)</array>`
+		if got := buf.String(); got != want {
+			t.Errorf("Parse: got %q, want %q", got, want)
+		}
+	})
+}
+
+// idHandler records the ID of each object, but does not skip any.
+type idHandler struct{ testHandler }
+
+func (h idHandler) Ref(id int) (bool, error) {
+	fmt.Fprintf(h.buf, "#%d", id)
+	return false, nil
+}
+
+type keyHandler struct{ testHandler }
+
+func (h keyHandler) Key(typ bplist.Type, datum any) error {
+	fmt.Fprintf(h.buf, "[%s=%v]", typ, datum)
+	return nil
+}
+
+func TestKeyHandler(t *testing.T) {
+	// {"a": "b", "c": {"a": 1}}
+	input := mkPlist(
+		[]byte{0xd2, 1, 3, 2, 4},
+		[]byte{0x51, 'a'},
+		[]byte{0x51, 'b'},
+		[]byte{0x51, 'c'},
+		[]byte{0xd1, 1, 5},
+		[]byte{0x10, 0x01},
+	)
+	var buf strings.Builder
+	if err := bplist.Parse(input, keyHandler{testHandler{log: t.Logf, buf: &buf}}); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	const want = `V"00"<dict size=2>[string=a](string=b)[string=c]<dict size=1>[string=a](int=1)</dict></dict>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %q, want %q", got, want)
+	}
+}
+
+type spanHandler struct{ testHandler }
+
+func (h spanHandler) Span(off, n int) error {
+	fmt.Fprintf(h.buf, "{%d+%d}", off, n)
+	return nil
+}
+
+func TestSpanHandler(t *testing.T) {
+	// {"a": ["bc", 1000]}
+	input := mkPlist(
+		[]byte{0xd1, 1, 2},
+		[]byte{0x51, 'a'},
+		[]byte{0xa2, 3, 4},
+		[]byte{0x52, 'b', 'c'},
+		[]byte{0x11, 0x03, 0xe8},
+	)
+	var buf strings.Builder
+	if err := bplist.Parse(input, spanHandler{testHandler{log: t.Logf, buf: &buf}}); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	const want = `V"00"{8+3}<dict size=1>{11+2}(string=a){13+3}<array size=2>` +
+		`{16+3}(string=bc){19+3}(int=1000)</array></dict>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %q, want %q", got, want)
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	input := mkPlist([]byte{0xa3, 1, 1, 1}, []byte{0x09})
+
+	t.Run("OK", func(t *testing.T) {
+		if err := bplist.ParseContext(context.Background(), input, nopHandler{}); err != nil {
+			t.Errorf("ParseContext: unexpected error: %v", err)
+		}
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var n int
+		err := bplist.ParseContext(ctx, input, bplist.HandlerFuncs{
+			ValueFunc: func(bplist.Type, any) error { n++; cancel(); return nil },
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ParseContext: got %v, want %v", err, context.Canceled)
+		}
+		if n != 1 {
+			t.Errorf("ParseContext: got %d values, want 1", n)
+		}
+	})
+	t.Run("Ended", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := bplist.ParseContext(ctx, input, bplist.HandlerFuncs{
+			VersionFunc: func(string) error { t.Error("Version called unexpectedly"); return nil },
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ParseContext: got %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestParseReader(t *testing.T) {
+	data, err := bplist.Marshal(map[string]any{"a": 1, "b": []any{"x", true}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := parseString(t, data)
+
+	path := filepath.Join(t.TempDir(), "test.plist")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Writing test file: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Opening test file: %v", err)
+	}
+	defer f.Close()
+
+	for _, r := range []io.Reader{
+		bytes.NewReader(data),
+		iotest.OneByteReader(bytes.NewReader(data)),
+		f,
+	} {
+		var buf bytes.Buffer
+		if err := bplist.ParseReader(r, testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Errorf("ParseReader %T failed: %v", r, err)
+		} else if got := buf.String(); got != want {
+			t.Errorf("ParseReader %T:\n got %s\nwant %s", r, got, want)
+		}
+	}
+
+	r := iotest.ErrReader(errors.New("bad reader"))
+	if err := bplist.ParseReader(r, nopHandler{}); err == nil {
+		t.Error("ParseReader with failing reader: got nil, wanted an error")
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	var data []byte
+	var lens []int
+	for _, v := range []any{"a", []any{1}, map[string]any{"k": true}} {
+		enc, err := bplist.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal %v: %v", v, err)
+		}
+		data = append(data, enc...)
+		lens = append(lens, len(enc))
+	}
+	const want = `V"00"(string=a)` +
+		`V"00"<array size=1>(int=1)</array>` +
+		`V"00"<dict size=1>(string=k)(bool=true)</dict>`
+
+	t.Run("ParseAll", func(t *testing.T) {
+		var buf strings.Builder
+		if err := bplist.ParseAll(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Fatalf("ParseAll: unexpected error: %v", err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("ParseAll: got %q, want %q", got, want)
+		}
+
+		bad := append(slices.Clone(data), "garbage"...)
+		err := bplist.ParseAll(bad, nopHandler{})
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("offset %d", len(data))) {
+			t.Errorf("ParseAll: got %v, wanted an error at offset %d", err, len(data))
+		}
+	})
+
+	t.Run("Decoder", func(t *testing.T) {
+		var buf strings.Builder
+		dec := bplist.NewDecoder(bytes.NewReader(data))
+		for i, want := range lens {
+			n, err := dec.Parse(testHandler{log: t.Logf, buf: &buf})
+			if err != nil {
+				t.Fatalf("Parse %d: unexpected error: %v", i, err)
+			} else if n != want {
+				t.Errorf("Parse %d: got length %d, want %d", i, n, want)
+			}
+		}
+		if n, err := dec.Parse(nopHandler{}); err != io.EOF {
+			t.Errorf("Parse at end: got (%d, %v), want %v", n, err, io.EOF)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("Parse: got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestParsePrefix(t *testing.T) {
+	plist := mkPlist([]byte{0xa1, 1}, []byte{0x51, 'x'})
+	data := append(slices.Clone(plist), "SIGNATURE"...)
+
+	if err := bplist.Parse(data, nopHandler{}); err == nil {
+		t.Error("Parse with trailing data: got nil, wanted an error")
+	}
+	var buf strings.Builder
+	n, err := bplist.ParsePrefix(data, testHandler{log: t.Logf, buf: &buf})
+	if err != nil {
+		t.Fatalf("ParsePrefix: unexpected error: %v", err)
+	} else if n != len(plist) {
+		t.Errorf("ParsePrefix: got length %d, want %d", n, len(plist))
+	}
+	const want = `V"00"<array size=1>(string=x)</array>`
+	if got := buf.String(); got != want {
+		t.Errorf("ParsePrefix: got %q, want %q", got, want)
+	}
+
+	if _, err := bplist.ParsePrefix(plist[:len(plist)-1], nopHandler{}); err == nil {
+		t.Error("ParsePrefix truncated: got nil, wanted an error")
+	}
+
+	t.Run("Gap", func(t *testing.T) {
+		// Unused bytes between the offsets table and the trailer.
+		tpos := len(plist) - 32
+		gap := slices.Concat(plist[:tpos], []byte{0, 0}, plist[tpos:])
+
+		var warns []string
+		opts := bplist.ParseOptions{Warn: func(w bplist.Warning) { warns = append(warns, w.String()) }}
+		if err := opts.Parse(gap, nopHandler{}); err != nil {
+			t.Errorf("Parse: unexpected error: %v", err)
+		}
+		if len(warns) != 1 || warns[0] != "2 unused bytes after offsets table" {
+			t.Errorf("Warnings: got %q, want one about unused bytes", warns)
+		}
+		if err := bplist.ParseWith(gap, nopHandler{}, bplist.WithStrict()); err == nil {
+			t.Error("Parse strict: got nil, wanted an error")
+		}
+	})
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	data := mkPlist([]byte{0x09})
+	copy(data[len("bplist"):], "15")
+
+	var ver string
+	err := bplist.Parse(data, testHandler{log: t.Logf, buf: io.Discard, ver: &ver})
+	if !errors.Is(err, bplist.ErrUnsupportedVersion) {
+		t.Errorf("Parse: got %v, want %v", err, bplist.ErrUnsupportedVersion)
+	}
+	if ver != "15" {
+		t.Errorf("Version: got %q, want %q", ver, "15")
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	valid := mkPlist([]byte{0xa1, 1}, []byte{0x51, 'x'})
+	withTrailer := func(f func(tr []byte)) []byte {
+		data := slices.Clone(valid)
+		f(data[len(data)-32:])
+		return data
+	}
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"TruncatedString", mkPlist([]byte{0x5f, 0x10, 0x40, 'x'})},
+		{"TruncatedSize", mkPlist([]byte{0x5f, 0x13, 0x00})},
+		{"HugeSize", mkPlist([]byte{0x4f, 0x13, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})},
+		{"NegativeSize", mkPlist([]byte{0x4f, 0x13, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})},
+		{"WideSize", mkPlist([]byte{0x4f, 0x1f, 0x01})},
+		{"TruncatedUnicode", mkPlist([]byte{0x6f, 0x10, 0x40, 0x00, 'x'})},
+		{"TruncatedArray", mkPlist([]byte{0xaf, 0x10, 0x7f, 0x00})},
+		{"TruncatedDict", mkPlist([]byte{0xd2, 0x01, 0x01, 0x01})},
+		{"BadRef", mkPlist([]byte{0xa1, 0x09})},
+		{"BadRoot", withTrailer(func(tr []byte) { binary.BigEndian.PutUint64(tr[16:], 5) })},
+		{"NoRefBytes", withTrailer(func(tr []byte) { tr[7] = 0 })},
+		{"NoOffsetBytes", withTrailer(func(tr []byte) { tr[6] = 0 })},
+		{"WideOffsets", withTrailer(func(tr []byte) { tr[6] = 9 })},
+		{"HugeCount", withTrailer(func(tr []byte) { binary.BigEndian.PutUint64(tr[8:], 1<<62) })},
+		{"NegativeCount", withTrailer(func(tr []byte) { binary.BigEndian.PutUint64(tr[8:], 1<<63) })},
+		{"BadTable", withTrailer(func(tr []byte) { binary.BigEndian.PutUint64(tr[24:], 1<<63) })},
+		{"BadOffset", func() []byte {
+			data := slices.Clone(valid)
+			data[len(data)-33] = 0xf0 // the last offset table entry
+			return data
+		}()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := bplist.Parse(test.input, nopHandler{}); err == nil {
+				t.Error("Parse: got nil, wanted an error")
+			} else {
+				t.Logf("Parse: %v", err)
+			}
+			opts := bplist.ParseOptions{ContinueOnError: true, Warn: func(bplist.Warning) {}}
+			opts.Parse(test.input, nopHandler{}) // must not panic
+		})
+	}
+}
+
+func TestCycle(t *testing.T) {
+	t.Run("Cycles", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			input []byte
+		}{
+			{"Self", mkPlist([]byte{0xa1, 0})},
+			{"SelfDict", mkPlist([]byte{0xd1, 1, 0}, []byte{0x51, 'k'})},
+			{"Indirect", mkPlist([]byte{0xa1, 1}, []byte{0xd1, 2, 0}, []byte{0x51, 'k'})},
+			{"Set", mkPlist([]byte{0xa1, 1}, []byte{0xc2, 2, 1}, []byte{0x10, 1})},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				err := bplist.Parse(test.input, nopHandler{})
+				if err == nil || !strings.Contains(err.Error(), "cycle") {
+					t.Errorf("Parse: got %v, wanted a cycle error", err)
+				}
+				opts := bplist.ParseOptions{ContinueOnError: true}
+				if err := opts.Parse(test.input, nopHandler{}); err == nil {
+					t.Error("Parse with ContinueOnError: got nil, wanted an error")
+				}
+			})
+		}
+	})
+
+	t.Run("Shared", func(t *testing.T) {
+		// An object referenced more than once is not a cycle.
+		input := mkPlist([]byte{0xa2, 1, 1}, []byte{0xa1, 2}, []byte{0x51, 'x'})
+		var buf strings.Builder
+		if err := bplist.Parse(input, testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Fatalf("Parse: unexpected error: %v", err)
+		}
+		const want = `V"00"<array size=2><array size=1>(string=x)</array><array size=1>(string=x)</array></array>`
+		if got := buf.String(); got != want {
+			t.Errorf("Parse: got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  bplist.ParseError
+		msg   string
+	}{
+		{"BadTag", mkPlist([]byte{0xa1, 1}, []byte{0x95}),
+			bplist.ParseError{Offset: 10, Object: 1, Tag: 0x95},
+			"object 1 at offset 10 (tag 95): unrecognized tag 95"},
+		{"BadRef", mkPlist([]byte{0xa1, 7}),
+			bplist.ParseError{Offset: -1, Object: 7, Tag: -1},
+			"object 7: reference out of range (1 objects)"},
+		{"BadMagic", append([]byte("xplist00"), make([]byte, 40)...),
+			bplist.ParseError{Offset: 0, Object: -1, Tag: -1},
+			"offset 0: invalid magic number"},
+		{"BadTrailer", func() []byte {
+			data := mkPlist([]byte{0x09})
+			data[len(data)-26] = 0 // offset size
+			return data
+		}(),
+			bplist.ParseError{Offset: 10, Object: -1, Tag: -1},
+			"offset 10: invalid offset size 0"},
+		{"BadRefSize", func() []byte {
+			data := mkPlist([]byte{0xa1, 1}, []byte{0x09})
+			data[len(data)-25] = 0 // reference size
+			return data
+		}(),
+			bplist.ParseError{Offset: 13, Object: -1, Tag: -1},
+			"offset 13: invalid reference size 0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bplist.Parse(test.input, nopHandler{})
+			var pe *bplist.ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Parse: got %v, wanted a *ParseError", err)
+			}
+			if pe.Offset != test.want.Offset || pe.Object != test.want.Object || pe.Tag != test.want.Tag {
+				t.Errorf("Parse: got offset %d, object %d, tag %d; want %d, %d, %d",
+					pe.Offset, pe.Object, pe.Tag, test.want.Offset, test.want.Object, test.want.Tag)
+			}
+			if got := err.Error(); got != test.msg {
+				t.Errorf("Error: got %q, want %q", got, test.msg)
+			}
+		})
+	}
+}
+
+func TestDeepNesting(t *testing.T) {
+	// Parsing must not depend on the goroutine stack to track nesting.
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	// Object i is an array containing object i+1, and the last is true.
+	const depth = 100000
+	buf := []byte("bplist00")
+	var offsets []byte
+	for i := 1; i < depth; i++ {
+		offsets = binary.BigEndian.AppendUint32(offsets, uint32(len(buf)))
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xa1), uint32(i))
+	}
+	offsets = binary.BigEndian.AppendUint32(offsets, uint32(len(buf)))
+	buf = append(buf, 0x09)
+	table := len(buf)
+	buf = append(buf, offsets...)
+	buf = append(buf, 0, 0, 0, 0, 0, 0, 4, 4)
+	buf = binary.BigEndian.AppendUint64(buf, depth)
+	buf = binary.BigEndian.AppendUint64(buf, 0)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(table))
+
+	if err := bplist.Parse(buf, nopHandler{}); err != nil {
+		t.Errorf("Parse: unexpected error: %v", err)
+	}
+	err := bplist.ParseWith(buf, nopHandler{}, bplist.WithMaxDepth(depth-2))
+	if err == nil || !strings.Contains(err.Error(), "nested more than") {
+		t.Errorf("Parse: got %v, wanted a depth error", err)
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	// {"Items": [{"Name": "x"}, 5]}
+	input := mkPlist(
+		[]byte{0xd1, 1, 2},
+		[]byte{0x55, 'I', 't', 'e', 'm', 's'},
+		[]byte{0xa2, 3, 4},
+		[]byte{0xd1, 5, 6},
+		[]byte{0x10, 0x05},
+		[]byte{0x54, 'N', 'a', 'm', 'e'},
+		[]byte{0x51, 'x'},
+	)
+	errBad := errors.New("bad")
+	tests := []struct {
+		name string
+		h    bplist.HandlerFuncs
+		want string
+	}{
+		{"Value", bplist.HandlerFuncs{ValueFunc: func(_ bplist.Type, datum any) error {
+			if datum == "x" {
+				return errBad
+			}
+			return nil
+		}}, "at Items[0].Name (offset 0x1e): bad"},
+		{"Key", bplist.HandlerFuncs{ValueFunc: func(_ bplist.Type, datum any) error {
+			if datum == "Name" {
+				return errBad
+			}
+			return nil
+		}}, "at Items[0] (offset 0x19): bad"},
+		{"Open", bplist.HandlerFuncs{OpenFunc: func(coll bplist.Collection, _ int) error {
+			if coll == bplist.Array {
+				return errBad
+			}
+			return nil
+		}}, "at Items (offset 0x11): bad"},
+		{"Close", bplist.HandlerFuncs{CloseFunc: func(coll bplist.Collection) error {
+			if coll == bplist.Array {
+				return errBad
+			}
+			return nil
+		}}, "at Items (offset 0x11): bad"},
+		{"Root", bplist.HandlerFuncs{OpenFunc: func(bplist.Collection, int) error {
+			return errBad
+		}}, "at root (offset 0x8): bad"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bplist.Parse(input, test.h)
+			var he *bplist.HandlerError
+			if !errors.As(err, &he) || !errors.Is(err, errBad) {
+				t.Fatalf("Parse: got %v, wanted a *HandlerError wrapping %v", err, errBad)
+			}
+			if got := err.Error(); got != test.want {
+				t.Errorf("Error: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrStop(t *testing.T) {
+	// [1, 2, 3]
+	input := mkPlist(
+		[]byte{0xa3, 1, 2, 3},
+		[]byte{0x10, 1},
+		[]byte{0x10, 2},
+		[]byte{0x10, 3},
+	)
+	var got []any
+	h := bplist.HandlerFuncs{ValueFunc: func(_ bplist.Type, datum any) error {
+		got = append(got, datum)
+		if datum == int64(2) {
+			return fmt.Errorf("enough: %w", bplist.ErrStop)
+		}
+		return nil
+	}}
+	if err := bplist.Parse(input, h); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if want := []any{int64(1), int64(2)}; !slices.Equal(got, want) {
+		t.Errorf("Values: got %v, want %v", got, want)
+	}
+
+	stop := bplist.HandlerFuncs{VersionFunc: func(string) error { return bplist.ErrStop }}
+	if err := bplist.Parse(input, stop); err != nil {
+		t.Errorf("Parse with stop at version: unexpected error: %v", err)
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	// [[["x"]]]
+	input := mkPlist([]byte{0xa1, 1}, []byte{0xa1, 2}, []byte{0xa1, 3}, []byte{0x51, 'x'})
+
+	t.Run("Default", func(t *testing.T) {
+		if err := bplist.ParseWith(input, nopHandler{}); err != nil {
+			t.Errorf("ParseWith: unexpected error: %v", err)
+		}
+	})
+	t.Run("MaxDepth", func(t *testing.T) {
+		if err := bplist.ParseWith(input, nopHandler{}, bplist.WithMaxDepth(3)); err != nil {
+			t.Errorf("ParseWith(3): unexpected error: %v", err)
+		}
+		err := bplist.ParseWith(input, nopHandler{}, bplist.WithMaxDepth(2))
+		if err == nil || !strings.Contains(err.Error(), "nested more than 2 deep") {
+			t.Errorf("ParseWith(2): got %v, wanted a depth error", err)
+		}
+	})
+	t.Run("ZeroCopy", func(t *testing.T) {
+		var buf strings.Builder
+		h := testHandler{log: t.Logf, buf: &buf}
+		if err := bplist.ParseWith(input, h, bplist.WithZeroCopy(), bplist.WithMaxDepth(5)); err != nil {
+			t.Fatalf("ParseWith: unexpected error: %v", err)
+		}
+		const want = `V"00"<array size=1><array size=1><array size=1>(string=1 bytes)</array></array></array>`
+		if got := buf.String(); got != want {
+			t.Errorf("ParseWith: got %q, want %q", got, want)
+		}
+	})
+	t.Run("Strict", func(t *testing.T) {
+		data := slices.Clone(input)
+		data[len(data)-32] = 1 // a reserved trailer byte
+		if err := bplist.ParseWith(data, nopHandler{}); err != nil {
+			t.Errorf("ParseWith: unexpected error: %v", err)
+		}
+		if err := bplist.ParseWith(data, nopHandler{}, bplist.WithStrict()); err == nil {
+			t.Error("ParseWith(strict): got nil, wanted an error")
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(mkPlist([]byte{0xa2, 1, 2}, []byte{0x51, 'x'}, []byte{0xd1, 1, 1}))
+	f.Add(mkPlist([]byte{0x5f, 0x10, 0x02, 'h', 'i'}))
+	f.Add(mkPlist([]byte{0x62, 0x00, 'h', 0x00, 'i'}))
+	f.Add(mkPlist([]byte{0x13, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00}))
+	f.Add(func() []byte {
+		data := mkPlist([]byte{0xa1, 1}, []byte{0x09})
+		data[len(data)-25] = 0 // reference size
+		return data
+	}())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		opts := bplist.ParseOptions{Warn: func(bplist.Warning) {}}
+		opts.Parse(data, nopHandler{})
+		opts.Parse(data, spanHandler{testHandler{log: t.Logf, buf: io.Discard}})
+		for _, err := range opts.Tokens(data) {
+			if err != nil {
+				break
+			}
+		}
+		opts.ContinueOnError = true
+		opts.Parse(data, nopHandler{})
+		opts.Recover = true
+		opts.Parse(data, nopHandler{})
+		if v, err := bplist.ParseLazy(data); err == nil {
+			v.Value()
+			v.Keys()
+			v.Index(0)
+		}
+	})
+}
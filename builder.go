@@ -15,112 +15,376 @@
 package bplist
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 	"unicode"
 	"unicode/utf16"
 	"unicode/utf8"
+
+	"github.com/creachadair/bplist/wire"
 )
 
+// ErrOverflow is reported by a Builder for an integer that is outside the
+// range the binary format can represent.
+var ErrOverflow = errors.New("integer out of range")
+
 // A Builder accumulates values to build a binary property list.  The zero
 // value is ready for use.  Add elements and collections to the list with Value
-// and Open.  When the property list is complete, use WriteTo to encode it.
+// and Open or OpenColl.  When the property list is complete, use WriteTo or
+// Bytes to encode it.
 type Builder struct {
+	opts BuilderOptions
 	stk  []entry
 	nobj int
 	err  error
+	errs []error // invalid content (with AccumulateErrors)
 }
 
 // NewBuilder constructs a new empty property list builder.
 // Add items to the property list using the Value, Open, and Close methods.
 func NewBuilder() *Builder { return new(Builder) }
 
+// BuilderOptions control the encoding of a property list by a Builder.
+// The zero value provides default settings.
+type BuilderOptions struct {
+	// Version is the two-byte format version written in the file header.
+	// If empty, "00" is used. Versions beginning with "1" are rejected, as
+	// the bplist1x formats use an object layout this package does not write.
+	Version string
+
+	// TrailerUnused and SortVersion are written to the corresponding reserved
+	// fields of the file trailer. Both are normally zero.
+	TrailerUnused [5]byte
+	SortVersion   byte
+
+	// A property list has exactly one root element. If ImplicitRoot is set to
+	// a collection type, the top-level elements of the builder are wrapped in
+	// a collection of that type to form the root, even if there is only one.
+	// Otherwise, WriteTo reports an error unless there is exactly one.
+	ImplicitRoot Collection
+
+	// Durations selects how the Duration method represents a time.Duration.
+	// The default is DurationSeconds.
+	Durations DurationFormat
+
+	// By default, identical elements, including the null and Boolean values,
+	// are encoded once and shared, while each collection is encoded as its own
+	// object even if it is empty. Writers differ in these choices, so they can
+	// be changed to match the output of another tool.
+	//
+	// If ShareEmpty is true, empty collections of the same type are encoded
+	// once and shared. If DistinctSingletons is true, each occurrence of a
+	// null or Boolean value is encoded as a separate object.
+	ShareEmpty         bool
+	DistinctSingletons bool
+
+	// If ShareCollections is true, collections of the same type with the same
+	// contents, including empty ones, are also encoded once and shared. This
+	// can greatly reduce the size of a property list with repeated structure.
+	ShareCollections bool
+
+	// If SortKeys is true, the entries of each Dict are sorted in CompareKeys
+	// order of their keys when it is closed, so that the output does not
+	// depend on the order the entries were added. Keys that are not strings
+	// are placed after the others, in their original order.
+	SortKeys bool
+
+	// If IntWidth is nonzero, integers are encoded with at least this many
+	// bytes, rather than the fewest that hold the value, to match the output
+	// of writers that use a fixed width. It must be 1, 2, 4, or 8. Negative
+	// integers always use 8 bytes.
+	IntWidth int
+
+	// If UIDWidth is nonzero, UIDs are encoded with at least this many bytes,
+	// rather than the fewest of 1, 2, 4, or 8 that hold the value. It must be
+	// 1, 2, 4, or 8. Note that CoreFoundation reads UIDs up to 4 bytes wide.
+	UIDWidth int
+
+	// If AccumulateErrors is true, a Builder does not fail at the first
+	// invalid element, key, or collection, but records the problem, skips the
+	// offending element, and continues, so that all the problems with the
+	// content can be reported together by Err and WriteTo.
+	AccumulateErrors bool
+
+	// If StrictKeys is true, closing a Dict reports an error if any of its
+	// keys is not a string. The format allows keys of any type, but Apple's
+	// tools expect strings.
+	StrictKeys bool
+
+	// XML sets the layout of the output of WriteXML.
+	XML XMLOptions
+}
+
+// SetOptions sets the encoding options for b. A nil opts restores defaults.
+// Options are retained across calls to Reset.
+func (b *Builder) SetOptions(opts *BuilderOptions) {
+	if opts == nil {
+		b.opts = BuilderOptions{}
+	} else {
+		b.opts = *opts
+	}
+}
+
+func (o *BuilderOptions) version() (string, error) {
+	if o.Version == "" {
+		return "00", nil
+	} else if len(o.Version) != 2 {
+		return "", fmt.Errorf("invalid version %q: must be 2 bytes", o.Version)
+	}
+	for i := 0; i < len(o.Version); i++ {
+		if c := o.Version[i]; c < ' ' || c > '~' {
+			return "", fmt.Errorf("invalid version %q: non-printing byte", o.Version)
+		}
+	}
+	if o.Version[0] == '1' {
+		return "", fmt.Errorf("version %q is not supported for writing", o.Version)
+	}
+	return o.Version, nil
+}
+
 // Err reports the last error that caused an operation on b to fail.  It
 // returns nil for a new builder.  Any error causes all subsequent operations
 // on the builder to fail with the same error.
-func (b *Builder) Err() error { return b.err }
+//
+// With the AccumulateErrors option, Err instead reports all the problems
+// found with the content of b, combined with errors.Join.
+func (b *Builder) Err() error {
+	if len(b.errs) == 0 {
+		return b.err
+	}
+	return errors.Join(append(slices.Clone(b.errs), b.err)...)
+}
 
 // Reset discards all the data associated with b and restores it to its initial
 // state. This also clears any error from a previous failed operation.
-func (b *Builder) Reset() { *b = Builder{} }
+// Options set by SetOptions are not affected.
+func (b *Builder) Reset() { *b = Builder{opts: b.opts} }
+
+// Clone returns a new Builder with the same options, contents, and error
+// state as b. Subsequent changes to either builder do not affect the other,
+// so a common prefix can be built once and completed in different ways.
+func (b *Builder) Clone() *Builder {
+	return &Builder{
+		opts: b.opts,
+		stk:  slices.Clone(b.stk),
+		nobj: b.nobj,
+		err:  b.err,
+		errs: slices.Clone(b.errs),
+	}
+}
+
+// A Checkpoint records the state of a Builder, so that the elements added
+// after it can be discarded. See Builder.Checkpoint.
+type Checkpoint struct {
+	stk  []entry
+	nobj int
+	err  error
+	errs []error
+}
+
+// Checkpoint returns a record of the current state of b, which can be passed
+// to Rollback to discard the elements and collections added after this call.
+// This allows a part of the property list to be built speculatively, and
+// abandoned if its source fails.
+func (b *Builder) Checkpoint() Checkpoint {
+	return Checkpoint{stk: slices.Clone(b.stk), nobj: b.nobj, err: b.err, errs: slices.Clone(b.errs)}
+}
+
+// Rollback restores b to the state recorded by c, discarding everything added
+// since, and the error state of any operation that has failed since. The
+// checkpoint must have been made by b, and remains valid after a rollback, so
+// it may be used again.
+func (b *Builder) Rollback(c Checkpoint) {
+	b.stk = append(b.stk[:0], c.stk...)
+	b.nobj = c.nobj
+	b.err = c.err
+	b.errs = append(b.errs[:0], c.errs...)
+}
 
 // WriteTo encodes the property list and writes it in binary form to w.
+// The objects are written to w as they are encoded, so if an error occurs,
+// part of the output may already have been written.
 func (b *Builder) WriteTo(w io.Writer) (int64, error) {
-	if b.err != nil {
-		return 0, b.err
-	} else if len(b.stk) != 1 {
-		return 0, b.fail(fmt.Errorf("have %d elements, want 1", len(b.stk)))
+	if err := b.Err(); err != nil {
+		return 0, err
 	}
-
-	// Encode the variable-size objects.
-	e := newEncoder(b.nobj)
-	root, err := e.encode(b.stk[0])
+	top, nobj, err := b.root()
 	if err != nil {
 		return 0, b.fail(err)
 	}
-
-	// Write the file header.
-	var total int64
-	nw, err := io.WriteString(w, "bplist00")
-	total += int64(nw)
+	version, err := b.opts.version()
 	if err != nil {
-		return total, b.fail(err)
+		return 0, b.fail(err)
+	}
+	switch b.opts.IntWidth {
+	case 0, 1, 2, 4, 8:
+	default:
+		return 0, b.fail(fmt.Errorf("invalid integer width %d", b.opts.IntWidth))
+	}
+	switch b.opts.UIDWidth {
+	case 0, 1, 2, 4, 8:
+	default:
+		return 0, b.fail(fmt.Errorf("invalid UID width %d", b.opts.UIDWidth))
 	}
-	base := int(total) // start of variable objects
 
-	// Write the encoded objects.
-	nc, err := io.Copy(w, e.buf)
-	total += nc
+	// Write the file header, then encode the variable-size objects directly
+	// to w, recording the offset of each as it is written. The output is
+	// buffered, but the objects are not held in memory for the whole file.
+	cw := &countWriter{w: w}
+	e := newEncoder(nobj, &b.opts, bufio.NewWriter(cw))
+	e.write([]byte("bplist" + version))
+	base := e.pos // start of variable objects
+	root, err := e.encode(top)
 	if err != nil {
-		return total, b.fail(err)
+		return cw.n, b.fail(err)
 	}
 
-	// Build the offset table.
+	// Write the offset table.
 	//
 	// Each offset in the table must have enough bits to hold the largest
 	// possible offset for any object, which is bounded by the offset of the
 	// table itself (i.e., the end of the variable objects).
-	offStart := total
-	offSize := numBytes(uint64(offStart + int64(base)))
+	offStart := e.pos
+	offSize := wire.Width(uint64(offStart + base))
 
-	var idx bytes.Buffer
-	for i := 0; i < b.nobj; i++ {
+	// Duplicate elements share a single object, so the number of objects
+	// actually written may be less than b.nobj.
+	for i := 0; i < e.nextID; i++ {
 		off, ok := e.offset[i]
 		if !ok {
-			return total, b.fail(fmt.Errorf("object %d missing offset", i))
+			return cw.n, b.fail(fmt.Errorf("object %d missing offset", i))
 		}
-		writeInt(&idx, offSize, off+base) // shift past header
+		e.write(wire.AppendUint(e.tmp[:0], offSize, uint64(off)))
 	}
 
-	// Build the file trailer, a 32-byte index for the rest of the file.  The
+	// Write the file trailer, a 32-byte index for the rest of the file.  The
 	// first word contains the offset and pointer sizes, the rest give the
 	// object count, root object pointer, and location of the offset table
 	// relative to the start of the file.
 	var zbuf [8]byte
+	copy(zbuf[:5], b.opts.TrailerUnused[:])
+	zbuf[5] = b.opts.SortVersion
 	zbuf[6] = byte(offSize)
 	zbuf[7] = byte(e.idSize)
-	idx.Write(zbuf[:])
-	binary.BigEndian.PutUint64(zbuf[:], uint64(b.nobj))
-	idx.Write(zbuf[:])
+	e.write(zbuf[:])
+	binary.BigEndian.PutUint64(zbuf[:], uint64(e.nextID))
+	e.write(zbuf[:])
 	binary.BigEndian.PutUint64(zbuf[:], uint64(root))
-	idx.Write(zbuf[:])
+	e.write(zbuf[:])
 	binary.BigEndian.PutUint64(zbuf[:], uint64(offStart))
-	idx.Write(zbuf[:])
+	e.write(zbuf[:])
+
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	return cw.n, b.fail(e.err)
+}
+
+// EncodedSize reports the size in bytes of the binary encoding of the property
+// list, as WriteTo would write it, without retaining the output. It reports
+// the same errors as WriteTo.
+func (b *Builder) EncodedSize() (int64, error) { return b.WriteTo(io.Discard) }
 
-	// Copy the offset table and trailer.
-	nc, err = io.Copy(w, &idx)
-	total += nc
-	return int64(total), b.fail(err)
+// A countWriter wraps an io.Writer and counts the bytes written to it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(data []byte) (int, error) {
+	nw, err := c.w.Write(data)
+	c.n += int64(nw)
+	return nw, err
+}
+
+// Bytes encodes the property list and returns it in binary form.
+func (b *Builder) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// root returns the root entry of the property list, and an upper bound on the
+// number of objects to encode. It reports an error if there is not exactly one
+// top-level element, unless ImplicitRoot is set.
+func (b *Builder) root() (entry, int, error) {
+	for _, e := range b.stk {
+		if e.coll != 0 && !e.closed {
+			return entry{}, 0, fmt.Errorf("unclosed %v", e.coll)
+		}
+	}
+	switch coll := b.opts.ImplicitRoot; coll {
+	case 0:
+		// No implicit root; check below.
+	case Array, Set, OrderedSet, Dict:
+		if coll == Dict && len(b.stk)%2 != 0 {
+			return entry{}, 0, errors.New("implicit root dictionary: missing value")
+		} else if coll == Dict && b.opts.StrictKeys {
+			if err := checkKeys(b.stk); err != nil {
+				return entry{}, 0, fmt.Errorf("implicit root dictionary: %w", err)
+			}
+		}
+		content := b.stk
+		if coll == Dict && b.opts.SortKeys {
+			content = sortEntries(slices.Clone(content))
+		}
+		return entry{coll: coll, closed: true, content: content}, b.nobj + 1, nil
+	default:
+		return entry{}, 0, fmt.Errorf("invalid implicit root type: %v", coll)
+	}
+
+	if len(b.stk) == 1 {
+		return b.stk[0], b.nobj, nil
+	} else if len(b.stk) == 0 {
+		return entry{}, 0, errors.New("no root element")
+	}
+	const maxShow = 4
+	var descs []string
+	for i, e := range b.stk {
+		if i == maxShow {
+			descs = append(descs, fmt.Sprintf("... %d more", len(b.stk)-i))
+			break
+		}
+		descs = append(descs, e.String())
+	}
+	return entry{}, 0, fmt.Errorf("have %d top-level elements [%s], want 1; "+
+		"add them to a collection or set BuilderOptions.ImplicitRoot",
+		len(b.stk), strings.Join(descs, ", "))
 }
 
 // Value adds a single data element to the property list.  It reports an error
 // if typ is not a known element type, or if datum is not a valid value for
 // that type.
+//
+// In addition to the datum types described for each Type, TString accepts a
+// url.URL, netip.Addr, or netip.Prefix and encodes its string form, and both
+// TString and TBytes accept a [16]byte UUID, encoded either in the canonical
+// text form or as raw bytes. See DecodeURL and related functions to convert
+// these back.
+//
+// TFloat also accepts a float32, which is encoded in the 4-byte form.
+//
+// TInteger accepts any signed or unsigned integer type, a *big.Int, or a
+// json.Number holding an integer. A value that does not fit in an int64 is
+// encoded in the 16-byte unsigned form, so it must not be negative or wider
+// than 128 bits; otherwise Value reports an error wrapping ErrOverflow.
+//
+// A TRaw datum is written as given, without checking that it is valid. It
+// must not be a collection, since the Builder assigns object references.
 func (b *Builder) Value(typ Type, datum any) error {
 	if b.err != nil {
 		return b.err
@@ -132,40 +396,56 @@ func (b *Builder) Value(typ Type, datum any) error {
 	case TBool:
 		_, ok = datum.(bool)
 	case TInteger:
-		datum, ok = intValue(datum)
+		z, isInt, err := integerValue(datum)
+		if err != nil {
+			return b.invalid(err)
+		} else if ok = isInt; ok {
+			datum = z
+		}
 	case TFloat:
-		_, ok = datum.(float64)
+		switch datum.(type) {
+		case float64, float32:
+			ok = true
+		}
 	case TTime:
 		_, ok = datum.(time.Time)
 	case TBytes:
 		// Allow either a string or a slice for this, but convert the actual
 		// value to a string so it can be checked as a map key for deduplication.
-		var b []byte
-		b, ok = datum.([]byte)
-		if ok {
-			datum = string(b)
-		} else {
-			_, ok = datum.(string)
+		switch t := datum.(type) {
+		case []byte:
+			datum, ok = string(t), true
+		case string:
+			ok = true
+		case [16]byte: // UUID
+			datum, ok = string(t[:]), true
 		}
 	case TString, TUnicode:
-		var r []rune
-		r, ok = datum.([]rune)
-		if ok {
-			datum = string(r)
-		} else {
-			_, ok = datum.(string)
-		}
+		datum, ok = stringValue(datum)
 	case TUID:
-		var b []byte
-		b, ok = datum.([]byte)
-		if ok {
-			datum = string(b)
+		var id []byte
+		id, ok = datum.([]byte)
+		if ok && (len(id) < 1 || len(id) > 8) {
+			return b.invalid(fmt.Errorf("invalid UID length %d", len(id)))
+		} else if ok {
+			z := uint64(parseInt(id))
+			datum = string(wire.AppendUint(nil, wire.Width(z), z))
+		}
+	case TRaw:
+		var raw []byte
+		raw, ok = datum.([]byte)
+		if ok && len(raw) == 0 {
+			return b.invalid(errors.New("empty raw object"))
+		} else if ok && raw[0]>>4 >= wire.Array>>4 && raw[0]>>4 <= wire.Dict>>4 {
+			return b.invalid(fmt.Errorf("raw object with collection tag %02x", raw[0]))
+		} else if ok {
+			datum = string(raw)
 		}
 	default:
-		return b.fail(fmt.Errorf("unknown element type: %v", typ))
+		return b.invalid(fmt.Errorf("unknown element type: %v", typ))
 	}
 	if !ok {
-		return b.fail(fmt.Errorf("invalid datum %T for %v", datum, typ))
+		return b.invalid(fmt.Errorf("invalid datum %T for %v", datum, typ))
 	}
 	elt := entry{elt: typ, datum: datum}
 	b.stk = append(b.stk, elt)
@@ -173,6 +453,81 @@ func (b *Builder) Value(typ Type, datum any) error {
 	return nil
 }
 
+// Key adds a string key to the innermost open collection, which must be a
+// Dict whose entries are complete, so that the next element added is the
+// value for the key. Unlike adding the key with Value, it reports an error at
+// once if the key is out of place.
+func (b *Builder) Key(key string) error {
+	if b.err != nil {
+		return b.err
+	}
+	n := b.innermost()
+	if n < 0 || b.stk[n].coll != Dict {
+		return b.invalid(fmt.Errorf("key %q outside a dictionary", key))
+	} else if (len(b.stk)-n-1)%2 != 0 {
+		return b.invalid(fmt.Errorf("key %q follows a key without a value", key))
+	}
+	return b.Value(TString, key)
+}
+
+// Pair adds a key and a value element to the innermost open collection, which
+// must be a Dict, as if by Key followed by Value.
+func (b *Builder) Pair(key string, typ Type, datum any) error {
+	if err := b.Key(key); err != nil {
+		return err
+	}
+	return b.Value(typ, datum)
+}
+
+// innermost returns the index in b.stk of the innermost open collection, or
+// -1 if no collection is open.
+func (b *Builder) innermost() int {
+	for n := len(b.stk) - 1; n >= 0; n-- {
+		if b.stk[n].coll != 0 && !b.stk[n].closed {
+			return n
+		}
+	}
+	return -1
+}
+
+// Embed adds the complete property list built by src to b, as a single
+// element or collection. The root of src is determined as for WriteTo, and
+// Embed reports an error if src could not be written. The contents of src
+// are copied, so later changes to src do not affect b, and src is not
+// modified.
+func (b *Builder) Embed(src *Builder) error {
+	if b.err != nil {
+		return b.err
+	} else if err := src.Err(); err != nil {
+		return b.invalid(fmt.Errorf("embedded builder: %w", err))
+	}
+	top, nobj, err := src.root()
+	if err != nil {
+		return b.invalid(fmt.Errorf("embedded builder: %w", err))
+	}
+	// An implicit root shares the stack of src, which later changes to src
+	// may reuse. The content of a closed collection is not modified.
+	top.content = slices.Clone(top.content)
+	b.stk = append(b.stk, top)
+	b.nobj += nobj
+	return nil
+}
+
+// EmbedValue adds v and its contents to b, as a single element or collection.
+func (b *Builder) EmbedValue(v *Value) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.fail(v.build(b))
+}
+
+// Duration adds a single data element representing d, in the format given by
+// the Durations option.
+func (b *Builder) Duration(d time.Duration) error {
+	typ, datum := b.opts.Durations.Encode(d)
+	return b.Value(typ, datum)
+}
+
 // Open adds a new empty collection of the given type, and calls f to populate
 // its contents. When f returns, the collection is automatically closed.  It is
 // safe and valid for f to open further nested collections.
@@ -184,12 +539,52 @@ func (b *Builder) Value(typ Type, datum any) error {
 //	  b.Value(bplist.TString, "bar")
 //	})
 func (b *Builder) Open(coll Collection, f func(*Builder)) {
-	b.stk = append(b.stk, entry{coll: coll})
-	b.nobj++ // +1 for the collection (items are separate)
+	b.open(coll)
 	defer b.close(coll)
 	f(b)
 }
 
+// OpenColl adds a new empty collection of the given type, to which subsequent
+// elements are added until it is closed by CloseColl or by the Close method
+// of the result. Unlike Open, this allows a collection to be built
+// incrementally, for example from values received over time.
+//
+// For example:
+//
+//	c := b.OpenColl(bplist.Array)
+//	for v := range values {
+//	  b.Value(bplist.TString, v)
+//	}
+//	if err := c.Close(); err != nil {
+//	  // ...
+//	}
+func (b *Builder) OpenColl(coll Collection) Closer {
+	b.open(coll)
+	return Closer{b: b, coll: coll}
+}
+
+// CloseColl closes the most recently-opened collection of the given type. It
+// reports an error if no collection of that type is open, if a collection
+// opened after it is still open, or if coll is a Dict whose elements are not
+// properly paired.
+func (b *Builder) CloseColl(coll Collection) error { return b.close(coll) }
+
+// A Closer closes a collection opened by Builder.OpenColl.
+type Closer struct {
+	b    *Builder
+	coll Collection
+}
+
+// Close closes the collection, as for Builder.CloseColl.
+func (c Closer) Close() error { return c.b.CloseColl(c.coll) }
+
+// open adds a new empty collection of the given type, to which subsequent
+// elements are added until the corresponding close.
+func (b *Builder) open(coll Collection) {
+	b.stk = append(b.stk, entry{coll: coll})
+	b.nobj++ // +1 for the collection (items are separate)
+}
+
 // close closes the most recently-opened collection of the given type. It
 // reports an error if no collection of that type is open. If coll is a
 // dictionary (bplist.Dict) it reports an error if the elements are not
@@ -207,29 +602,92 @@ func (b *Builder) close(coll Collection) error {
 				break
 			}
 		} else if b.stk[n].coll != 0 && !b.stk[n].closed {
-			return b.fail(fmt.Errorf("unclosed %v", b.stk[n].coll))
+			return b.invalid(fmt.Errorf("unclosed %v", b.stk[n].coll))
 		}
 		n--
 	}
 	if n < 0 {
-		return b.fail(fmt.Errorf("close of unopened %v", coll))
+		return b.invalid(fmt.Errorf("close of unopened %v", coll))
 	}
 	elts := b.stk[n+1:] // everything after the open is now content
 
-	// For dictionaries, contents must be paired (key, value).
+	// For dictionaries, contents must be paired (key, value). When errors are
+	// accumulated, the collection is closed regardless, so that later calls
+	// can be checked.
+	var err error
 	if coll == Dict && len(elts)%2 != 0 {
-		return b.fail(errors.New("missing value in dictionary"))
+		err = errors.New("missing value in dictionary")
+	} else if coll == Dict && b.opts.StrictKeys {
+		err = checkKeys(elts)
+	}
+	if err != nil {
+		if !b.opts.AccumulateErrors {
+			return b.fail(err)
+		}
+		b.errs = append(b.errs, err)
 	}
 
-	// Pack the entries into the collection and mark it complete.
+	// Pack the entries into the collection and mark it complete.  The content
+	// must be copied, since later additions will reuse the stack.
 	// Note although we have reduced the stack, we do not decrease the object
 	// count, since we haven't discarded any.
-	b.stk[n].content = elts
+	b.stk[n].content = slices.Clone(elts)
+	if coll == Dict && b.opts.SortKeys {
+		sortEntries(b.stk[n].content)
+	}
 	b.stk[n].closed = true
 	b.stk = b.stk[:n+1]
+	return err
+}
+
+// checkKeys reports an error if any of the keys of the key/value pairs of elts
+// is not a string, as required by BuilderOptions.StrictKeys.
+func checkKeys(elts []entry) error {
+	for i := 0; i < len(elts); i += 2 {
+		if _, ok := elts[i].key(); !ok {
+			return fmt.Errorf("dictionary key %d is not a string: %v", i/2, elts[i])
+		}
+	}
 	return nil
 }
 
+// sortEntries sorts the key/value pairs of elts in place by key, as described
+// for BuilderOptions.SortKeys, and returns elts.
+func sortEntries(elts []entry) []entry {
+	pairs := make([][2]entry, len(elts)/2)
+	for i := range pairs {
+		pairs[i] = [2]entry{elts[2*i], elts[2*i+1]}
+	}
+	slices.SortStableFunc(pairs, func(a, b [2]entry) int {
+		ka, aok := a[0].key()
+		kb, bok := b[0].key()
+		switch {
+		case aok && bok:
+			return CompareKeys(ka, kb)
+		case aok:
+			return -1
+		case bok:
+			return 1
+		}
+		return 0
+	})
+	for i, p := range pairs {
+		elts[2*i], elts[2*i+1] = p[0], p[1]
+	}
+	return elts
+}
+
+// invalid records err as a problem with the content added to b. By default
+// this causes b to fail, as for fail; but if the AccumulateErrors option is
+// set, err is added to the errors reported by Err, and b remains usable.
+func (b *Builder) invalid(err error) error {
+	if !b.opts.AccumulateErrors {
+		return b.fail(err)
+	}
+	b.errs = append(b.errs, err)
+	return err
+}
+
 func (b *Builder) fail(err error) error {
 	if err != nil {
 		b.err = err
@@ -237,36 +695,43 @@ func (b *Builder) fail(err error) error {
 	return err
 }
 
-func newEncoder(nobj int) *encoder {
+func newEncoder(nobj int, opts *BuilderOptions, w *bufio.Writer) *encoder {
 	return &encoder{
-		idSize: numBytes(uint64(nobj)),
+		opts:   opts,
+		idSize: wire.Width(uint64(nobj)),
 		objref: make(map[string]int),
 		offset: make(map[int]int),
-		buf:    bytes.NewBuffer(nil),
+		w:      w,
 	}
 }
 
 type encoder struct {
+	opts   *BuilderOptions
 	idSize int            // byte count per objid
 	nextID int            // next object id
 	objref map[string]int // :: key → objid
 	offset map[int]int    // :: objid → offset
-	buf    *bytes.Buffer
+	w      *bufio.Writer  // the output
+	pos    int            // the offset of the next byte written to w
+	err    error          // the first error writing to w
+	tmp    [64]byte       // scratch space for encoding small objects
 }
 
-func writeInt(w io.Writer, nb, z int) {
-	var zbuf [8]byte
-
-	v := uint64(z)
-	for i := 0; i < nb; i++ {
-		zbuf[7-i] = byte(v & 255)
-		v >>= 8
+// write writes data to the output at the current position, unless an earlier
+// write has failed.
+func (e *encoder) write(data []byte) {
+	if e.err != nil {
+		return
 	}
-	w.Write(zbuf[8-nb:])
+	nw, err := e.w.Write(data)
+	e.pos += nw
+	e.err = err
 }
 
 func (e *encoder) encode(elt entry) (int, error) {
-	if elt.coll == 0 {
+	if e.err != nil {
+		return 0, e.err
+	} else if elt.coll == 0 {
 		return e.encodeDatum(elt)
 	}
 	ids := make([]int, len(elt.content))
@@ -281,99 +746,123 @@ func (e *encoder) encode(elt entry) (int, error) {
 }
 
 func (e *encoder) encodeDatum(elt entry) (int, error) {
+	share := !e.opts.DistinctSingletons || (elt.elt != TNull && elt.elt != TBool)
 	ck := cacheKey(elt)
-	if z, ok := e.objref[ck]; ok {
+	if z, ok := e.objref[ck]; ok && share {
 		return z, nil
 	}
-	pos := e.buf.Len()
+	pos := e.pos
+	buf := e.tmp[:0]
 	switch elt.elt {
 	case TNull:
-		e.buf.WriteByte(0)
+		buf = append(buf, wire.Null)
 	case TBool:
 		if elt.datum.(bool) {
-			e.buf.WriteByte(8)
+			buf = append(buf, wire.True)
 		} else {
-			e.buf.WriteByte(9)
+			buf = append(buf, wire.False)
 		}
 	case TInteger:
-		e.buf.Write(unparseInt(0x10, uint64(elt.datum.(int64))))
+		switch z := elt.datum.(type) {
+		case int64:
+			buf = wire.AppendIntWidth(buf, max(e.opts.IntWidth, 1), uint64(z))
+		case uint64:
+			buf = wire.AppendInt128(buf, 0, z)
+		case *big.Int:
+			var w [16]byte
+			z.FillBytes(w[:])
+			buf = wire.AppendInt128(buf, binary.BigEndian.Uint64(w[:8]), binary.BigEndian.Uint64(w[8:]))
+		}
 	case TFloat:
-		e.buf.Write(unparseFloat(elt.datum.(float64)))
+		if f, ok := elt.datum.(float32); ok {
+			buf = wire.AppendReal32(buf, f)
+		} else {
+			buf = wire.AppendReal(buf, elt.datum.(float64))
+		}
 	case TTime:
-		sec := float64(elt.datum.(time.Time).UTC().Unix() - macEpoch)
-		e.buf.WriteByte(0x33)
-		var date [8]byte
-		binary.BigEndian.PutUint64(date[:], math.Float64bits(sec))
-		e.buf.Write(date[:])
+		t := elt.datum.(time.Time)
+		sec := float64(t.Unix()-macEpoch) + float64(t.Nanosecond())/1e9
+		buf = wire.AppendDate(buf, sec)
 	case TBytes:
-		writeData(e.buf, 0x40, elt.datum.(string))
+		buf = wire.AppendHeader(buf, wire.Data, len(elt.datum.(string)))
+		buf = append(buf, elt.datum.(string)...)
 	case TString, TUnicode:
 		s := elt.datum.(string)
 		if isASCII(s) {
-			writeData(e.buf, 0x50, s)
+			buf = append(wire.AppendHeader(buf, wire.ASCII, len(s)), s...)
 		} else if utf8.ValidString(s) {
-			writeData(e.buf, 0x70, s)
+			buf = append(wire.AppendHeader(buf, wire.UTF8, len(s)), s...)
 		} else {
 			u16 := utf16.Encode([]rune(s))
-			if len(u16) >= 15 {
-				e.buf.WriteByte(0x6f)
-				e.buf.Write(unparseInt(0x10, uint64(len(u16))))
-			} else {
-				e.buf.WriteByte(0x60 | byte(len(u16)))
-			}
+			buf = wire.AppendHeader(buf, wire.UTF16, len(u16))
 			for _, uc := range u16 {
-				v := []byte{byte((uc >> 8) & 0xff), byte(uc & 0xff)}
-				e.buf.Write(v)
+				buf = binary.BigEndian.AppendUint16(buf, uc)
 			}
 		}
+	case TUID:
+		z := uint64(parseInt([]byte(elt.datum.(string))))
+		buf = wire.AppendUID(buf, max(e.opts.UIDWidth, 1), z)
+	case TRaw:
+		buf = append(buf, elt.datum.(string)...)
 	default:
 		return 0, fmt.Errorf("unexpected entry type: %v", elt.elt)
 	}
+	e.write(buf)
 
 	ref := e.nextID
 	e.nextID++
-	e.objref[ck] = ref
+	if share {
+		e.objref[ck] = ref
+	}
 	e.offset[ref] = pos
 	return ref, nil
 }
 
 func (e *encoder) encodeCollection(elt entry, ids []int) (int, error) {
-	pos := e.buf.Len()
+	var ck string
+	if e.opts.ShareCollections || (len(ids) == 0 && e.opts.ShareEmpty) {
+		ck = fmt.Sprintf("C:%d:%v", elt.coll, ids)
+		if z, ok := e.objref[ck]; ok {
+			return z, nil
+		}
+	}
+	pos := e.pos
 	nelt := len(ids)
 
 	var tag byte
 	switch elt.coll {
 	case Array:
-		tag = 0xa0
+		tag = wire.Array
+	case OrderedSet:
+		tag = wire.OrderedSet
 	case Set:
-		tag = 0xc0
+		tag = wire.Set
 	case Dict:
-		tag = 0xd0
+		tag = wire.Dict
 		nelt = len(ids) / 2
 	default:
 		return 0, fmt.Errorf("invalid collection type: %v", elt.coll)
 	}
-	if nelt >= 15 {
-		e.buf.WriteByte(tag | 0xf)
-		e.buf.Write(unparseInt(0x10, uint64(nelt)))
-	} else {
-		e.buf.WriteByte(tag | byte(nelt))
-	}
+	buf := wire.AppendHeader(e.tmp[:0], tag, nelt)
 	if elt.coll == Dict {
 		for i := 0; i < len(ids); i += 2 {
-			writeInt(e.buf, e.idSize, ids[i]) // keys
+			buf = wire.AppendRef(buf, e.idSize, ids[i]) // keys
 		}
 		for i := 1; i < len(ids); i += 2 {
-			writeInt(e.buf, e.idSize, ids[i]) // values
+			buf = wire.AppendRef(buf, e.idSize, ids[i]) // values
 		}
 	} else {
 		for _, id := range ids {
-			writeInt(e.buf, e.idSize, id)
+			buf = wire.AppendRef(buf, e.idSize, id)
 		}
 	}
+	e.write(buf)
 
 	ref := e.nextID
 	e.nextID++
+	if ck != "" {
+		e.objref[ck] = ref
+	}
 	e.offset[ref] = pos
 	return ref, nil
 }
@@ -386,9 +875,36 @@ type entry struct {
 	content []entry    // nil for an element
 }
 
+// String returns a brief description of e for use in diagnostics.
+func (e entry) String() string {
+	if e.coll != 0 {
+		n := len(e.content)
+		if e.coll == Dict {
+			n /= 2
+		}
+		return fmt.Sprintf("%v(%d)", e.coll, n)
+	}
+	const maxLen = 16
+	s := fmt.Sprint(e.datum)
+	if len(s) > maxLen {
+		s = s[:maxLen] + "..."
+	}
+	return fmt.Sprintf("%v(%s)", e.elt, s)
+}
+
+// key reports whether e is a string element, and if so returns its text.
+func (e entry) key() (string, bool) {
+	if e.coll == 0 && (e.elt == TString || e.elt == TUnicode) {
+		return e.datum.(string), true
+	}
+	return "", false
+}
+
 // Precondition: e is an element, not a collection.
+// The key includes the type of the datum, since for example a float32 and a
+// float64 with the same value are encoded differently.
 func cacheKey(e entry) string {
-	return fmt.Sprintf("E:%d:%v", e.elt, e.datum)
+	return fmt.Sprintf("E:%d:%T:%v", e.elt, e.datum, e.datum)
 }
 
 // intValue reports whether v is an integer convertible to int64, and if so
@@ -405,47 +921,88 @@ func intValue(v any) (int64, bool) {
 	return 0, false
 }
 
-func unparseFloat(f float64) []byte {
-	return unparseInt(0x20, math.Float64bits(f))
-}
-
-func numBytes(v uint64) int {
-	nb := 1
-	for s := uint64(256); nb < 8 && s <= v; s *= 256 {
-		nb++
+// integerValue reports whether v is an integer, and if so converts it to an
+// int64 if it is in range, otherwise to a uint64 or, if it is too large for
+// that, a *big.Int. It reports an error wrapping ErrOverflow if v is an
+// integer that cannot be encoded.
+func integerValue(v any) (any, bool, error) {
+	if z, ok := intValue(v); ok {
+		return z, true, nil
 	}
-	return nb
-}
-
-func intSize(v uint64) (nb, p2 int) {
-	nb = 1
-	for s := uint64(256); nb < 8 && s <= v; s *= s {
-		nb *= 2
-		p2++
+	var u uint64
+	switch t := v.(type) {
+	case int8:
+		return int64(t), true, nil
+	case int16:
+		return int64(t), true, nil
+	case uint:
+		u = uint64(t)
+	case uint8:
+		u = uint64(t)
+	case uint16:
+		u = uint64(t)
+	case uint32:
+		u = uint64(t)
+	case uint64:
+		u = t
+	case uintptr:
+		u = uint64(t)
+	case json.Number:
+		z, ok := new(big.Int).SetString(string(t), 10)
+		if !ok {
+			return nil, false, nil
+		}
+		return bigIntegerValue(z)
+	case *big.Int:
+		if t == nil {
+			return nil, false, nil
+		}
+		return bigIntegerValue(new(big.Int).Set(t))
+	default:
+		return nil, false, nil
+	}
+	if u <= math.MaxInt64 {
+		return int64(u), true, nil
 	}
-	return nb, p2
+	return u, true, nil
 }
 
-func unparseInt(tag byte, v uint64) []byte {
-	nd, p2 := intSize(v)
-
-	var buf [9]byte
-	buf[0] = tag | byte(p2&0xf)
-	for i := nd; i > 0; i-- {
-		buf[i] = byte(v & 0xff)
-		v >>= 8
+// bigIntegerValue converts z as described for integerValue. The caller must
+// not retain z.
+func bigIntegerValue(z *big.Int) (any, bool, error) {
+	switch {
+	case z.IsInt64():
+		return z.Int64(), true, nil
+	case z.IsUint64():
+		return z.Uint64(), true, nil
+	case z.Sign() < 0 || z.BitLen() > 128:
+		return nil, true, fmt.Errorf("%w: %v", ErrOverflow, z)
 	}
-	return buf[:nd+1]
+	return z, true, nil
 }
 
-func writeData(buf *bytes.Buffer, tag byte, s string) {
-	if len(s) >= 15 {
-		buf.WriteByte(tag | 0xf)
-		buf.Write(unparseInt(0x10, uint64(len(s))))
-	} else {
-		buf.WriteByte(tag | byte(len(s)))
+// stringValue reports whether v can be encoded as a string, and if so
+// converts it to one.
+func stringValue(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case []rune:
+		return string(t), true
+	case *url.URL:
+		if t != nil {
+			return t.String(), true
+		}
+	case url.URL:
+		return t.String(), true
+	case netip.Addr:
+		return t.String(), true
+	case netip.Prefix:
+		return t.String(), true
+	case [16]byte:
+		return formatUUID(t), true
 	}
-	buf.WriteString(s)
+	return "", false
 }
 
 func isASCII(s string) bool {
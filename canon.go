@@ -0,0 +1,188 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Canonicalize returns a canonical copy of v, such that two trees that are
+// equal according to Equal have canonical copies that encode to identical
+// bytes. In the copy:
+//
+//   - The entries of each Dict are in CompareKeys order of their keys.
+//   - The elements of each Set are in a fixed order determined by their
+//     contents.
+//   - Each integer has the narrowest of the datum types int64, uint64, and
+//     *big.Int that can hold it, each time is in UTC, every NaN has the
+//     same bits, and a negative zero is positive.
+//   - Identical subtrees are represented by a single shared *Value.
+//
+// Strings need no normalization, since a Value represents both encodings of
+// a string as a Go string, and the encoder chooses the encoding for each.
+//
+// Because the result may share subtrees, changes made to one part of it may
+// appear in others. Use Clone to obtain an independent copy for editing.
+func (v *Value) Canonicalize() *Value {
+	c := canonicalizer{seen: make(map[string]canonEntry)}
+	out, _ := c.canon(v)
+	return out
+}
+
+type canonicalizer struct {
+	seen map[string]canonEntry // :: content key → interned value
+}
+
+type canonEntry struct {
+	v  *Value
+	id int
+}
+
+// canon returns the canonical form of v and its interned ID.
+func (c *canonicalizer) canon(v *Value) (*Value, int) {
+	var key strings.Builder
+	fmt.Fprintf(&key, "%d:", v.Kind())
+	out := &Value{kind: v.Kind()}
+	switch v.Kind() {
+	case KInvalid:
+		return nil, -1
+	case KArray, KOrderedSet, KSet:
+		elts := make([]canonEntry, len(v.elts))
+		for i, elt := range v.elts {
+			elts[i].v, elts[i].id = c.canon(elt)
+		}
+		if v.kind == KSet {
+			slices.SortStableFunc(elts, func(a, b canonEntry) int { return compareValues(a.v, b.v) })
+		}
+		out.elts = make([]*Value, len(elts))
+		for i, e := range elts {
+			out.elts[i] = e.v
+			fmt.Fprintf(&key, "%d,", e.id)
+		}
+	case KDict:
+		ids := make(map[*Value]int, len(v.ents))
+		out.ents = make([]Entry, len(v.ents))
+		for i, e := range v.ents {
+			cv, id := c.canon(e.Value)
+			out.ents[i] = Entry{Key: e.Key, Value: cv}
+			ids[cv] = id
+		}
+		slices.SortStableFunc(out.ents, func(a, b Entry) int { return CompareKeys(a.Key, b.Key) })
+		for _, e := range out.ents {
+			fmt.Fprintf(&key, "%q=%d,", e.Key, ids[e.Value])
+		}
+	default:
+		out.datum = canonDatum(v.datum)
+		switch t := out.datum.(type) {
+		case []byte:
+			fmt.Fprintf(&key, "%x", t)
+		case float64:
+			key.WriteString(strconv.FormatUint(math.Float64bits(t), 16))
+		case time.Time:
+			key.WriteString(t.Format(time.RFC3339Nano))
+		default:
+			fmt.Fprintf(&key, "%T:%v", t, t)
+		}
+	}
+	if e, ok := c.seen[key.String()]; ok {
+		return e.v, e.id
+	}
+	id := len(c.seen)
+	c.seen[key.String()] = canonEntry{v: out, id: id}
+	return out, id
+}
+
+// canonDatum returns the canonical form of an element datum.
+func canonDatum(datum any) any {
+	switch t := datum.(type) {
+	case uint64:
+		if t <= math.MaxInt64 {
+			return int64(t)
+		}
+	case *big.Int:
+		if t.IsInt64() {
+			return t.Int64()
+		} else if t.IsUint64() {
+			return t.Uint64()
+		}
+		return new(big.Int).Set(t)
+	case float64:
+		if math.IsNaN(t) {
+			return math.NaN()
+		} else if t == 0 {
+			return 0.0 // Equal does not distinguish -0 from +0
+		}
+	case time.Time:
+		return t.UTC()
+	case []byte:
+		return bytes.Clone(t)
+	}
+	return datum
+}
+
+// compareValues defines a total order on canonical values, first by kind and
+// then by contents.
+func compareValues(a, b *Value) int {
+	if c := cmp.Compare(a.Kind(), b.Kind()); c != 0 {
+		return c
+	}
+	switch a.Kind() {
+	case KArray, KOrderedSet, KSet:
+		return slices.CompareFunc(a.elts, b.elts, compareValues)
+	case KDict:
+		return slices.CompareFunc(a.ents, b.ents, func(x, y Entry) int {
+			if c := CompareKeys(x.Key, y.Key); c != 0 {
+				return c
+			}
+			return compareValues(x.Value, y.Value)
+		})
+	}
+	switch x := a.datum.(type) {
+	case bool:
+		y, _ := b.datum.(bool)
+		if x == y {
+			return 0
+		} else if !x {
+			return -1
+		}
+		return 1
+	case float64:
+		y, _ := b.datum.(float64)
+		return cmp.Compare(x, y)
+	case time.Time:
+		y, _ := b.datum.(time.Time)
+		return x.Compare(y)
+	case []byte:
+		y, _ := b.datum.([]byte)
+		return bytes.Compare(x, y)
+	case string:
+		y, _ := b.datum.(string)
+		return CompareKeys(x, y)
+	}
+	if x, ok := bigIntValue(a.datum); ok {
+		if y, ok := bigIntValue(b.datum); ok {
+			return x.Cmp(y)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a.datum), fmt.Sprint(b.datum))
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Program plroundtrip checks that binary property list files survive a round
+// trip through the parser and builder of the bplist package.
+//
+// Usage:
+//
+//	plroundtrip [-r] [-v] path ...
+//
+// Each path names a file to check. With -r, directories are searched
+// recursively, and every file in them that begins with a binary property list
+// header is checked. The program exits with status 1 if any check fails.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/creachadair/bplist"
+)
+
+var (
+	doRecur   = flag.Bool("r", false, "Recursively check files in directories")
+	doVerbose = flag.Bool("v", false, "Report files that pass as well as failures")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-r] [-v] path ...\n\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var nok, nfail int
+	check := func(path string, explicit bool) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			nfail++
+			return
+		} else if !explicit && !bytes.HasPrefix(data, []byte("bplist")) {
+			return // not a binary property list
+		}
+		if err := bplist.RoundTrip(data); err != nil {
+			fmt.Printf("FAIL %s: %v\n", path, err)
+			nfail++
+			return
+		}
+		if *doVerbose {
+			fmt.Printf("ok   %s\n", path)
+		}
+		nok++
+	}
+
+	for _, arg := range flag.Args() {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			log.Printf("%s: %v", arg, err)
+			nfail++
+			continue
+		}
+		if !fi.IsDir() {
+			check(arg, true)
+			continue
+		} else if !*doRecur {
+			log.Printf("%s: is a directory (use -r to search it)", arg)
+			nfail++
+			continue
+		}
+		if err := filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				nfail++
+			} else if d.Type().IsRegular() {
+				check(path, false)
+			}
+			return nil
+		}); err != nil {
+			log.Fatalf("Walk %s: %v", arg, err)
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", nok, nfail)
+	if nfail != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import "unicode/utf8"
+
+// CompareKeys compares dictionary keys a and b in the order CoreFoundation
+// uses when it sorts keys for output, returning -1 if a < b, 0 if a == b, and
+// +1 if a > b. Use it to sort keys for canonical or CoreFoundation-compatible
+// output.
+//
+// CoreFoundation sorts keys with CFStringCompare and no comparison flags,
+// which orders strings literally by their UTF-16 code units. There is no
+// locale-sensitive, case-insensitive, or numeric ordering. For most keys this
+// agrees with Go's native string order, but it differs for characters outside
+// the Basic Multilingual Plane: these are encoded as surrogate pairs, which
+// sort below the code points U+E000 to U+FFFF, whereas in UTF-8 they sort
+// above. Invalid UTF-8 sequences compare as U+FFFD.
+func CompareKeys(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if ra != rb {
+			ua, ub := utf16Lead(ra), utf16Lead(rb)
+			if ua == ub {
+				// Both are supplementary with the same lead surrogate, so the
+				// trail surrogates order the same as the code points.
+				ua, ub = ra, rb
+			}
+			if ua < ub {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[na:], b[nb:]
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// utf16Lead returns the first UTF-16 code unit of the encoding of r.
+func utf16Lead(r rune) rune {
+	if r < 0x10000 {
+		return r
+	}
+	return 0xd800 + (r-0x10000)>>10
+}
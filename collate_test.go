@@ -0,0 +1,45 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"testing"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestCompareKeys(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "a", -1},
+		{"a", "", 1},
+		{"abc", "abc", 0},
+		{"B", "a", -1},    // no case folding
+		{"a10", "a9", -1}, // no numeric ordering
+		{"é", "f", 1},
+		{"\U0001F600", "！", -1}, // surrogates sort before U+E000..U+FFFF
+		{"！", "\U0001F600", 1},
+		{"\U0001F600", "\U0001F601", -1},
+		{"\U0001F600", "é", 1},
+	}
+	for _, tc := range tests {
+		if got := bplist.CompareKeys(tc.a, tc.b); got != tc.want {
+			t.Errorf("CompareKeys(%q, %q): got %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance provides a corpus of binary property lists written by
+// Python's plistlib module, with the logical content each one should decode
+// to. It checks that the bplist parser agrees with plistlib on a matrix of
+// value types and sizes, and that a caller's encoding pipeline preserves the
+// same content.
+//
+// The corpus is generated by testdata/gen.py, which also computes the
+// expected transcript of each case. To regenerate it, run:
+//
+//	python3 testdata/gen.py
+package conformance
+
+import (
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creachadair/bplist"
+)
+
+//go:embed testdata/manifest.json testdata/plistlib
+var corpus embed.FS
+
+// A Case is a single entry in the conformance corpus.
+type Case struct {
+	Name  string // a unique name for the case, e.g., "int/u16max"
+	Input []byte // the binary property list written by plistlib
+	Want  string // the expected transcript (see Transcript)
+}
+
+// Cases returns the cases in the corpus, ordered by name.
+func Cases() []Case {
+	data, err := corpus.ReadFile("testdata/manifest.json")
+	if err != nil {
+		panic(fmt.Sprintf("reading manifest: %v", err))
+	}
+	var manifest []struct {
+		Name string `json:"name"`
+		File string `json:"file"`
+		Want string `json:"want"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		panic(fmt.Sprintf("decoding manifest: %v", err))
+	}
+	cases := make([]Case, len(manifest))
+	for i, m := range manifest {
+		input, err := corpus.ReadFile("testdata/" + m.File)
+		if err != nil {
+			panic(fmt.Sprintf("reading case %q: %v", m.Name, err))
+		}
+		cases[i] = Case{Name: m.Name, Input: input, Want: m.Want}
+	}
+	return cases
+}
+
+// Transcript parses data with bplist.Parse and returns a canonical transcript
+// of its logical content, as a space-separated sequence of tokens:
+//
+//	bool:true  int:-1  real:<IEEE 754 bits in hex>  date:<Unix seconds>
+//	data:<hex>  string:<hex of UTF-8>  array(n) ... end  dict(n) ... end
+//
+// Strings are reported the same way regardless of their encoding in the file,
+// and dictionary entries appear as alternating keys and values.
+func Transcript(data []byte) (string, error) {
+	var t transcriber
+	if err := bplist.Parse(data, &t); err != nil {
+		return "", err
+	}
+	return strings.Join(t.toks, " "), nil
+}
+
+// Check verifies each case in the corpus against the bplist parser. If
+// pipeline != nil, Check also passes the input of each case through it, and
+// verifies that the output of the pipeline has the expected transcript. Use
+// this to check tools that re-encode property lists.
+func Check(t testing.TB, pipeline func([]byte) ([]byte, error)) {
+	t.Helper()
+	for _, c := range Cases() {
+		got, err := Transcript(c.Input)
+		if err != nil {
+			t.Errorf("Case %s: parse failed: %v", c.Name, err)
+			continue
+		} else if got != c.Want {
+			t.Errorf("Case %s: parse:\n got: %s\nwant: %s", c.Name, got, c.Want)
+			continue
+		}
+		if pipeline == nil {
+			continue
+		}
+		out, err := pipeline(c.Input)
+		if err != nil {
+			t.Errorf("Case %s: pipeline failed: %v", c.Name, err)
+			continue
+		}
+		got, err = Transcript(out)
+		if err != nil {
+			t.Errorf("Case %s: parse pipeline output failed: %v", c.Name, err)
+		} else if got != c.Want {
+			t.Errorf("Case %s: pipeline output:\n got: %s\nwant: %s", c.Name, got, c.Want)
+		}
+	}
+}
+
+type transcriber struct{ toks []string }
+
+func (t *transcriber) add(s string, args ...any) { t.toks = append(t.toks, fmt.Sprintf(s, args...)) }
+
+func (*transcriber) Version(string) error { return nil }
+
+func (t *transcriber) Value(typ bplist.Type, datum any) error {
+	switch typ {
+	case bplist.TBool:
+		t.add("bool:%v", datum)
+	case bplist.TInteger:
+		t.add("int:%v", datum)
+	case bplist.TFloat:
+		t.add("real:%016x", math.Float64bits(datum.(float64)))
+	case bplist.TTime:
+		t.add("date:%d", datum.(time.Time).Unix())
+	case bplist.TBytes:
+		t.add("data:%s", hex.EncodeToString(datum.([]byte)))
+	case bplist.TString:
+		t.add("string:%s", hex.EncodeToString([]byte(datum.(string))))
+	case bplist.TUnicode:
+		s := string(datum.([]rune))
+		t.add("string:%s", hex.EncodeToString([]byte(s)))
+	case bplist.TUID:
+		t.add("uid:%s", hex.EncodeToString(datum.([]byte)))
+	default:
+		t.add("%v", typ)
+	}
+	return nil
+}
+
+func (t *transcriber) Open(coll bplist.Collection, n int) error {
+	t.add("%v(%d)", coll, n)
+	return nil
+}
+
+func (t *transcriber) Close(bplist.Collection) error {
+	t.add("end")
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/creachadair/bplist"
+	"github.com/creachadair/bplist/conformance"
+)
+
+func TestParse(t *testing.T) { conformance.Check(t, nil) }
+
+func TestBuilder(t *testing.T) { conformance.Check(t, rebuild) }
+
+// rebuild parses a property list and re-encodes it with a Builder.
+func rebuild(data []byte) ([]byte, error) {
+	var root node
+	stk := []*node{&root}
+	h := handler{
+		value: func(typ bplist.Type, datum any) {
+			top := stk[len(stk)-1]
+			if b, ok := datum.([]byte); ok {
+				datum = bytes.Clone(b)
+			}
+			top.kids = append(top.kids, &node{typ: typ, datum: datum})
+		},
+		open: func(coll bplist.Collection) {
+			top := stk[len(stk)-1]
+			n := &node{coll: coll}
+			top.kids = append(top.kids, n)
+			stk = append(stk, n)
+		},
+		close: func() { stk = stk[:len(stk)-1] },
+	}
+	if err := bplist.Parse(data, h); err != nil {
+		return nil, err
+	}
+
+	b := bplist.NewBuilder()
+	root.kids[0].build(b)
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type node struct {
+	coll  bplist.Collection
+	typ   bplist.Type
+	datum any
+	kids  []*node
+}
+
+func (n *node) build(b *bplist.Builder) {
+	if n.coll == 0 {
+		b.Value(n.typ, n.datum)
+		return
+	}
+	b.Open(n.coll, func(b *bplist.Builder) {
+		for _, kid := range n.kids {
+			kid.build(b)
+		}
+	})
+}
+
+type handler struct {
+	value func(bplist.Type, any)
+	open  func(bplist.Collection)
+	close func()
+}
+
+func (handler) Version(string) error { return nil }
+
+func (h handler) Value(typ bplist.Type, datum any) error { h.value(typ, datum); return nil }
+
+func (h handler) Open(coll bplist.Collection, _ int) error { h.open(coll); return nil }
+
+func (h handler) Close(bplist.Collection) error { h.close(); return nil }
@@ -0,0 +1,130 @@
+#!/usr/bin/env python3
+#
+# Generate the conformance corpus from Python's plistlib.
+#
+# Usage: python3 gen.py
+#
+# Each case is written to plistlib/<name>.bplist, and manifest.json lists the
+# cases with their expected event transcripts, in the format produced by
+# conformance.Transcript.
+
+import datetime
+import json
+import os
+import plistlib
+import struct
+
+here = os.path.dirname(os.path.abspath(__file__))
+
+
+def transcript(v):
+    """Return the transcript tokens for value v."""
+    if isinstance(v, bool):
+        return ['bool:' + ('true' if v else 'false')]
+    if isinstance(v, int):
+        return ['int:%d' % v]
+    if isinstance(v, float):
+        return ['real:%016x' % struct.unpack('>Q', struct.pack('>d', v))[0]]
+    if isinstance(v, datetime.datetime):
+        epoch = datetime.datetime(1970, 1, 1)
+        return ['date:%d' % int((v - epoch).total_seconds())]
+    if isinstance(v, bytes):
+        return ['data:' + v.hex()]
+    if isinstance(v, str):
+        return ['string:' + v.encode('utf-8').hex()]
+    if isinstance(v, list):
+        out = ['array(%d)' % len(v)]
+        for elt in v:
+            out += transcript(elt)
+        return out + ['end']
+    if isinstance(v, dict):
+        out = ['dict(%d)' % len(v)]
+        for key in sorted(v):  # plistlib sorts keys by default
+            out += transcript(key) + transcript(v[key])
+        return out + ['end']
+    raise TypeError('unsupported value: %r' % (v,))
+
+
+cases = {
+    # Integers, around each encoded width.
+    'int/zero': 0,
+    'int/one': 1,
+    'int/u8max': 255,
+    'int/u16min': 256,
+    'int/u16max': 65535,
+    'int/u32min': 65536,
+    'int/u32max': 2**32 - 1,
+    'int/u64min': 2**32,
+    'int/i64max': 2**63 - 1,
+    'int/neg1': -1,
+    'int/i64min': -2**63,
+
+    # Reals.
+    'real/zero': 0.0,
+    'real/frac': 1.5,
+    'real/neg': -2.25,
+    'real/large': 1e300,
+    'real/subnormal': 5e-324,
+    'real/inf': float('inf'),
+
+    # Booleans.
+    'bool/true': True,
+    'bool/false': False,
+
+    # Strings, around the inline size limit.
+    'string/empty': '',
+    'string/short': 'hello',
+    'string/len14': 'a' * 14,
+    'string/len15': 'b' * 15,
+    'string/len16': 'c' * 16,
+    'string/long': 'long string ' * 25,
+    'string/latin1': 'café',
+    'string/cjk': '日本語のテキスト',
+    'string/emoji': 'smile \U0001F600',
+
+    # Data.
+    'data/empty': b'',
+    'data/len15': bytes(range(15)),
+    'data/long': bytes(range(256)) * 2,
+
+    # Dates, at whole seconds.
+    'date/epoch2001': datetime.datetime(2001, 1, 1),
+    'date/epoch1970': datetime.datetime(1970, 1, 1),
+    'date/future': datetime.datetime(2030, 6, 15, 12, 30, 45),
+
+    # Collections.
+    'array/empty': [],
+    'array/mixed': [1, 'two', 3.0, True, b'\x05'],
+    'array/len15': list(range(15)),
+    'array/nested': [[1, [2, [3, []]]], {'k': ['v']}],
+    'array/wide': list(range(1000)),
+    'dict/empty': {},
+    'dict/simple': {'CFBundleIdentifier': 'com.example.app', 'Count': 3},
+    'dict/many': {'key%02d' % i: i for i in range(20)},
+    'dict/unicode': {'clé': 'valeur', 'キー': '値'},
+    'dict/nested': {'outer': {'inner': {'leaf': [1, 2]}}, 'list': [{}, {'a': 'b'}]},
+    'dict/shared': {'a': 'same', 'b': 'same', 'c': ['same', 'same']},
+}
+
+
+def main():
+    outdir = os.path.join(here, 'plistlib')
+    os.makedirs(outdir, exist_ok=True)
+    manifest = []
+    for name in sorted(cases):
+        value = cases[name]
+        file = name.replace('/', '-') + '.bplist'
+        with open(os.path.join(outdir, file), 'wb') as f:
+            plistlib.dump(value, f, fmt=plistlib.FMT_BINARY)
+        manifest.append({
+            'name': name,
+            'file': 'plistlib/' + file,
+            'want': ' '.join(transcript(value)),
+        })
+    with open(os.path.join(here, 'manifest.json'), 'w') as f:
+        json.dump(manifest, f, indent=1, ensure_ascii=False)
+        f.write('\n')
+
+
+if __name__ == '__main__':
+    main()
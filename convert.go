@@ -0,0 +1,368 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A DurationFormat selects how a time.Duration is represented in a property
+// list. The format has no native duration type, so a tool reading a duration
+// must agree with the tool that wrote it.
+type DurationFormat int
+
+// Constants defining the duration formats.
+const (
+	DurationSeconds DurationFormat = iota // TFloat, in seconds (the default)
+	DurationNanos                         // TInteger, in nanoseconds
+	DurationISO8601                       // TString, e.g., "PT1H30M"
+)
+
+func (f DurationFormat) String() string {
+	switch f {
+	case DurationSeconds:
+		return "seconds"
+	case DurationNanos:
+		return "nanoseconds"
+	case DurationISO8601:
+		return "ISO-8601"
+	}
+	return "unknown"
+}
+
+// Encode returns the type and datum representing d in format f.
+// An unknown format is treated as DurationSeconds.
+func (f DurationFormat) Encode(d time.Duration) (Type, any) {
+	switch f {
+	case DurationNanos:
+		return TInteger, int64(d)
+	case DurationISO8601:
+		return TString, formatISODuration(d)
+	}
+	return TFloat, d.Seconds()
+}
+
+// Decode converts a datum of the given type, in format f, to a duration.
+// It is the inverse of Encode. For convenience, DurationSeconds also accepts
+// an integer number of seconds.
+func (f DurationFormat) Decode(typ Type, datum any) (time.Duration, error) {
+	switch f {
+	case DurationNanos:
+		if typ == TInteger {
+			if v, ok := datum.(int64); ok {
+				return time.Duration(v), nil
+			}
+		}
+	case DurationISO8601:
+		if typ == TString || typ == TUnicode {
+			if r, ok := datum.([]rune); ok {
+				datum = string(r)
+			}
+			if s, ok := datum.(string); ok {
+				return parseISODuration(s)
+			}
+		}
+	default:
+		switch v := datum.(type) {
+		case float64:
+			if typ == TFloat {
+				ns := math.Round(v * float64(time.Second))
+				if math.IsNaN(ns) || ns >= 1<<63 || ns < -(1<<63) {
+					return 0, fmt.Errorf("duration %v seconds out of range", v)
+				}
+				return time.Duration(ns), nil
+			}
+		case int64:
+			if typ == TInteger {
+				if v > math.MaxInt64/int64(time.Second) || v < math.MinInt64/int64(time.Second) {
+					return 0, fmt.Errorf("duration %v seconds out of range", v)
+				}
+				return time.Duration(v) * time.Second, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid %v datum %T for %s duration", typ, datum, f)
+}
+
+// formatISODuration formats d as an ISO 8601 duration, using only hours,
+// minutes, and (possibly fractional) seconds, e.g., "PT1H2M3.5S".
+func formatISODuration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+	var sb strings.Builder
+	u := uint64(d)
+	if d < 0 {
+		sb.WriteByte('-')
+		u = -u
+	}
+	sb.WriteString("PT")
+	h, u := u/uint64(time.Hour), u%uint64(time.Hour)
+	m, u := u/uint64(time.Minute), u%uint64(time.Minute)
+	if h != 0 {
+		sb.WriteString(strconv.FormatUint(h, 10) + "H")
+	}
+	if m != 0 {
+		sb.WriteString(strconv.FormatUint(m, 10) + "M")
+	}
+	if u != 0 {
+		s, ns := u/uint64(time.Second), u%uint64(time.Second)
+		sb.WriteString(strconv.FormatUint(s, 10))
+		if ns != 0 {
+			frac := strings.TrimRight(fmt.Sprintf("%09d", ns), "0")
+			sb.WriteString("." + frac)
+		}
+		sb.WriteByte('S')
+	}
+	return sb.String()
+}
+
+// parseISODuration parses an ISO 8601 duration of the form PnDTnHnMnS, with
+// an optional leading sign. Year, month, and week designators are rejected,
+// since their length is not fixed. Only the seconds may have a fraction.
+func parseISODuration(s string) (time.Duration, error) {
+	orig := s
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	if !strings.HasPrefix(s, "P") || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+	s = s[1:]
+
+	var total uint64 // the magnitude in nanoseconds
+	limit := uint64(math.MaxInt64)
+	if neg {
+		limit++ // the magnitude of math.MinInt64
+	}
+	inTime := false
+	for s != "" {
+		if s[0] == 'T' && !inTime {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		whole, frac, hasFrac := strings.Cut(s[:i], ".")
+		if strings.Contains(frac, ".") || (whole == "" && frac == "") {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		var unit uint64
+		switch d := s[i]; {
+		case d == 'D' && !inTime:
+			unit = uint64(24 * time.Hour)
+		case d == 'H' && inTime:
+			unit = uint64(time.Hour)
+		case d == 'M' && inTime:
+			unit = uint64(time.Minute)
+		case d == 'S' && inTime:
+			unit = uint64(time.Second)
+		default:
+			return 0, fmt.Errorf("invalid duration %q: unsupported designator %q", orig, d)
+		}
+		if unit != uint64(time.Second) && hasFrac {
+			return 0, fmt.Errorf("invalid duration %q: only seconds may be fractional", orig)
+		}
+
+		// The whole part contains only digits, so ParseUint fails only if it
+		// is out of range.
+		var v uint64
+		if whole != "" {
+			var err error
+			v, err = strconv.ParseUint(whole, 10, 64)
+			if err != nil || v > limit/unit {
+				return 0, fmt.Errorf("duration %q out of range", orig)
+			}
+		}
+		n := v*unit + fracNanos(frac)
+		if n > limit-total {
+			return 0, fmt.Errorf("duration %q out of range", orig)
+		}
+		total += n
+		s = s[i+1:]
+	}
+	if neg {
+		return time.Duration(-total), nil
+	}
+	return time.Duration(total), nil
+}
+
+// fracNanos returns the number of nanoseconds represented by the decimal
+// digits of a fraction of a second, rounded to the nearest nanosecond.
+func fracNanos(frac string) uint64 {
+	roundUp := false
+	if len(frac) > 9 {
+		roundUp = frac[9] >= '5'
+		frac = frac[:9]
+	}
+	ns, _ := strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	if roundUp {
+		ns++
+	}
+	return ns
+}
+
+// DecodeURL converts a TString datum to a URL.
+func DecodeURL(typ Type, datum any) (*url.URL, error) {
+	s, err := decodeString(typ, datum)
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(s)
+}
+
+// DecodeAddr converts a TString datum to an IP address.
+func DecodeAddr(typ Type, datum any) (netip.Addr, error) {
+	s, err := decodeString(typ, datum)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.ParseAddr(s)
+}
+
+// DecodePrefix converts a TString datum to an IP network prefix.
+func DecodePrefix(typ Type, datum any) (netip.Prefix, error) {
+	s, err := decodeString(typ, datum)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.ParsePrefix(s)
+}
+
+// DecodeUUID converts a datum to a UUID. The datum may be either TBytes with
+// exactly 16 bytes, or a TString in the canonical 8-4-4-4-12 hex format.
+func DecodeUUID(typ Type, datum any) ([16]byte, error) {
+	var u [16]byte
+	if b, ok := datum.([]byte); ok && typ == TBytes {
+		if len(b) != len(u) {
+			return u, fmt.Errorf("invalid UUID length %d", len(b))
+		}
+		copy(u[:], b)
+		return u, nil
+	}
+	s, err := decodeString(typ, datum)
+	if err != nil {
+		return u, err
+	}
+	return parseUUID(s)
+}
+
+// decodeString converts a TString or TUnicode datum to a string.
+func decodeString(typ Type, datum any) (string, error) {
+	if typ == TString || typ == TUnicode {
+		switch t := datum.(type) {
+		case string:
+			return t, nil
+		case []rune:
+			return string(t), nil
+		}
+	}
+	return "", fmt.Errorf("invalid %v datum %T for a string", typ, datum)
+}
+
+func formatUUID(u [16]byte) string {
+	h := hex.EncodeToString(u[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func parseUUID(s string) ([16]byte, error) {
+	var u [16]byte
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	h := s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(u[:], []byte(h)); err != nil {
+		return u, fmt.Errorf("invalid UUID %q: %w", s, err)
+	}
+	return u, nil
+}
+
+// RegisterConverter registers functions that Marshal and Unmarshal use to
+// convert values of type T to and from property list values, in place of
+// the default rules for T. The encode function returns the type and datum
+// to encode for a value, which must be acceptable to Builder.Value. The
+// decode function converts a type and datum to a value.
+//
+// Either function may be nil, in which case the default rules apply in that
+// direction. Registering a converter for T replaces any existing one;
+// registering nil for both functions restores the default rules for T.
+//
+// For example, to encode a UUID type as a string in the usual format:
+//
+//	type UUID [16]byte
+//
+//	bplist.RegisterConverter(
+//	   func(u UUID) (bplist.Type, any, error) {
+//	      return bplist.TString, [16]byte(u), nil
+//	   },
+//	   func(typ bplist.Type, datum any) (UUID, error) {
+//	      u, err := bplist.DecodeUUID(typ, datum)
+//	      return UUID(u), err
+//	   },
+//	)
+//
+// RegisterConverter is safe for concurrent use, but converters registered
+// while Marshal or Unmarshal is in progress may not be seen by that call.
+func RegisterConverter[T any](encode func(T) (Type, any, error), decode func(Type, any) (T, error)) {
+	t := reflect.TypeFor[T]()
+	if encode == nil && decode == nil {
+		converters.Delete(t)
+		return
+	}
+	c := new(converter)
+	if encode != nil {
+		c.encode = func(v reflect.Value) (Type, any, error) {
+			return encode(v.Interface().(T))
+		}
+	}
+	if decode != nil {
+		c.decode = func(typ Type, datum any) (reflect.Value, error) {
+			v, err := decode(typ, datum)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(&v).Elem(), nil
+		}
+	}
+	converters.Store(t, c)
+}
+
+// converters holds registered converters, keyed by reflect.Type.
+var converters sync.Map
+
+// A converter is a type-erased pair of functions from RegisterConverter.
+type converter struct {
+	encode func(reflect.Value) (Type, any, error)
+	decode func(Type, any) (reflect.Value, error)
+}
+
+// lookupConverter returns the converter registered for t, or nil.
+func lookupConverter(t reflect.Type) *converter {
+	if c, ok := converters.Load(t); ok {
+		return c.(*converter)
+	}
+	return nil
+}
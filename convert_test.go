@@ -0,0 +1,231 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestDurations(t *testing.T) {
+	durations := []time.Duration{
+		0,
+		1500 * time.Millisecond,
+		-90 * time.Minute,
+		26*time.Hour + 3*time.Second + 25*time.Nanosecond,
+	}
+	formats := []bplist.DurationFormat{
+		bplist.DurationSeconds, bplist.DurationNanos, bplist.DurationISO8601,
+	}
+	for _, f := range formats {
+		for _, d := range durations {
+			typ, datum := f.Encode(d)
+			got, err := f.Decode(typ, datum)
+			if err != nil {
+				t.Errorf("Decode %s %v: unexpected error: %v", f, datum, err)
+			} else if got != d {
+				t.Errorf("Decode %s %v: got %v, want %v", f, datum, got, d)
+			}
+		}
+	}
+
+	t.Run("ISO8601", func(t *testing.T) {
+		tests := []struct {
+			input string
+			want  time.Duration
+		}{
+			{"PT0S", 0},
+			{"P1D", 24 * time.Hour},
+			{"P1DT2H", 26 * time.Hour},
+			{"PT1.25S", 1250 * time.Millisecond},
+			{"-PT5M", -5 * time.Minute},
+			{"PT.5S", 500 * time.Millisecond},
+			{"PT1.0000000015S", time.Second + 2},
+			{"PT2093077H52M41.39600511S", 2093077*time.Hour + 52*time.Minute + 41396005110},
+			{"PT2562047H47M16.854775807S", math.MaxInt64},
+			{"-PT2562047H47M16.854775808S", math.MinInt64},
+			{"P106751DT23H47M16.854775807S", math.MaxInt64},
+		}
+		for _, tc := range tests {
+			got, err := bplist.DurationISO8601.Decode(bplist.TString, tc.input)
+			if err != nil {
+				t.Errorf("Decode %q: unexpected error: %v", tc.input, err)
+			} else if got != tc.want {
+				t.Errorf("Decode %q: got %v, want %v", tc.input, got, tc.want)
+			}
+		}
+		for _, bad := range []string{
+			"", "P", "PT", "1H", "P1Y", "P1M", "PT1.5H", "PTxS", "PT.S", "PT1.2.3S",
+			"PT2562047H47M16.854775808S", "-PT2562047H47M16.854775809S",
+			"P106752D", "PT99999999999999999999H",
+		} {
+			if got, err := bplist.DurationISO8601.Decode(bplist.TString, bad); err == nil {
+				t.Errorf("Decode %q: got %v, wanted an error", bad, got)
+			}
+		}
+	})
+
+	t.Run("Seconds", func(t *testing.T) {
+		for _, d := range []time.Duration{
+			2*time.Hour + 16*time.Minute + 50*time.Second + 884491574,
+			-(23*time.Hour + 59*time.Minute + 59*time.Second + 999999999),
+			1,
+		} {
+			typ, datum := bplist.DurationSeconds.Encode(d)
+			if got, err := bplist.DurationSeconds.Decode(typ, datum); err != nil || got != d {
+				t.Errorf("Decode %v: got (%v, %v), want %v", datum, got, err, d)
+			}
+		}
+		for _, bad := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.MaxInt64 / 1e9, -1e10} {
+			if got, err := bplist.DurationSeconds.Decode(bplist.TFloat, bad); err == nil {
+				t.Errorf("Decode %v: got %v, wanted an error", bad, got)
+			}
+		}
+	})
+
+	t.Run("Builder", func(t *testing.T) {
+		b := bplist.NewBuilder()
+		b.SetOptions(&bplist.BuilderOptions{Durations: bplist.DurationISO8601})
+		b.Duration(90 * time.Minute)
+
+		var buf bytes.Buffer
+		if _, err := b.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+		var out bytes.Buffer
+		if err := bplist.Parse(buf.Bytes(), testHandler{log: t.Logf, buf: &out}); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		const want = `V"00"(string=PT1H30M)`
+		if got := out.String(); got != want {
+			t.Errorf("Parse: got %s, want %s", got, want)
+		}
+	})
+}
+
+func TestStdlibTypes(t *testing.T) {
+	u, _ := url.Parse("https://example.com/a?b=c")
+	addr := netip.MustParseAddr("192.0.2.1")
+	pfx := netip.MustParsePrefix("2001:db8::/32")
+	uuid := [16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8}
+
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TString, u)
+		b.Value(bplist.TString, addr)
+		b.Value(bplist.TString, pfx)
+		b.Value(bplist.TString, uuid)
+		b.Value(bplist.TBytes, uuid)
+	})
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	type elt struct {
+		typ   bplist.Type
+		datum any
+	}
+	var elts []elt
+	h := testHandler{log: t.Logf, buf: io.Discard}
+	opts := bplist.ParseOptions{Transform: make(map[bplist.Type]func(any) (any, error))}
+	for _, typ := range []bplist.Type{bplist.TString, bplist.TBytes} {
+		opts.Transform[typ] = func(v any) (any, error) {
+			elts = append(elts, elt{typ, v})
+			return v, nil
+		}
+	}
+	if err := opts.Parse(buf.Bytes(), h); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(elts) != 5 {
+		t.Fatalf("Got %d elements, want 5", len(elts))
+	}
+
+	if got, err := bplist.DecodeURL(elts[0].typ, elts[0].datum); err != nil || got.String() != u.String() {
+		t.Errorf("DecodeURL: got (%v, %v), want %v", got, err, u)
+	}
+	if got, err := bplist.DecodeAddr(elts[1].typ, elts[1].datum); err != nil || got != addr {
+		t.Errorf("DecodeAddr: got (%v, %v), want %v", got, err, addr)
+	}
+	if got, err := bplist.DecodePrefix(elts[2].typ, elts[2].datum); err != nil || got != pfx {
+		t.Errorf("DecodePrefix: got (%v, %v), want %v", got, err, pfx)
+	}
+	if s := elts[3].datum; s != "12345678-9abc-def0-0102-030405060708" {
+		t.Errorf("UUID string: got %q", s)
+	}
+	for _, e := range elts[3:] {
+		if got, err := bplist.DecodeUUID(e.typ, e.datum); err != nil || got != uuid {
+			t.Errorf("DecodeUUID %v: got (%x, %v), want %x", e.typ, got, err, uuid)
+		}
+	}
+}
+
+type testUUID [16]byte
+
+func TestRegisterConverter(t *testing.T) {
+	bplist.RegisterConverter(
+		func(u testUUID) (bplist.Type, any, error) { return bplist.TString, [16]byte(u), nil },
+		func(typ bplist.Type, datum any) (testUUID, error) {
+			u, err := bplist.DecodeUUID(typ, datum)
+			return testUUID(u), err
+		},
+	)
+	bplist.RegisterConverter(
+		func(d time.Duration) (bplist.Type, any, error) {
+			typ, datum := bplist.DurationISO8601.Encode(d)
+			return typ, datum, nil
+		},
+		bplist.DurationISO8601.Decode,
+	)
+	defer bplist.RegisterConverter[testUUID](nil, nil)
+	defer bplist.RegisterConverter[time.Duration](nil, nil)
+
+	type rec struct {
+		ID   testUUID
+		Wait time.Duration
+	}
+	in := rec{
+		ID:   testUUID{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8},
+		Wait: 90 * time.Minute,
+	}
+	data, err := bplist.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var out rec
+	if err := bplist.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if out != in {
+		t.Errorf("Unmarshal: got %+v, want %+v", out, in)
+	}
+	got, err := bplist.ParseAny(data)
+	if err != nil {
+		t.Fatalf("ParseAny failed: %v", err)
+	}
+	want := map[string]any{"ID": "12345678-9abc-def0-0102-030405060708", "Wait": "PT1H30M"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAny: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import "errors"
+
+// Fluent is a chainable interface to a Builder, for constructing small
+// property lists concisely. Its methods do not report errors; instead, the
+// first error is recorded by the Builder and reported by its Err, WriteTo,
+// and Bytes methods, and the remaining calls in the chain have no effect.
+//
+// For example:
+//
+//	b := bplist.NewBuilder()
+//	b.Fluent().Dict().
+//	  Str("Name", "x").
+//	  Int("Count", 3).
+//	  Key("Tags").Array().Value(bplist.TString, "a").End().
+//	  End()
+//	data, err := b.Bytes()
+type Fluent struct{ b *Builder }
+
+// Fluent returns a chainable interface to b.
+func (b *Builder) Fluent() Fluent { return Fluent{b: b} }
+
+// Dict opens a new Dict, as for Builder.OpenColl.
+func (f Fluent) Dict() Fluent { f.b.open(Dict); return f }
+
+// Array opens a new Array, as for Builder.OpenColl.
+func (f Fluent) Array() Fluent { f.b.open(Array); return f }
+
+// End closes the innermost open collection, as for Builder.CloseColl.
+func (f Fluent) End() Fluent {
+	if n := f.b.innermost(); n < 0 {
+		f.b.invalid(errors.New("end with no open collection"))
+	} else {
+		f.b.close(f.b.stk[n].coll)
+	}
+	return f
+}
+
+// Key adds a dictionary key, as for Builder.Key.
+func (f Fluent) Key(key string) Fluent { f.b.Key(key); return f }
+
+// Value adds a single data element, as for Builder.Value.
+func (f Fluent) Value(typ Type, datum any) Fluent { f.b.Value(typ, datum); return f }
+
+// Str adds a dictionary entry with a string value.
+func (f Fluent) Str(key, s string) Fluent { f.b.Pair(key, TString, s); return f }
+
+// Int adds a dictionary entry with an integer value.
+func (f Fluent) Int(key string, z int64) Fluent { f.b.Pair(key, TInteger, z); return f }
+
+// Float adds a dictionary entry with a floating-point value.
+func (f Fluent) Float(key string, v float64) Fluent { f.b.Pair(key, TFloat, v); return f }
+
+// Bool adds a dictionary entry with a Boolean value.
+func (f Fluent) Bool(key string, v bool) Fluent { f.b.Pair(key, TBool, v); return f }
+
+// Err reports the error recorded by the Builder, if any.
+func (f Fluent) Err() error { return f.b.Err() }
@@ -0,0 +1,56 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestFluent(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Fluent().Dict().
+		Str("Name", "x").
+		Int("Count", 3).
+		Bool("OK", true).
+		Key("Tags").Array().Value(bplist.TString, "a").Value(bplist.TFloat, 1.5).End().
+		End()
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	var buf strings.Builder
+	if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	const want = `V"00"<dict size=4>(string=Name)(string=x)(string=Count)(int=3)(string=OK)(bool=true)` +
+		`(string=Tags)<array size=2>(string=a)(float=1.5)</array></dict>`
+	if got := buf.String(); got != want {
+		t.Errorf("Parse: got %s, want %s", got, want)
+	}
+
+	// Errors are recorded by the builder, and stop the chain.
+	b = bplist.NewBuilder()
+	if err := b.Fluent().Array().Str("bad", "key").Int("x", 1).End().Err(); err == nil {
+		t.Error("Key in an array: got nil, wanted an error")
+	} else if err != b.Err() {
+		t.Errorf("Err: got %v, want %v", err, b.Err())
+	}
+	if err := bplist.NewBuilder().Fluent().End().Err(); err == nil {
+		t.Error("End with no open collection: got nil, wanted an error")
+	}
+}
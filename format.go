@@ -0,0 +1,128 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Format enumerates the encodings of a property list.
+type Format int
+
+// Constants defining the property list formats.
+const (
+	UnknownFormat  Format = iota // not recognized as a property list
+	BinaryFormat                 // binary, beginning with "bplist"
+	XMLFormat                    // XML, per Apple's PropertyList-1.0 DTD
+	OpenStepFormat               // the text format of OpenStep and NeXTSTEP
+)
+
+func (f Format) String() string {
+	switch f {
+	case BinaryFormat:
+		return "binary"
+	case XMLFormat:
+		return "xml"
+	case OpenStepFormat:
+		return "openstep"
+	}
+	return "unknown"
+}
+
+// ErrUnsupportedFormat is reported by ParseAuto for input whose format is not
+// recognized, or cannot be parsed by this package.
+var ErrUnsupportedFormat = errors.New("unsupported property list format")
+
+// Detect reports the format of the property list in data, by inspecting its
+// first few bytes. It does not check that the rest of data is valid, and
+// reports UnknownFormat if the format is not recognized.
+func Detect(data []byte) Format {
+	if bytes.HasPrefix(data, []byte(magic)) {
+		return BinaryFormat
+	}
+	text := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	for _, pfx := range []string{"<?xml", "<!DOCTYPE plist", "<plist"} {
+		if bytes.HasPrefix(text, []byte(pfx)) {
+			return XMLFormat
+		}
+	}
+	if len(text) == 0 || !utf8.Valid(text) {
+		return UnknownFormat
+	}
+	switch c := text[0]; {
+	case c == '{', c == '(', c == '"', c == '<', c == '/':
+		// A dictionary, array, quoted string, data, or a comment.
+		return OpenStepFormat
+	case c == '_', c >= '0' && c <= '9', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		// An unquoted string, or a "strings file" of key = value pairs.
+		return OpenStepFormat
+	}
+	return UnknownFormat
+}
+
+// ParseAuto parses the property list in data, whose format is determined by
+// Detect, and delivers its contents to h. It calls Parse for a binary
+// property list and ParseXML for an XML property list. Any other format is
+// reported as ErrUnsupportedFormat. It uses default options; see
+// ParseOptions.ParseAuto for other settings.
+func ParseAuto(data []byte, h Handler) error { return ParseOptions{}.ParseAuto(data, h) }
+
+// ParseAuto parses the property list in data, using the options in o, as
+// described for ParseAuto.
+func (o ParseOptions) ParseAuto(data []byte, h Handler) error {
+	switch f := Detect(data); f {
+	case BinaryFormat:
+		return o.Parse(data, h)
+	case XMLFormat:
+		return o.ParseXML(data, h)
+	case UnknownFormat:
+		return ErrUnsupportedFormat
+	default:
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, f)
+	}
+}
+
+// Convert parses the property list in src, whose format is determined by
+// Detect, and writes it to dst in the format to, which must be BinaryFormat
+// or XMLFormat. The values of src are preserved, except where the XML format
+// cannot represent them: in XML, a set is written as an array, and a date is
+// truncated to a whole number of seconds. Convert reports an error without
+// writing anything if src contains a value that cannot be represented in the
+// target format, such as a null in XML.
+func Convert(dst io.Writer, src []byte, to Format) error {
+	if to != BinaryFormat && to != XMLFormat {
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, to)
+	}
+	b := NewBuilder()
+	if err := ParseAuto(src, BuilderHandler(b)); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	var err error
+	if to == XMLFormat {
+		_, err = b.WriteXML(&buf)
+	} else {
+		_, err = b.WriteTo(&buf)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(dst)
+	return err
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bplist.Format
+	}{
+		{"", bplist.UnknownFormat},
+		{"   ", bplist.UnknownFormat},
+		{"\x00\x01", bplist.UnknownFormat},
+		{testInput, bplist.BinaryFormat},
+		{"bplist00", bplist.BinaryFormat},
+		{xmlHeader, bplist.XMLFormat},
+		{"\xef\xbb\xbf\n<?xml version=\"1.0\"?>", bplist.XMLFormat},
+		{"<plist><true/></plist>", bplist.XMLFormat},
+		{`{ a = b; }`, bplist.OpenStepFormat},
+		{`("x", <0fab>)`, bplist.OpenStepFormat},
+		{"// comment\n{}", bplist.OpenStepFormat},
+		{`"key" = "value";`, bplist.OpenStepFormat},
+	}
+	for _, tc := range tests {
+		if got := bplist.Detect([]byte(tc.input)); got != tc.want {
+			t.Errorf("Detect(%q): got %v, want %v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestParseAuto(t *testing.T) {
+	const want = `V"00"<dict size=1>(string=NSHTTPCookieAcceptPolicy)(int=2)</dict>`
+	for _, input := range []string{
+		testInput,
+		xmlHeader + `<dict><key>NSHTTPCookieAcceptPolicy</key><integer>2</integer></dict></plist>`,
+	} {
+		var buf strings.Builder
+		if err := bplist.ParseAuto([]byte(input), testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Errorf("ParseAuto failed: %v", err)
+		} else if got := buf.String(); got != want {
+			t.Errorf("ParseAuto: got %s, want %s", got, want)
+		}
+	}
+
+	for _, input := range []string{"", `{ a = b; }`} {
+		if err := bplist.ParseAuto([]byte(input), nopHandler{}); !errors.Is(err, bplist.ErrUnsupportedFormat) {
+			t.Errorf("ParseAuto(%q): got %v, want %v", input, err, bplist.ErrUnsupportedFormat)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	const xmlInput = xmlHeader + `<dict>
+	<key>NSHTTPCookieAcceptPolicy</key>
+	<integer>2</integer>
+</dict>
+</plist>
+`
+	tests := []struct {
+		input string
+		to    bplist.Format
+		want  string
+	}{
+		{testInput, bplist.BinaryFormat, testInput},
+		{testInput, bplist.XMLFormat, xmlInput},
+		{xmlInput, bplist.BinaryFormat, testInput},
+		{xmlInput, bplist.XMLFormat, xmlInput},
+	}
+	for _, tc := range tests {
+		var buf strings.Builder
+		if err := bplist.Convert(&buf, []byte(tc.input), tc.to); err != nil {
+			t.Errorf("Convert to %v failed: %v", tc.to, err)
+			continue
+		}
+		if tc.to == bplist.BinaryFormat {
+			// The object layout may differ, so compare the parse events.
+			if got, want := parseEvents(t, buf.String()), parseEvents(t, tc.want); got != want {
+				t.Errorf("Convert to %v: got %s, want %s", tc.to, got, want)
+			}
+		} else if got := buf.String(); got != tc.want {
+			t.Errorf("Convert to %v:\n got %q\nwant %q", tc.to, got, tc.want)
+		}
+	}
+
+	var buf strings.Builder
+	if err := bplist.Convert(&buf, []byte(testInput), bplist.OpenStepFormat); !errors.Is(err, bplist.ErrUnsupportedFormat) {
+		t.Errorf("Convert to OpenStep: got %v, want %v", err, bplist.ErrUnsupportedFormat)
+	}
+	if err := bplist.Convert(&buf, []byte("bplist00\x00"), bplist.XMLFormat); err == nil {
+		t.Error("Convert invalid input: got nil, wanted an error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Convert wrote output on error: %q", buf.String())
+	}
+}
+
+// parseEvents returns the parse events of the property list in input, as
+// reported by testHandler.
+func parseEvents(t *testing.T, input string) string {
+	t.Helper()
+	var buf strings.Builder
+	if err := bplist.ParseAuto([]byte(input), testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestConvertDate(t *testing.T) {
+	when := time.Date(2020, 1, 1, 0, 0, 0, 500_000_000, time.UTC)
+	b := bplist.NewBuilder()
+	b.Value(bplist.TTime, when)
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	parseTime := func(data []byte) time.Time {
+		t.Helper()
+		v, err := bplist.ParseValue(data)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		return v.Time()
+	}
+
+	// A binary conversion keeps the fractional seconds.
+	var buf bytes.Buffer
+	if err := bplist.Convert(&buf, data, bplist.BinaryFormat); err != nil {
+		t.Fatalf("Convert to binary failed: %v", err)
+	}
+	if got := parseTime(buf.Bytes()); !got.Equal(when) {
+		t.Errorf("Binary date: got %v, want %v", got, when)
+	}
+
+	// An XML conversion truncates them.
+	buf.Reset()
+	if err := bplist.Convert(&buf, data, bplist.XMLFormat); err != nil {
+		t.Fatalf("Convert to XML failed: %v", err)
+	}
+	var back bytes.Buffer
+	if err := bplist.Convert(&back, buf.Bytes(), bplist.BinaryFormat); err != nil {
+		t.Fatalf("Convert from XML failed: %v", err)
+	}
+	if got, want := parseTime(back.Bytes()), when.Truncate(time.Second); !got.Equal(want) {
+		t.Errorf("XML date: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"fmt"
+	"slices"
+)
+
+// HandlerFuncs implements the Handler interface by calling its fields. A nil
+// field is treated as a function that does nothing and reports no error.
+type HandlerFuncs struct {
+	VersionFunc func(string) error
+	ValueFunc   func(typ Type, datum any) error
+	OpenFunc    func(typ Collection, n int) error
+	CloseFunc   func(Collection) error
+}
+
+// Version implements part of the Handler interface by calling h.VersionFunc.
+func (h HandlerFuncs) Version(v string) error {
+	if h.VersionFunc == nil {
+		return nil
+	}
+	return h.VersionFunc(v)
+}
+
+// Value implements part of the Handler interface by calling h.ValueFunc.
+func (h HandlerFuncs) Value(typ Type, datum any) error {
+	if h.ValueFunc == nil {
+		return nil
+	}
+	return h.ValueFunc(typ, datum)
+}
+
+// Open implements part of the Handler interface by calling h.OpenFunc.
+func (h HandlerFuncs) Open(typ Collection, n int) error {
+	if h.OpenFunc == nil {
+		return nil
+	}
+	return h.OpenFunc(typ, n)
+}
+
+// Close implements part of the Handler interface by calling h.CloseFunc.
+func (h HandlerFuncs) Close(typ Collection) error {
+	if h.CloseFunc == nil {
+		return nil
+	}
+	return h.CloseFunc(typ)
+}
+
+// MultiHandler returns a Handler that delivers each event to each of the given
+// handlers in order, like the tee(1) command. If a handler reports an error,
+// the event is not delivered to the remaining handlers, and the error is
+// reported to the caller.
+//
+// The result is also a KeyHandler and a SpanHandler, which forwards Key and
+// Span calls to those of the handlers that implement these interfaces, and
+// delivers keys to the others as values. It is not a RefHandler, since the
+// handlers could not agree whether to skip an object.
+func MultiHandler(hs ...Handler) Handler { return multiHandler(slices.Clone(hs)) }
+
+type multiHandler []Handler
+
+func (m multiHandler) Version(v string) error {
+	for _, h := range m {
+		if err := h.Version(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiHandler) Value(typ Type, datum any) error {
+	for _, h := range m {
+		if err := h.Value(typ, datum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiHandler) Key(typ Type, datum any) error {
+	for _, h := range m {
+		var err error
+		if kh, ok := h.(KeyHandler); ok {
+			err = kh.Key(typ, datum)
+		} else {
+			err = h.Value(typ, datum)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiHandler) Span(off, n int) error {
+	for _, h := range m {
+		if sh, ok := h.(SpanHandler); ok {
+			if err := sh.Span(off, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m multiHandler) Open(coll Collection, n int) error {
+	for _, h := range m {
+		if err := h.Open(coll, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiHandler) Close(coll Collection) error {
+	for _, h := range m {
+		if err := h.Close(coll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A PathTracker is a Handler that tracks the location of each event within
+// the property list, and delivers the event to another Handler. While the
+// other handler is processing an event, the Path method of the tracker reports
+// where the event occurred.
+type PathTracker struct {
+	h    Handler
+	path []any       // the path of the current object
+	stk  []pathFrame // the open collections
+}
+
+// pathFrame records the position of a PathTracker in an open collection.
+type pathFrame struct {
+	dict bool
+	next int    // the index of the next element (for a non-dict)
+	key  string // the most recent key (for a dict)
+}
+
+// NewPathTracker returns a PathTracker that delivers events to h.
+//
+// The tracker is a KeyHandler, and delivers dict keys to h.Key if h is a
+// KeyHandler, or otherwise to h.Value.
+func NewPathTracker(h Handler) *PathTracker { return &PathTracker{h: h} }
+
+// Path returns the path of the object of the current event, as a sequence of
+// string dict keys and int indexes, in the form accepted by Value.Lookup.
+// For an Open or Close event, it is the path of the collection. For a dict
+// key, it is the path of the dict. The root has an empty path.
+//
+// The result is only valid during the event; the caller must copy it to
+// retain it after the event handler returns.
+func (t *PathTracker) Path() []any { return t.path }
+
+// enter updates the path for an element of the current collection.
+func (t *PathTracker) enter() {
+	if n := len(t.stk); n != 0 {
+		f := &t.stk[n-1]
+		if f.dict {
+			t.path = append(t.path, f.key)
+		} else {
+			t.path = append(t.path, f.next)
+			f.next++
+		}
+	}
+}
+
+// exit reverses the effect of enter.
+func (t *PathTracker) exit() {
+	if len(t.stk) != 0 {
+		t.path = t.path[:len(t.path)-1]
+	}
+}
+
+// Version implements part of the Handler interface.
+func (t *PathTracker) Version(v string) error {
+	t.path, t.stk = t.path[:0], t.stk[:0]
+	return t.h.Version(v)
+}
+
+// Value implements part of the Handler interface.
+func (t *PathTracker) Value(typ Type, datum any) error {
+	t.enter()
+	defer t.exit()
+	return t.h.Value(typ, datum)
+}
+
+// Key implements the KeyHandler interface.
+func (t *PathTracker) Key(typ Type, datum any) error {
+	if n := len(t.stk); n != 0 {
+		t.stk[n-1].key = keyText(typ, datum)
+	}
+	if kh, ok := t.h.(KeyHandler); ok {
+		return kh.Key(typ, datum)
+	}
+	return t.h.Value(typ, datum)
+}
+
+// Open implements part of the Handler interface.
+func (t *PathTracker) Open(coll Collection, n int) error {
+	t.enter()
+	t.stk = append(t.stk, pathFrame{dict: coll == Dict})
+	return t.h.Open(coll, n)
+}
+
+// Close implements part of the Handler interface.
+func (t *PathTracker) Close(coll Collection) error {
+	t.stk = t.stk[:len(t.stk)-1]
+	defer t.exit()
+	return t.h.Close(coll)
+}
+
+// keyText returns the text of a dict key with the given type and datum, as
+// delivered by the parser.
+func keyText(typ Type, datum any) string {
+	switch d := datum.(type) {
+	case string:
+		return d
+	case []rune:
+		return string(d)
+	case []byte:
+		if typ == TUnicode {
+			return string(decodeUTF16(d)) // with ZeroCopy
+		}
+		return string(d)
+	}
+	return fmt.Sprint(datum)
+}
+
+// FilterHandler returns a Handler that delivers events to h, except for those
+// of objects excluded by keep, and their contents. The keep function is
+// called with the path of each object other than the root, in the form
+// reported by PathTracker.Path, and the object is excluded if keep reports
+// false. A dict key is excluded along with its value. The path is only valid
+// during the call; keep must copy it to retain it.
+//
+// The result is a RefHandler, and when it is passed directly to Parse, the
+// parser does not decode the objects it excludes. The size reported to
+// h.Open counts all the elements of the collection, including any that are
+// excluded. If h is a KeyHandler, dict keys are delivered to h.Key.
+func FilterHandler(h Handler, keep func(path []any) bool) Handler {
+	return &filterHandler{h: h, keep: keep}
+}
+
+type filterHandler struct {
+	h    Handler
+	keep func([]any) bool
+	path []any         // the path of the current object
+	stk  []filterFrame // the open collections that are not excluded
+	skip int           // the depth of nesting within an excluded collection
+}
+
+// filterFrame records the position of a filterHandler in an open collection.
+type filterFrame struct {
+	dict    bool
+	next    int    // the index of the next element (for a non-dict)
+	key     string // the most recent key (for a dict)
+	val     bool   // the next element is a value (for a dict)
+	decided bool   // whether drop is valid for the next element
+	drop    bool   // the next element is excluded
+}
+
+// comp returns the path component for the next element of f.
+func (f *filterFrame) comp() any {
+	if f.dict {
+		return f.key
+	}
+	return f.next
+}
+
+// excluded reports whether the next element of the innermost collection is
+// excluded, calling keep if this has not already been decided.
+func (h *filterHandler) excluded() bool {
+	top := &h.stk[len(h.stk)-1]
+	if !top.decided {
+		h.path = append(h.path, top.comp())
+		top.drop = !h.keep(h.path)
+		top.decided = true
+		h.path = h.path[:len(h.path)-1]
+	}
+	return top.drop
+}
+
+// advance consumes the next element of the innermost collection, and returns
+// its path component and whether it is excluded.
+func (h *filterHandler) advance() (any, bool) {
+	drop := h.excluded()
+	top := &h.stk[len(h.stk)-1]
+	comp := top.comp()
+	if top.dict {
+		top.val = false
+	} else {
+		top.next++
+	}
+	top.decided = false
+	return comp, drop
+}
+
+func (h *filterHandler) Version(v string) error {
+	h.path, h.stk, h.skip = h.path[:0], h.stk[:0], 0
+	return h.h.Version(v)
+}
+
+func (h *filterHandler) Ref(int) (bool, error) {
+	if h.skip != 0 || len(h.stk) == 0 {
+		return false, nil
+	} else if top := h.stk[len(h.stk)-1]; top.dict && !top.val {
+		return false, nil // a key, which is checked by Key
+	} else if h.excluded() {
+		h.advance()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (h *filterHandler) Key(typ Type, datum any) error {
+	if h.skip != 0 {
+		return nil
+	}
+	top := &h.stk[len(h.stk)-1]
+	top.key, top.val, top.decided = keyText(typ, datum), true, false
+	if h.excluded() {
+		return nil
+	} else if kh, ok := h.h.(KeyHandler); ok {
+		return kh.Key(typ, datum)
+	}
+	return h.h.Value(typ, datum)
+}
+
+func (h *filterHandler) Value(typ Type, datum any) error {
+	if h.skip != 0 {
+		return nil
+	} else if len(h.stk) == 0 {
+		return h.h.Value(typ, datum)
+	} else if top := h.stk[len(h.stk)-1]; top.dict && !top.val {
+		return h.Key(typ, datum)
+	}
+	comp, drop := h.advance()
+	if drop {
+		return nil
+	}
+	h.path = append(h.path, comp)
+	defer func() { h.path = h.path[:len(h.path)-1] }()
+	return h.h.Value(typ, datum)
+}
+
+func (h *filterHandler) Open(coll Collection, n int) error {
+	if h.skip != 0 {
+		h.skip++
+		return nil
+	} else if len(h.stk) != 0 {
+		comp, drop := h.advance()
+		if drop {
+			h.skip = 1
+			return nil
+		}
+		h.path = append(h.path, comp)
+	}
+	h.stk = append(h.stk, filterFrame{dict: coll == Dict})
+	return h.h.Open(coll, n)
+}
+
+func (h *filterHandler) Close(coll Collection) error {
+	if h.skip != 0 {
+		h.skip--
+		return nil
+	}
+	h.stk = h.stk[:len(h.stk)-1]
+	if len(h.stk) != 0 {
+		defer func() { h.path = h.path[:len(h.path)-1] }()
+	}
+	return h.h.Close(coll)
+}
+
+// BuilderHandler returns a Handler that adds the values it receives to b, so
+// that parsing a property list with it rebuilds the list in b. This allows a
+// property list to be re-encoded, possibly with other BuilderOptions, or
+// combined with a handler like FilterHandler to rewrite it.
+//
+// The version of the input is recorded in the options of b, unless they
+// already specify one. The handler reports any error from b, for example if
+// the input contains a value that b cannot encode.
+func BuilderHandler(b *Builder) Handler { return builderHandler{b} }
+
+type builderHandler struct{ b *Builder }
+
+func (h builderHandler) Version(v string) error {
+	if h.b.opts.Version == "" {
+		h.b.opts.Version = v
+	}
+	return h.b.err
+}
+
+func (h builderHandler) Value(typ Type, datum any) error { return h.b.Value(typ, datum) }
+
+func (h builderHandler) Open(coll Collection, _ int) error {
+	if h.b.err != nil {
+		return h.b.err
+	}
+	h.b.open(coll)
+	return nil
+}
+
+func (h builderHandler) Close(coll Collection) error { return h.b.close(coll) }
@@ -0,0 +1,260 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestHandlerFuncs(t *testing.T) {
+	input := mkPlist([]byte{0xa2, 1, 2}, []byte{0x51, 'x'}, []byte{0x10, 0x05})
+
+	var strs []string
+	opens := 0
+	if err := bplist.Parse(input, bplist.HandlerFuncs{
+		ValueFunc: func(typ bplist.Type, datum any) error {
+			if typ == bplist.TString {
+				strs = append(strs, datum.(string))
+			}
+			return nil
+		},
+		OpenFunc: func(bplist.Collection, int) error { opens++; return nil },
+	}); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if len(strs) != 1 || strs[0] != "x" || opens != 1 {
+		t.Errorf("Parse: got strings %q, %d opens; want [x], 1", strs, opens)
+	}
+
+	// A zero HandlerFuncs accepts everything.
+	if err := bplist.Parse(input, bplist.HandlerFuncs{}); err != nil {
+		t.Errorf("Parse: unexpected error: %v", err)
+	}
+
+	// Errors from the functions are propagated.
+	errStop := errors.New("stop")
+	if err := bplist.Parse(input, bplist.HandlerFuncs{
+		VersionFunc: func(string) error { return errStop },
+	}); !errors.Is(err, errStop) {
+		t.Errorf("Parse: got %v, want %v", err, errStop)
+	}
+}
+
+func TestMultiHandler(t *testing.T) {
+	// {"a": [true]}
+	input := mkPlist([]byte{0xd1, 1, 2}, []byte{0x51, 'a'}, []byte{0xa1, 3}, []byte{0x09})
+
+	var b1, b2, b3 strings.Builder
+	h := bplist.MultiHandler(
+		testHandler{log: t.Logf, buf: &b1},
+		keyHandler{testHandler{log: t.Logf, buf: &b2}},
+		spanHandler{testHandler{log: t.Logf, buf: &b3}},
+	)
+	if err := bplist.Parse(input, h); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	for _, tc := range []struct {
+		got  *strings.Builder
+		want string
+	}{
+		{&b1, `V"00"<dict size=1>(string=a)<array size=1>(bool=true)</array></dict>`},
+		{&b2, `V"00"<dict size=1>[string=a]<array size=1>(bool=true)</array></dict>`},
+		{&b3, `V"00"{8+3}<dict size=1>{11+2}(string=a){13+2}<array size=1>{15+1}(bool=true)</array></dict>`},
+	} {
+		if got := tc.got.String(); got != tc.want {
+			t.Errorf("Parse: got %q, want %q", got, tc.want)
+		}
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var later int
+		h := bplist.MultiHandler(
+			bplist.HandlerFuncs{OpenFunc: func(bplist.Collection, int) error { return errStop }},
+			bplist.HandlerFuncs{OpenFunc: func(bplist.Collection, int) error { later++; return nil }},
+		)
+		if err := bplist.Parse(input, h); !errors.Is(err, errStop) {
+			t.Errorf("Parse: got %v, want %v", err, errStop)
+		}
+		if later != 0 {
+			t.Errorf("Open was delivered to %d later handlers, want 0", later)
+		}
+	})
+}
+
+func TestPathTracker(t *testing.T) {
+	// {"Items": [{"Name": "x"}, 5]}
+	input := mkPlist(
+		[]byte{0xd1, 1, 2},
+		[]byte{0x55, 'I', 't', 'e', 'm', 's'},
+		[]byte{0xa2, 3, 4},
+		[]byte{0xd1, 5, 6},
+		[]byte{0x10, 0x05},
+		[]byte{0x54, 'N', 'a', 'm', 'e'},
+		[]byte{0x51, 'x'},
+	)
+	var got []string
+	var pt *bplist.PathTracker
+	record := func(tag string) error {
+		got = append(got, fmt.Sprintf("%s%v", tag, pt.Path()))
+		return nil
+	}
+	pt = bplist.NewPathTracker(bplist.HandlerFuncs{
+		ValueFunc: func(typ bplist.Type, datum any) error { return record(fmt.Sprint(datum)) },
+		OpenFunc:  func(coll bplist.Collection, _ int) error { return record("<" + coll.String()) },
+		CloseFunc: func(coll bplist.Collection) error { return record("/" + coll.String()) },
+	})
+	if err := bplist.Parse(input, pt); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	want := []string{
+		"<dict[]",
+		"Items[]",
+		"<array[Items]",
+		"<dict[Items 0]",
+		"Name[Items 0]",
+		"x[Items 0 Name]",
+		"/dict[Items 0]",
+		"5[Items 1]",
+		"/array[Items]",
+		"/dict[]",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Paths:\n got %q\nwant %q", got, want)
+	}
+
+	// The paths can be used to look up values.
+	root, err := bplist.ParseValue(input)
+	if err != nil {
+		t.Fatalf("ParseValue: %v", err)
+	}
+	if v, err := root.Lookup("Items", 0, "Name"); err != nil || v.String() != "x" {
+		t.Errorf("Lookup: got (%v, %v), want x", v, err)
+	}
+}
+
+func TestFilterHandler(t *testing.T) {
+	// {"Items": [{"Name": "x"}, 5, "y"], "Count": 3}
+	input := mkPlist(
+		[]byte{0xd2, 1, 7, 2, 8},
+		[]byte{0x55, 'I', 't', 'e', 'm', 's'},
+		[]byte{0xa3, 3, 4, 9},
+		[]byte{0xd1, 5, 6},
+		[]byte{0x10, 0x05},
+		[]byte{0x54, 'N', 'a', 'm', 'e'},
+		[]byte{0x51, 'x'},
+		[]byte{0x55, 'C', 'o', 'u', 'n', 't'},
+		[]byte{0x10, 0x03},
+		[]byte{0x51, 'y'},
+	)
+	tests := []struct {
+		name string
+		keep func([]any) bool
+		want string
+	}{
+		{"All", func([]any) bool { return true },
+			`V"00"<dict size=2>(string=Items)<array size=3><dict size=1>(string=Name)(string=x)</dict>` +
+				`(int=5)(string=y)</array>(string=Count)(int=3)</dict>`},
+		{"NoItems", func(p []any) bool { return p[0] != "Items" },
+			`V"00"<dict size=2>(string=Count)(int=3)</dict>`},
+		{"FirstItem", func(p []any) bool { return len(p) < 2 || p[1] == 0 },
+			`V"00"<dict size=2>(string=Items)<array size=3><dict size=1>(string=Name)(string=x)</dict>` +
+				`</array>(string=Count)(int=3)</dict>`},
+		{"Scalars", func(p []any) bool { return len(p) < 2 || p[1] != 0 },
+			`V"00"<dict size=2>(string=Items)<array size=3>(int=5)(string=y)</array>(string=Count)(int=3)</dict>`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf strings.Builder
+			h := bplist.FilterHandler(testHandler{log: t.Logf, buf: &buf}, test.keep)
+			if err := bplist.Parse(input, h); err != nil {
+				t.Fatalf("Parse: unexpected error: %v", err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("Parse:\n got %q\nwant %q", got, test.want)
+			}
+
+			// Without the parser skipping excluded objects, the result is the same.
+			buf.Reset()
+			h = bplist.FilterHandler(testHandler{log: t.Logf, buf: &buf}, test.keep)
+			if err := bplist.Parse(input, bplist.MultiHandler(h)); err != nil {
+				t.Fatalf("Parse: unexpected error: %v", err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("Parse via MultiHandler:\n got %q\nwant %q", got, test.want)
+			}
+		})
+	}
+
+	t.Run("NoDecode", func(t *testing.T) {
+		bad := slices.Clone(input)
+		bad[len("bplist00")+5+6+4] = 0xf0 // the tag of object 3
+		if err := bplist.Parse(bad, nopHandler{}); err == nil {
+			t.Fatal("Parse: got nil, wanted an error")
+		}
+		h := bplist.FilterHandler(nopHandler{}, func(p []any) bool { return len(p) < 2 || p[1] != 0 })
+		if err := bplist.Parse(bad, h); err != nil {
+			t.Errorf("Parse with filter: unexpected error: %v", err)
+		}
+	})
+}
+
+func TestBuilderHandler(t *testing.T) {
+	// {"a": [true, 5], "b": "x"}, in version "01".
+	input := mkPlist(
+		[]byte{0xd2, 1, 2, 3, 4},
+		[]byte{0x51, 'a'},
+		[]byte{0x51, 'b'},
+		[]byte{0xa2, 5, 6},
+		[]byte{0x51, 'x'},
+		[]byte{0x09},
+		[]byte{0x10, 0x05},
+	)
+	copy(input[6:], "01")
+
+	b := bplist.NewBuilder()
+	if err := bplist.Parse(input, bplist.BuilderHandler(b)); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	var out bytes.Buffer
+	if _, err := b.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo: unexpected error: %v", err)
+	}
+
+	var want, got strings.Builder
+	if err := bplist.Parse(input, testHandler{log: t.Logf, buf: &want}); err != nil {
+		t.Fatalf("Parse input: %v", err)
+	}
+	if err := bplist.Parse(out.Bytes(), testHandler{log: t.Logf, buf: &got}); err != nil {
+		t.Fatalf("Parse output: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("Rebuilt list:\n got %s\nwant %s", got.String(), want.String())
+	}
+
+	// Errors from the builder are reported by the handler.
+	b.Reset()
+	b.Value(bplist.TNull, 1) // fails
+	if err := bplist.Parse(input, bplist.BuilderHandler(b)); err == nil {
+		t.Error("Parse: got nil, want error")
+	}
+}
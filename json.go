@@ -0,0 +1,219 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creachadair/bplist/wire"
+)
+
+// uidKey is the JSON object key used to represent a UID, following the
+// convention of NSKeyedArchiver.
+const uidKey = "CF$UID"
+
+// MarshalJSON implements the json.Marshaler interface. A Value is encoded
+// as JSON according to its kind:
+//
+//   - KNull and KInvalid are null, and KBool is a Boolean.
+//   - KInteger and KFloat are numbers. A float with an integral value is
+//     written with a trailing ".0" so that it decodes as a float. Infinite
+//     and NaN floats cannot be encoded, and are reported as errors.
+//   - KString is a string.
+//   - KBytes is a string containing the base64 encoding of the data.
+//   - KTime is a string in RFC 3339 format with nanoseconds.
+//   - KUID is an object with a single key "CF$UID" whose value is the UID.
+//   - KArray, KSet, and KOrderedSet are arrays.
+//   - KDict is an object, with its keys in order.
+//
+// A KRaw value cannot be encoded, and is reported as an error.
+func (v *Value) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := v.appendJSON(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v *Value) appendJSON(buf *bytes.Buffer) error {
+	switch v.Kind() {
+	case KInvalid, KNull:
+		buf.WriteString("null")
+		return nil
+	case KArray, KSet, KOrderedSet:
+		buf.WriteByte('[')
+		for i, elt := range v.elts {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := elt.appendJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case KDict:
+		buf.WriteByte('{')
+		for i, e := range v.ents {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, e.Key)
+			buf.WriteByte(':')
+			if err := e.Value.appendJSON(buf); err != nil {
+				return fmt.Errorf("key %q: %w", e.Key, err)
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case KUID:
+		fmt.Fprintf(buf, `{%q:%d}`, uidKey, v.UID())
+		return nil
+	case KRaw:
+		return errors.New("cannot encode raw object as JSON")
+	}
+
+	switch t := v.datum.(type) {
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case int64, uint64, *big.Int:
+		fmt.Fprint(buf, t)
+	case float64:
+		if math.IsInf(t, 0) || math.IsNaN(t) {
+			return fmt.Errorf("cannot encode %v as JSON", t)
+		}
+		s := strconv.FormatFloat(t, 'g', -1, 64)
+		buf.WriteString(s)
+		if !strings.ContainsAny(s, ".e") {
+			buf.WriteString(".0")
+		}
+	case string:
+		writeJSONString(buf, t)
+	case []byte:
+		writeJSONString(buf, base64.StdEncoding.EncodeToString(t))
+	case time.Time:
+		writeJSONString(buf, t.Format(time.RFC3339Nano))
+	default:
+		return fmt.Errorf("cannot encode %T as JSON", t)
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s) // cannot fail for a string
+	buf.Write(data)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, replacing the
+// contents of v with the value decoded from data. Since JSON does not
+// distinguish them from strings, bytes and times encoded by MarshalJSON are
+// decoded as KString values. A number without a fraction or exponent is a
+// KInteger, and any other number is a KFloat. An object with a single key
+// "CF$UID" and a non-negative integer value is a KUID; any other object is a
+// KDict with its keys in order.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	w, err := decodeJSON(dec)
+	if err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after JSON value")
+	}
+	*v = *w
+	return nil
+}
+
+func decodeJSON(dec *json.Decoder) (*Value, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case nil:
+		return &Value{kind: KNull}, nil
+	case bool:
+		return &Value{kind: KBool, datum: t}, nil
+	case string:
+		return &Value{kind: KString, datum: t}, nil
+	case json.Number:
+		return jsonNumber(t)
+	case json.Delim:
+		if t == '[' {
+			v := &Value{kind: KArray}
+			for dec.More() {
+				elt, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				v.elts = append(v.elts, elt)
+			}
+			_, err := dec.Token() // consume ]
+			return v, err
+		}
+		v := &Value{kind: KDict}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			elt, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			v.SetKey(tok.(string), elt)
+		}
+		if _, err := dec.Token(); err != nil { // consume }
+			return nil, err
+		}
+		if len(v.ents) == 1 && v.ents[0].Key == uidKey {
+			if z, ok := v.ents[0].Value.datum.(int64); ok && z >= 0 {
+				return &Value{kind: KUID, datum: wire.AppendUint(nil, wire.Width(uint64(z)), uint64(z))}, nil
+			}
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", tok)
+}
+
+// jsonNumber converts a JSON number to an integer or float value.
+func jsonNumber(n json.Number) (*Value, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if z, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return &Value{kind: KInteger, datum: z}, nil
+		} else if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return &Value{kind: KInteger, datum: u}, nil
+		} else if b, ok := new(big.Int).SetString(s, 10); ok {
+			return &Value{kind: KInteger, datum: b}, nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Value{kind: KFloat, datum: f}, nil
+}
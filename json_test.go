@@ -0,0 +1,81 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestValueJSON(t *testing.T) {
+	doc := bplist.NewDict(
+		bplist.Entry{Key: "z", Value: bplist.NewValue(bplist.TNull, nil)},
+		bplist.Entry{Key: "bool", Value: bplist.NewValue(bplist.TBool, true)},
+		bplist.Entry{Key: "int", Value: bplist.NewValue(bplist.TInteger, -3)},
+		bplist.Entry{Key: "float", Value: bplist.NewValue(bplist.TFloat, 2.0)},
+		bplist.Entry{Key: "str", Value: bplist.NewValue(bplist.TString, "a<b")},
+		bplist.Entry{Key: "bytes", Value: bplist.NewValue(bplist.TBytes, []byte("hello"))},
+		bplist.Entry{Key: "time", Value: bplist.NewValue(bplist.TTime,
+			time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC))},
+		bplist.Entry{Key: "uid", Value: bplist.NewValue(bplist.TUID, []byte{1, 0})},
+		bplist.Entry{Key: "list", Value: bplist.NewArray(
+			bplist.NewValue(bplist.TInteger, 1),
+			bplist.NewCollection(bplist.Set),
+		)},
+	)
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	const want = `{"z":null,"bool":true,"int":-3,"float":2.0,"str":"a\u003cb",` +
+		`"bytes":"aGVsbG8=","time":"2020-01-02T03:04:05.0000006Z",` +
+		`"uid":{"CF$UID":256},"list":[1,[]]}`
+	if got := string(data); got != want {
+		t.Errorf("Marshal:\n got %s\nwant %s", got, want)
+	}
+
+	var back bplist.Value
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	wantBack := bplist.NewDict(
+		bplist.Entry{Key: "z", Value: bplist.NewValue(bplist.TNull, nil)},
+		bplist.Entry{Key: "bool", Value: bplist.NewValue(bplist.TBool, true)},
+		bplist.Entry{Key: "int", Value: bplist.NewValue(bplist.TInteger, -3)},
+		bplist.Entry{Key: "float", Value: bplist.NewValue(bplist.TFloat, 2.0)},
+		bplist.Entry{Key: "str", Value: bplist.NewValue(bplist.TString, "a<b")},
+		bplist.Entry{Key: "bytes", Value: bplist.NewValue(bplist.TString, "aGVsbG8=")},
+		bplist.Entry{Key: "time", Value: bplist.NewValue(bplist.TString, "2020-01-02T03:04:05.0000006Z")},
+		bplist.Entry{Key: "uid", Value: bplist.NewValue(bplist.TUID, []byte{1, 0})},
+		bplist.Entry{Key: "list", Value: bplist.NewArray(
+			bplist.NewValue(bplist.TInteger, 1),
+			bplist.NewArray(),
+		)},
+	)
+	if !back.Equal(wantBack) {
+		t.Errorf("Unmarshal: got %v, want %v", back.Interface(), wantBack.Interface())
+	}
+	if got := back.Dict()[0].Key; got != "z" {
+		t.Errorf("First key: got %q, want z", got)
+	}
+
+	if data, err := json.Marshal(bplist.NewValue(bplist.TFloat, math.Inf(1))); err == nil {
+		t.Errorf("Marshal infinity: got %s, wanted an error", data)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A LazyValue is a read-only view of a single object in a binary property
+// list. Unlike a Value, a LazyValue does not decode its contents in advance:
+// the elements of a collection are located in the underlying data only when
+// they are requested, so that a large property list can be browsed without
+// decoding every object in it.
+//
+// A LazyValue retains the data it was parsed from, which must not be
+// modified while the LazyValue is in use. A LazyValue is safe for concurrent
+// use by multiple goroutines.
+type LazyValue struct {
+	p  *parser
+	id int
+}
+
+// ParseLazy returns a LazyValue for the root object of the binary property
+// list in data. It checks the structure of the file, but does not decode any
+// objects.
+func ParseLazy(data []byte) (*LazyValue, error) {
+	if err := checkFraming(data); err != nil {
+		return nil, err
+	}
+	p, err := ParseOptions{}.newParser(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.lazy(p.t.RootObject)
+}
+
+// ParseLazyAt returns a LazyValue for the root object of the binary property
+// list of the given size in bytes read from r. Only the trailer and offset
+// table are read in advance; each object is read from r when it is accessed.
+// Unlike ParseLazy, the data of the values it returns do not alias a shared
+// buffer. The reader must remain valid while the LazyValue is in use.
+func ParseLazyAt(r io.ReaderAt, size int64) (*LazyValue, error) {
+	p, err := readerAtParser(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := (ParseOptions{}).setup(p, nil); err != nil {
+		return nil, err
+	}
+	return p.lazy(p.t.RootObject)
+}
+
+// Kind returns the kind of v.
+func (v *LazyValue) Kind() Kind {
+	_, tag, err := v.p.objTag(v.id)
+	if err != nil {
+		return KInvalid
+	}
+	switch sel := tag >> 4; sel {
+	case 0:
+		switch tag & 0xf {
+		case 0:
+			return KNull
+		case 8, 9:
+			return KBool
+		}
+	case 1:
+		return KInteger
+	case 2:
+		return KFloat
+	case 3:
+		return KTime
+	case 4:
+		return KBytes
+	case 5, 6, 7:
+		return KString
+	case 8:
+		return KUID
+	case 10:
+		return KArray
+	case 11:
+		return KOrderedSet
+	case 12:
+		return KSet
+	case 13:
+		return KDict
+	}
+	return KInvalid
+}
+
+// Len returns the number of elements of a collection, counting each entry of
+// a Dict once. It returns 0 for other kinds.
+func (v *LazyValue) Len() int {
+	if _, n, _, err := v.p.collection(v.id); err == nil {
+		return n
+	}
+	return 0
+}
+
+// Index returns element i of an Array, Set, or OrderedSet. It reports an
+// error wrapping ErrNotFound if i is out of range.
+func (v *LazyValue) Index(i int) (*LazyValue, error) {
+	coll, n, refs, err := v.p.collection(v.id)
+	if err != nil {
+		return nil, err
+	} else if coll == Dict {
+		return nil, errors.New("cannot index a dict")
+	} else if i < 0 || i >= n {
+		return nil, fmt.Errorf("%w: index %d of %d", ErrNotFound, i, n)
+	}
+	return v.p.lazy(v.p.ref(refs, i))
+}
+
+// Key returns the value of key in a Dict. It reports an error wrapping
+// ErrNotFound if key is not present.
+func (v *LazyValue) Key(key string) (*LazyValue, error) {
+	coll, n, refs, err := v.p.collection(v.id)
+	if err != nil {
+		return nil, err
+	} else if coll != Dict {
+		return nil, fmt.Errorf("cannot look up a key in %v", coll)
+	}
+	for i := 0; i < n; i++ {
+		if s, ok := v.p.keyString(v.p.ref(refs, i)); ok && s == key {
+			return v.p.lazy(v.p.ref(refs, n+i))
+		}
+	}
+	return nil, fmt.Errorf("%w: key %q", ErrNotFound, key)
+}
+
+// Keys returns the keys of a Dict, in order.
+func (v *LazyValue) Keys() ([]string, error) {
+	coll, n, refs, err := v.p.collection(v.id)
+	if err != nil {
+		return nil, err
+	} else if coll != Dict {
+		return nil, fmt.Errorf("%v has no keys", coll)
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		kref := v.p.ref(refs, i)
+		s, ok := v.p.keyString(kref)
+		if !ok {
+			return nil, fmt.Errorf("dict key %d (object %d) is not a string", i, kref)
+		}
+		keys[i] = s
+	}
+	return keys, nil
+}
+
+// Lookup returns the value addressed by path, starting from v, as described
+// for Value.Lookup. Only the objects along the path are decoded.
+func (v *LazyValue) Lookup(path ...any) (*LazyValue, error) {
+	cur := v
+	for _, p := range path {
+		var err error
+		switch t := p.(type) {
+		case string:
+			cur, err = cur.Key(t)
+		case int:
+			cur, err = cur.Index(t)
+		default:
+			return nil, fmt.Errorf("invalid path element %T", p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cur, nil
+}
+
+// Value decodes v and all its contents into a Value tree.
+func (v *LazyValue) Value() (*Value, error) {
+	var vh ValueHandler
+	p := *v.p
+	p.h = &vh
+	if err := p.walk(v.id); err != nil {
+		return nil, err
+	}
+	return vh.root, nil
+}
+
+// lazy returns a LazyValue for the object with the given ID, after checking
+// that its offset is valid.
+func (p *parser) lazy(id int) (*LazyValue, error) {
+	if _, _, err := p.objTag(id); err != nil {
+		return nil, p.objErr(id, err)
+	}
+	return &LazyValue{p: p, id: id}, nil
+}
+
+// collection decodes the header of the collection object with the given ID,
+// and returns its type, its size, and its object references.
+func (p *parser) collection(id int) (Collection, int, []byte, error) {
+	off, tag, err := p.objTag(id)
+	if err != nil {
+		return 0, 0, nil, p.objErr(id, err)
+	}
+	var coll Collection
+	per := 1
+	switch tag >> 4 {
+	case 10:
+		coll = Array
+	case 11:
+		coll = OrderedSet
+	case 12:
+		coll = Set
+	case 13:
+		coll, per = Dict, 2
+	default:
+		return 0, 0, nil, p.objErr(id, errors.New("not a collection"))
+	}
+	size, refs, err := p.refs(id, off, tag, per)
+	if err != nil {
+		return 0, 0, nil, p.objErr(id, err)
+	}
+	return coll, size, refs, nil
+}
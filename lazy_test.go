@@ -0,0 +1,149 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestLazyValue(t *testing.T) {
+	data, err := bplist.Marshal(map[string]any{
+		"name":  "lazy",
+		"items": []any{1, "two", map[string]any{"deep": true}},
+		"set":   nil,
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	root, err := bplist.ParseLazy(data)
+	if err != nil {
+		t.Fatalf("ParseLazy failed: %v", err)
+	}
+	if got := root.Kind(); got != bplist.KDict {
+		t.Errorf("Root kind: got %v, want %v", got, bplist.KDict)
+	}
+	if got := root.Len(); got != 3 {
+		t.Errorf("Root length: got %d, want 3", got)
+	}
+	keys, err := root.Keys()
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	if want := []string{"items", "name", "set"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys: got %q, want %q", keys, want)
+	}
+
+	deep, err := root.Lookup("items", 2, "deep")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if got := deep.Kind(); got != bplist.KBool {
+		t.Errorf("Deep kind: got %v, want %v", got, bplist.KBool)
+	}
+	if v, err := deep.Value(); err != nil || !v.Bool() {
+		t.Errorf("Deep value: got %v, %v; want true", v, err)
+	}
+
+	items, err := root.Key("items")
+	if err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	iv, err := items.Value()
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	want := []any{int64(1), "two", map[string]any{"deep": true}}
+	if got := iv.Interface(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Items: got %v, want %v", got, want)
+	}
+
+	if _, err := root.Lookup("items", 3); !errors.Is(err, bplist.ErrNotFound) {
+		t.Errorf("Lookup out of range: got %v, want %v", err, bplist.ErrNotFound)
+	}
+	if _, err := root.Key("nonesuch"); !errors.Is(err, bplist.ErrNotFound) {
+		t.Errorf("Key missing: got %v, want %v", err, bplist.ErrNotFound)
+	}
+	if _, err := root.Index(0); err == nil {
+		t.Error("Index of dict: got nil, wanted an error")
+	}
+}
+
+// countingReaderAt is an io.ReaderAt that counts the bytes read from it.
+type countingReaderAt struct {
+	r     io.ReaderAt
+	nread int
+}
+
+func (c *countingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(buf, off)
+	c.nread += n
+	return n, err
+}
+
+func TestReaderAt(t *testing.T) {
+	big := make(map[string]any)
+	for i := range 500 {
+		big[fmt.Sprintf("key%03d", i)] = strings.Repeat("x", i)
+	}
+	data, err := bplist.Marshal(map[string]any{"big": big, "small": 1})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	t.Run("Parse", func(t *testing.T) {
+		want := parseString(t, data)
+		var buf bytes.Buffer
+		r := bytes.NewReader(data)
+		if err := bplist.ParseReaderAt(r, r.Size(), testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Fatalf("ParseReaderAt failed: %v", err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("ParseReaderAt: got %d bytes of output, want %d", len(got), len(want))
+		}
+		if err := bplist.ParseReaderAt(r, r.Size()-1, nopHandler{}); err == nil {
+			t.Error("ParseReaderAt with wrong size: got nil, wanted an error")
+		}
+	})
+
+	t.Run("Lazy", func(t *testing.T) {
+		r := &countingReaderAt{r: bytes.NewReader(data)}
+		root, err := bplist.ParseLazyAt(r, int64(len(data)))
+		if err != nil {
+			t.Fatalf("ParseLazyAt failed: %v", err)
+		}
+		v, err := root.Lookup("big", "key007")
+		if err != nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+		val, err := v.Value()
+		if err != nil {
+			t.Fatalf("Value failed: %v", err)
+		}
+		if got := val.String(); got != "xxxxxxx" {
+			t.Errorf("Value: got %q, want xxxxxxx", got)
+		}
+		if r.nread > len(data)/4 {
+			t.Errorf("Read %d bytes of %d for one lookup", r.nread, len(data))
+		}
+	})
+}
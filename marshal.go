@@ -0,0 +1,466 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"encoding"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxDepth is the maximum nesting depth of values handled by Marshal.
+const maxDepth = 10000
+
+// Marshal returns the binary property list encoding of v.
+//
+// Marshal traverses v recursively, encoding Go values as follows:
+//
+//   - A bool is a TBool.
+//   - A signed or unsigned integer is a TInteger.
+//   - A float32 or float64 is a TFloat, in the 4- or 8-byte form.
+//   - A string is a TString.
+//   - A []byte or an array of bytes, such as a UUID, is a TBytes.
+//   - A time.Time is a TTime, and a time.Duration a TFloat of seconds; see
+//     MarshalOptions.Durations for other forms.
+//   - A url.URL, netip.Addr, or netip.Prefix is a TString of its text form.
+//   - Any other value implementing encoding.TextMarshaler is a TString of
+//     the text it returns.
+//   - Any other slice or array is an Array of its elements.
+//   - A map with string keys is a Dict, with keys in CompareKeys order.
+//   - A struct is a Dict of its exported fields in declaration order, keyed
+//     by field name. The fields of an embedded struct are treated as fields
+//     of the enclosing struct.
+//   - A pointer or interface is encoded as the value it refers to, and a
+//     nil pointer or interface is a TNull. A nil slice or map is encoded
+//     as an empty collection.
+//
+// A converter registered with RegisterConverter for the type of a value
+// takes precedence over all the rules above.
+//
+// The encoding of a struct field can be customized by a "plist" key in its
+// struct tag. The tag gives the dictionary key for the field, optionally
+// followed by a comma and options. An empty name keeps the default. The tag
+// "-" causes the field to be skipped; to use "-" as a key, write "-,".
+// The "omitempty" option omits the field if it is false, zero, nil, or an
+// empty string, slice, map, or array. An embedded struct with a tag name is
+// encoded as a single field rather than having its fields promoted:
+//
+//	Identifier string `plist:"CFBundleIdentifier"`
+//	Version    string `plist:"CFBundleVersion,omitempty"`
+//	Scratch    int    `plist:"-"`
+//
+// Channel, function, and complex values, and maps with non-string keys,
+// cannot be encoded, and cause Marshal to report an error.
+func Marshal(v any) ([]byte, error) { return MarshalOptions{}.Marshal(v) }
+
+// MarshalOptions are settings that control the encoding of Go values. A zero
+// value is ready for use and provides the behavior of Marshal.
+type MarshalOptions struct {
+	// If true, omit struct fields and map entries whose values are empty
+	// collections, meaning slices or arrays (other than of bytes) or maps of
+	// length zero, including nil ones. By default these are encoded as empty
+	// collections. An empty collection inside an array is always encoded.
+	OmitEmpty bool
+
+	// If true, encode the fields of a struct in CompareKeys order of their
+	// dictionary keys, rather than in declaration order. Map keys are always
+	// encoded in sorted order.
+	SortFields bool
+
+	// Durations selects how a time.Duration is represented. The default is
+	// DurationSeconds. Unmarshal with the same options to decode them.
+	Durations DurationFormat
+}
+
+// Marshal returns the binary property list encoding of v, as described for
+// the Marshal function but with the settings from o.
+func (o MarshalOptions) Marshal(v any) ([]byte, error) {
+	b := NewBuilder()
+	if err := (&marshaler{b: b, opts: o}).marshal(reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return b.Bytes()
+}
+
+// Unmarshal decodes the binary property list in data into v, as described for
+// the Unmarshal function but with the settings from o, so that the result of
+// o.Marshal round-trips. Options that affect only encoding are ignored.
+func (o MarshalOptions) Unmarshal(data []byte, v any) error {
+	return (&unmarshaler{durations: o.Durations}).decode(data, v)
+}
+
+// FromGo adds v to the property list as a single element or collection,
+// encoded as described for Marshal, with durations in the format given by the
+// Durations option of b. This is convenient for adding values such as the
+// map[string]any and []any results of decoding JSON.
+func (b *Builder) FromGo(v any) error {
+	if b.err != nil {
+		return b.err
+	}
+	m := &marshaler{b: b, opts: MarshalOptions{Durations: b.opts.Durations}}
+	return b.fail(m.marshal(reflect.ValueOf(v), 0))
+}
+
+// A marshaler encodes Go values into a Builder.
+type marshaler struct {
+	b    *Builder
+	opts MarshalOptions
+}
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	durationType = reflect.TypeFor[time.Duration]()
+	urlType      = reflect.TypeFor[url.URL]()
+	addrType     = reflect.TypeFor[netip.Addr]()
+	prefixType   = reflect.TypeFor[netip.Prefix]()
+
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// textMarshaler reports whether v, or a pointer to v if it is addressable,
+// implements encoding.TextMarshaler. A nil pointer does not qualify, so that
+// it is encoded as a TNull.
+func textMarshaler(v reflect.Value) (encoding.TextMarshaler, bool) {
+	switch {
+	case v.Kind() == reflect.Interface || (v.Kind() == reflect.Pointer && v.IsNil()):
+		return nil, false
+	case v.Type().Implements(textMarshalerType):
+		return v.Interface().(encoding.TextMarshaler), true
+	case v.CanAddr() && reflect.PointerTo(v.Type()).Implements(textMarshalerType):
+		return v.Addr().Interface().(encoding.TextMarshaler), true
+	}
+	return nil, false
+}
+
+func (m *marshaler) marshal(v reflect.Value, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("value exceeds maximum depth %d", maxDepth)
+	} else if !v.IsValid() {
+		return m.b.Value(TNull, nil)
+	}
+
+	if c := lookupConverter(v.Type()); c != nil && c.encode != nil {
+		typ, datum, err := c.encode(v)
+		if err != nil {
+			return err
+		}
+		return m.b.Value(typ, datum)
+	}
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		if v.CanInterface() {
+			if ok, err := m.marshalFast(v.Interface()); ok {
+				return err
+			}
+		}
+	}
+
+	// Check for types with special handling before their kinds.
+	switch v.Type() {
+	case timeType:
+		return m.b.Value(TTime, v.Interface())
+	case durationType:
+		return m.b.Value(m.opts.Durations.Encode(time.Duration(v.Int())))
+	case urlType, addrType, prefixType:
+		return m.b.Value(TString, v.Interface())
+	}
+	if tm, ok := textMarshaler(v); ok {
+		text, err := tm.MarshalText()
+		if err != nil {
+			return err
+		}
+		return m.b.Value(TString, string(text))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return m.b.Value(TBool, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return m.b.Value(TInteger, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return m.b.Value(TInteger, v.Uint())
+	case reflect.Float32:
+		return m.b.Value(TFloat, float32(v.Float()))
+	case reflect.Float64:
+		return m.b.Value(TFloat, v.Float())
+	case reflect.String:
+		return m.b.Value(TString, v.String())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return m.b.Value(TNull, nil)
+		}
+		return m.marshal(v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return m.b.Value(TBytes, v.Bytes())
+		}
+		return m.marshalList(v, depth)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			for i := range data {
+				data[i] = byte(v.Index(i).Uint())
+			}
+			return m.b.Value(TBytes, data)
+		}
+		return m.marshalList(v, depth)
+	case reflect.Map:
+		return m.marshalMap(v, depth)
+	case reflect.Struct:
+		return m.marshalStruct(v, depth)
+	}
+	return fmt.Errorf("cannot encode value of type %v", v.Type())
+}
+
+// marshalFast encodes the common collection types of configuration data
+// without reflecting on their elements, and reports whether v was one of
+// them. The output is the same as the general path would produce.
+func (m *marshaler) marshalFast(v any) (bool, error) {
+	switch t := v.(type) {
+	case []string:
+		return true, marshalSlice(m.b, t, TString)
+	case []int64:
+		return true, marshalSlice(m.b, t, TInteger)
+	case map[string]string:
+		return true, marshalStringMap(m.b, t, TString)
+	case map[string]int64:
+		return true, marshalStringMap(m.b, t, TInteger)
+	}
+	return false, nil
+}
+
+func marshalSlice[T any](b *Builder, vs []T, typ Type) error {
+	b.open(Array)
+	for i, v := range vs {
+		if err := b.Value(typ, v); err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+	}
+	return b.close(Array)
+}
+
+func marshalStringMap[T any](b *Builder, m map[string]T, typ Type) error {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, CompareKeys)
+	b.open(Dict)
+	for _, key := range keys {
+		if err := b.Value(TString, key); err != nil {
+			return err
+		}
+		if err := b.Value(typ, m[key]); err != nil {
+			return fmt.Errorf("key %q: %w", key, err)
+		}
+	}
+	return b.close(Dict)
+}
+
+func (m *marshaler) marshalList(v reflect.Value, depth int) error {
+	m.b.open(Array)
+	for i := 0; i < v.Len(); i++ {
+		if err := m.marshal(v.Index(i), depth+1); err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+	}
+	return m.b.close(Array)
+}
+
+func (m *marshaler) marshalMap(v reflect.Value, depth int) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("cannot encode map with %v keys", v.Type().Key())
+	}
+	keys := v.MapKeys()
+	slices.SortFunc(keys, func(a, b reflect.Value) int {
+		return CompareKeys(a.String(), b.String())
+	})
+	m.b.open(Dict)
+	for _, key := range keys {
+		if m.opts.OmitEmpty && isEmptyCollection(v.MapIndex(key)) {
+			continue
+		}
+		if err := m.b.Value(TString, key.String()); err != nil {
+			return err
+		}
+		if err := m.marshal(v.MapIndex(key), depth+1); err != nil {
+			return fmt.Errorf("key %q: %w", key.String(), err)
+		}
+	}
+	return m.b.close(Dict)
+}
+
+func (m *marshaler) marshalStruct(v reflect.Value, depth int) error {
+	fields := structFields(v.Type())
+	if m.opts.SortFields {
+		fields = slices.Clone(fields)
+		slices.SortStableFunc(fields, func(a, b field) int {
+			return CompareKeys(a.name, b.name)
+		})
+	}
+	m.b.open(Dict)
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue // nil embedded pointer
+		} else if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		} else if m.opts.OmitEmpty && isEmptyCollection(fv) {
+			continue
+		}
+		if err := m.b.Value(TString, f.name); err != nil {
+			return err
+		}
+		if err := m.marshal(fv, depth+1); err != nil {
+			return fmt.Errorf("field %s: %w", f.name, err)
+		}
+	}
+	return m.b.close(Dict)
+}
+
+// A field describes an encodable field of a struct type.
+type field struct {
+	name      string // the dictionary key
+	index     []int  // the index sequence for reflect.Value.FieldByIndex
+	tagged    bool   // the name was given by a struct tag
+	omitEmpty bool   // omit the field if its value is empty
+}
+
+// structFields returns the encodable fields of struct type t, including the
+// promoted fields of embedded structs, in declaration order. When several
+// fields have the same name, the shallowest one wins, preferring one whose
+// name comes from a tag; if that leaves more than one, the name is omitted,
+// as with encoding/json.
+func structFields(t reflect.Type) []field {
+	type cand struct {
+		field
+		depth int
+	}
+	var cands []cand
+	var walk func(t reflect.Type, index []int, depth int)
+	walk = func(t reflect.Type, index []int, depth int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag, hasTag := sf.Tag.Lookup("plist")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			idx := append(slices.Clip(index), i)
+			if sf.Anonymous && name == "" {
+				ft := sf.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && depth < 32 {
+					walk(ft, idx, depth+1)
+					continue
+				}
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			f := field{name: sf.Name, index: idx}
+			if hasTag {
+				if name != "" {
+					f.name, f.tagged = name, true
+				}
+				for _, opt := range strings.Split(opts, ",") {
+					if opt == "omitempty" {
+						f.omitEmpty = true
+					}
+				}
+			}
+			cands = append(cands, cand{f, depth})
+		}
+	}
+	walk(t, nil, 0)
+
+	type rank struct{ depth, count, tagged int }
+	best := make(map[string]*rank)
+	for _, c := range cands {
+		r := best[c.name]
+		if r == nil || c.depth < r.depth {
+			r = &rank{depth: c.depth}
+			best[c.name] = r
+		} else if c.depth > r.depth {
+			continue
+		}
+		r.count++
+		if c.tagged {
+			r.tagged++
+		}
+	}
+	var out []field
+	for _, c := range cands {
+		r := best[c.name]
+		if c.depth != r.depth {
+			continue
+		}
+		if r.count == 1 || (r.tagged == 1 && c.tagged) {
+			out = append(out, c.field)
+		}
+	}
+	return out
+}
+
+// isEmptyCollection reports whether v is an empty collection for the
+// OmitEmpty marshal option, looking through interfaces.
+func isEmptyCollection(v reflect.Value) bool {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		return v.Len() == 0 && v.Type().Elem().Kind() != reflect.Uint8
+	case reflect.Map:
+		return v.Len() == 0
+	}
+	return false
+}
+
+// isEmptyValue reports whether v is empty for the "omitempty" option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Pointer, reflect.Interface:
+		return v.IsZero()
+	}
+	return false
+}
+
+// fieldByIndex is like v.FieldByIndex, but reports false instead of panicking
+// if the path traverses a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
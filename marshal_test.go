@@ -0,0 +1,527 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/creachadair/bplist"
+)
+
+type Base struct {
+	ID   int
+	Name string
+}
+
+type testRecord struct {
+	Base
+	Enabled bool
+	Ratio   float64
+	Tags    []string
+	Extra   map[string]any
+	Data    []byte
+	When    time.Time
+	Next    *testRecord
+	private int
+}
+
+// parseString parses data and returns the transcript written by testHandler.
+func parseString(t *testing.T, data []byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := bplist.Parse(data, testHandler{log: t.Logf, buf: &buf}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestMarshal(t *testing.T) {
+	rec := &testRecord{
+		Base:    Base{ID: 17, Name: "alpha"},
+		Enabled: true,
+		Ratio:   0.5,
+		Tags:    []string{"x", "y"},
+		Extra:   map[string]any{"b": 2, "a": "one"},
+		Data:    []byte("hi"),
+		When:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	data, err := bplist.Marshal(rec)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	const want = `V"00"<dict size=9>` +
+		`(string=ID)(int=17)(string=Name)(string=alpha)(string=Enabled)(bool=true)` +
+		`(string=Ratio)(float=0.5)` +
+		`(string=Tags)<array size=2>(string=x)(string=y)</array>` +
+		`(string=Extra)<dict size=2>(string=a)(string=one)(string=b)(int=2)</dict>` +
+		`(string=Data)(bytes=2 bytes)` +
+		`(string=When)(time=2020-01-02 03:04:05 +0000 UTC)` +
+		`(string=Next)(null=<nil>)` +
+		`</dict>`
+	if got := parseString(t, data); got != want {
+		t.Errorf("Marshal:\n got %s\nwant %s", got, want)
+	}
+
+	for _, bad := range []any{
+		make(chan int),
+		map[int]string{1: "one"},
+		[]any{func() {}},
+		complex(1, 2),
+	} {
+		if data, err := bplist.Marshal(bad); err == nil {
+			t.Errorf("Marshal %T: got %q, wanted an error", bad, data)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	rec := &testRecord{
+		Base:    Base{ID: 17, Name: "alpha"},
+		Enabled: true,
+		Ratio:   0.5,
+		Tags:    []string{"x", "y"},
+		Extra:   map[string]any{"b": int64(2), "a": "one", "c": []any{true, nil}},
+		Data:    []byte("hi"),
+		When:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Next: &testRecord{
+			Base:  Base{ID: 18},
+			Tags:  []string{},
+			Extra: map[string]any{},
+			Data:  []byte{},
+			When:  time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	data, err := bplist.Marshal(rec)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var got testRecord
+	if err := bplist.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(&got, rec) {
+		t.Errorf("Unmarshal:\n got %+v\nwant %+v", got, rec)
+	}
+
+	t.Run("CaseFold", func(t *testing.T) {
+		data, err := bplist.Marshal(map[string]any{"id": 5, "name": "x", "Other": 1})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		var got Base
+		if err := bplist.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if want := (Base{ID: 5, Name: "x"}); got != want {
+			t.Errorf("Unmarshal: got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Array", func(t *testing.T) {
+		data, err := bplist.Marshal([]int{1, 2, 3})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		var got [2]float64
+		if err := bplist.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if want := [2]float64{1, 2}; got != want {
+			t.Errorf("Unmarshal: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		data, err := bplist.Marshal(map[string]any{"ID": "seventeen", "Small": 300})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		var base Base
+		if err := bplist.Unmarshal(data, &base); err == nil {
+			t.Errorf("Unmarshal string into int: got %+v, wanted an error", base)
+		}
+		var small struct{ Small int8 }
+		if err := bplist.Unmarshal(data, &small); err == nil {
+			t.Errorf("Unmarshal 300 into int8: got %+v, wanted an error", small)
+		}
+		if err := bplist.Unmarshal(data, base); err == nil {
+			t.Error("Unmarshal into non-pointer: got nil, wanted an error")
+		}
+	})
+}
+
+func TestStructTags(t *testing.T) {
+	type Info struct {
+		Base     `plist:"Base"`
+		Bundle   string   `plist:"CFBundleIdentifier"`
+		Version  string   `plist:"CFBundleVersion,omitempty"`
+		Docs     []string `plist:",omitempty"`
+		Dash     int      `plist:"-,"`
+		Scratch  int      `plist:"-"`
+		Optional *int     `plist:"opt,omitempty"`
+	}
+	in := Info{
+		Base:    Base{ID: 1},
+		Bundle:  "com.example.app",
+		Dash:    3,
+		Scratch: 99,
+	}
+	data, err := bplist.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	const want = `V"00"<dict size=3>` +
+		`(string=Base)<dict size=2>(string=ID)(int=1)(string=Name)(string=)</dict>` +
+		`(string=CFBundleIdentifier)(string=com.example.app)` +
+		`(string=-)(int=3)` +
+		`</dict>`
+	if got := parseString(t, data); got != want {
+		t.Errorf("Marshal:\n got %s\nwant %s", got, want)
+	}
+
+	var out Info
+	if err := bplist.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	in.Scratch = 0
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal: got %+v, want %+v", out, in)
+	}
+}
+
+func TestTextMarshaler(t *testing.T) {
+	type Rec struct {
+		Big  *big.Int
+		Rat  big.Rat
+		Addr netip.AddrPort
+		None *big.Int
+	}
+	in := Rec{
+		Big:  new(big.Int).Lsh(big.NewInt(1), 100),
+		Rat:  *big.NewRat(1, 3),
+		Addr: netip.MustParseAddrPort("127.0.0.1:80"),
+	}
+	data, err := bplist.Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	const want = `V"00"<dict size=4>` +
+		`(string=Big)(string=1267650600228229401496703205376)` +
+		`(string=Rat)(string=1/3)` +
+		`(string=Addr)(string=127.0.0.1:80)` +
+		`(string=None)(null=<nil>)` +
+		`</dict>`
+	if got := parseString(t, data); got != want {
+		t.Errorf("Marshal:\n got %s\nwant %s", got, want)
+	}
+
+	var out Rec
+	if err := bplist.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if out.Big.Cmp(in.Big) != 0 || out.Rat.Cmp(&in.Rat) != 0 || out.Addr != in.Addr || out.None != nil {
+		t.Errorf("Unmarshal: got %+v, want %+v", out, in)
+	}
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := bplist.NewEncoder(&buf)
+	inputs := []Base{{ID: 1, Name: "one"}, {ID: 2, Name: "two"}, {ID: 3}}
+	for _, in := range inputs {
+		if err := enc.Encode(in); err != nil {
+			t.Fatalf("Encode %+v: %v", in, err)
+		}
+	}
+	data := buf.Bytes()
+
+	dec := bplist.NewDecoder(iotest.OneByteReader(bytes.NewReader(data)))
+	for _, want := range inputs {
+		var got Base
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Decode: unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("Decode: got %+v, want %+v", got, want)
+		}
+	}
+	var extra Base
+	if err := dec.Decode(&extra); err != io.EOF {
+		t.Errorf("Decode at end: got %v, want %v", err, io.EOF)
+	}
+
+	trunc := bplist.NewDecoder(bytes.NewReader(data[:len(data)-1]))
+	for i := range inputs {
+		var got Base
+		err := trunc.Decode(&got)
+		if i < len(inputs)-1 && err != nil {
+			t.Fatalf("Decode %d: unexpected error: %v", i, err)
+		} else if i == len(inputs)-1 && err != io.ErrUnexpectedEOF {
+			t.Errorf("Decode truncated: got %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestParseAny(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := bplist.Marshal(map[string]any{
+		"list":  []any{1, "two", 3.5, false, nil},
+		"data":  []byte{1, 2},
+		"when":  when,
+		"inner": map[string]any{},
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	got, err := bplist.ParseAny(data)
+	if err != nil {
+		t.Fatalf("ParseAny failed: %v", err)
+	}
+	want := map[string]any{
+		"list":  []any{int64(1), "two", 3.5, false, nil},
+		"data":  []byte{1, 2},
+		"when":  when,
+		"inner": map[string]any{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAny:\n got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestBuilderFromGo(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		if err := b.FromGo(map[string]any{"b": []any{1, "x"}, "a": nil}); err != nil {
+			t.Errorf("FromGo: unexpected error: %v", err)
+		}
+		if err := b.FromGo(true); err != nil {
+			t.Errorf("FromGo: unexpected error: %v", err)
+		}
+	})
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	got, err := bplist.ParseAny(data)
+	if err != nil {
+		t.Fatalf("ParseAny failed: %v", err)
+	}
+	want := []any{map[string]any{"a": nil, "b": []any{int64(1), "x"}}, true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAny:\n got %#v\nwant %#v", got, want)
+	}
+
+	if err := bplist.NewBuilder().FromGo(map[int]any{1: 2}); err == nil {
+		t.Error("FromGo with non-string keys: got nil, wanted an error")
+	}
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	data, err := bplist.Marshal(map[string]any{"ID": 1, "Nmae": "typo"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var base Base
+	if err := bplist.NewDecoder(bytes.NewReader(data)).Decode(&base); err != nil {
+		t.Errorf("Decode: unexpected error: %v", err)
+	}
+	dec := bplist.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&base); err == nil {
+		t.Errorf("Decode: got %+v, wanted an unknown-field error", base)
+	}
+}
+
+func TestMarshalOptions(t *testing.T) {
+	type rec struct {
+		Zeta  []int
+		Alpha map[string]any
+		Mid   []byte
+		Beta  int
+	}
+	in := rec{Alpha: map[string]any{"x": []string{}, "y": 1}}
+
+	tests := []struct {
+		opts bplist.MarshalOptions
+		want string
+	}{
+		{bplist.MarshalOptions{}, `V"00"<dict size=4>` +
+			`(string=Zeta)<array size=0></array>` +
+			`(string=Alpha)<dict size=2>(string=x)<array size=0></array>(string=y)(int=1)</dict>` +
+			`(string=Mid)(bytes=0 bytes)(string=Beta)(int=0)</dict>`},
+		{bplist.MarshalOptions{OmitEmpty: true}, `V"00"<dict size=3>` +
+			`(string=Alpha)<dict size=1>(string=y)(int=1)</dict>` +
+			`(string=Mid)(bytes=0 bytes)(string=Beta)(int=0)</dict>`},
+		{bplist.MarshalOptions{SortFields: true}, `V"00"<dict size=4>` +
+			`(string=Alpha)<dict size=2>(string=x)<array size=0></array>(string=y)(int=1)</dict>` +
+			`(string=Beta)(int=0)(string=Mid)(bytes=0 bytes)` +
+			`(string=Zeta)<array size=0></array></dict>`},
+	}
+	for _, test := range tests {
+		data, err := test.opts.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal %+v failed: %v", test.opts, err)
+		}
+		if got := parseString(t, data); got != test.want {
+			t.Errorf("Marshal %+v:\n got %s\nwant %s", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestMarshalDurations(t *testing.T) {
+	type rec struct {
+		Wait time.Duration
+	}
+	in := rec{Wait: 90 * time.Minute}
+	tests := []struct {
+		format bplist.DurationFormat
+		want   string
+	}{
+		{bplist.DurationSeconds, `(float=5400)`},
+		{bplist.DurationNanos, `(int=5400000000000)`},
+		{bplist.DurationISO8601, `(string=PT1H30M)`},
+	}
+	for _, tc := range tests {
+		opts := bplist.MarshalOptions{Durations: tc.format}
+		data, err := opts.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal %v failed: %v", tc.format, err)
+		}
+		want := `V"00"<dict size=1>(string=Wait)` + tc.want + `</dict>`
+		if got := parseString(t, data); got != want {
+			t.Errorf("Marshal %v: got %s, want %s", tc.format, got, want)
+		}
+
+		var out rec
+		if err := opts.Unmarshal(data, &out); err != nil {
+			t.Errorf("Unmarshal %v failed: %v", tc.format, err)
+		} else if out != in {
+			t.Errorf("Unmarshal %v: got %+v, want %+v", tc.format, out, in)
+		}
+
+		// A Decoder with the same options agrees.
+		dec := bplist.NewDecoder(bytes.NewReader(data))
+		dec.SetOptions(&opts)
+		out = rec{}
+		if err := dec.Decode(&out); err != nil {
+			t.Errorf("Decode %v failed: %v", tc.format, err)
+		} else if out != in {
+			t.Errorf("Decode %v: got %+v, want %+v", tc.format, out, in)
+		}
+
+		// FromGo uses the Durations option of the builder.
+		b := bplist.NewBuilder()
+		b.SetOptions(&bplist.BuilderOptions{Durations: tc.format})
+		if err := b.FromGo(in); err != nil {
+			t.Fatalf("FromGo failed: %v", err)
+		}
+		fdata, err := b.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		if got := parseString(t, fdata); got != want {
+			t.Errorf("FromGo %v: got %s, want %s", tc.format, got, want)
+		}
+	}
+}
+
+func TestMarshalByteArray(t *testing.T) {
+	type rec struct {
+		ID  [16]byte
+		Tag [2]uint8
+	}
+	in := rec{ID: [16]byte{1, 2, 3, 15: 16}, Tag: [2]uint8{'o', 'k'}}
+	data, err := bplist.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	const want = `V"00"<dict size=2>(string=ID)(bytes=16 bytes)(string=Tag)(bytes=2 bytes)</dict>`
+	if got := parseString(t, data); got != want {
+		t.Errorf("Marshal: got %s, want %s", got, want)
+	}
+	var out rec
+	if err := bplist.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	} else if out != in {
+		t.Errorf("Unmarshal: got %+v, want %+v", out, in)
+	}
+}
+
+func TestMarshalFastPaths(t *testing.T) {
+	// Named types take the general path, and must encode the same way as the
+	// unnamed types handled by the fast paths.
+	type (
+		strList []string
+		intList []int64
+		strMap  map[string]string
+		intMap  map[string]int64
+	)
+	tests := []struct {
+		fast, slow any
+	}{
+		{[]string{"a", "b"}, strList{"a", "b"}},
+		{[]string(nil), strList(nil)},
+		{[]int64{1, -2, 300}, intList{1, -2, 300}},
+		{map[string]string{"b": "x", "a": "y", "B": "z"}, strMap{"b": "x", "a": "y", "B": "z"}},
+		{map[string]int64{"k": 5, "j": -1}, intMap{"k": 5, "j": -1}},
+		{map[string]any{"list": []string{"x"}}, map[string]any{"list": strList{"x"}}},
+	}
+	for _, tc := range tests {
+		fast, err := bplist.Marshal(tc.fast)
+		if err != nil {
+			t.Fatalf("Marshal %T failed: %v", tc.fast, err)
+		}
+		slow, err := bplist.Marshal(tc.slow)
+		if err != nil {
+			t.Fatalf("Marshal %T failed: %v", tc.slow, err)
+		}
+		if !bytes.Equal(fast, slow) {
+			t.Errorf("Marshal %T:\n got %q\nwant %q", tc.fast, fast, slow)
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	cfg := map[string]string{}
+	for i := range 100 {
+		cfg[fmt.Sprintf("key%03d", i)] = fmt.Sprint("value", i)
+	}
+	b.Run("StringMap", func(b *testing.B) {
+		for range b.N {
+			if _, err := bplist.Marshal(cfg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("AnyMap", func(b *testing.B) {
+		m := make(map[string]any, len(cfg))
+		for k, v := range cfg {
+			m[k] = v
+		}
+		for range b.N {
+			if _, err := bplist.Marshal(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
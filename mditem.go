@@ -0,0 +1,195 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the extended attributes macOS uses to store common Spotlight
+// metadata items. The value of each is a binary property list.
+const (
+	AttrWhereFroms     = "com.apple.metadata:kMDItemWhereFroms"
+	AttrDownloadedDate = "com.apple.metadata:kMDItemDownloadedDate"
+	AttrUserTags       = "com.apple.metadata:_kMDItemUserTags"
+	AttrFinderComment  = "com.apple.metadata:kMDItemFinderComment"
+)
+
+// ParseWhereFroms decodes a kMDItemWhereFroms value, an array of strings
+// giving the origin URLs of a downloaded file.
+func ParseWhereFroms(data []byte) ([]string, error) {
+	vs, err := parseMDArray(data, TString)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = v.(string)
+	}
+	return out, nil
+}
+
+// EncodeWhereFroms encodes urls as a kMDItemWhereFroms value.
+func EncodeWhereFroms(urls []string) ([]byte, error) {
+	return encodeMDArray(TString, len(urls), func(i int) any { return urls[i] })
+}
+
+// ParseDownloadedDate decodes a kMDItemDownloadedDate value, an array of
+// timestamps. In practice the array usually has exactly one element.
+func ParseDownloadedDate(data []byte) ([]time.Time, error) {
+	vs, err := parseMDArray(data, TTime)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]time.Time, len(vs))
+	for i, v := range vs {
+		out[i] = v.(time.Time)
+	}
+	return out, nil
+}
+
+// EncodeDownloadedDate encodes ts as a kMDItemDownloadedDate value.
+func EncodeDownloadedDate(ts ...time.Time) ([]byte, error) {
+	return encodeMDArray(TTime, len(ts), func(i int) any { return ts[i] })
+}
+
+// A UserTag is a single Finder tag from a _kMDItemUserTags value.
+type UserTag struct {
+	Name  string
+	Color int // Finder label color index, 0 for none
+}
+
+// String encodes the tag in the conventional "name\ncolor" form.
+func (u UserTag) String() string {
+	if u.Color == 0 {
+		return u.Name
+	}
+	return u.Name + "\n" + strconv.Itoa(u.Color)
+}
+
+// ParseUserTags decodes a _kMDItemUserTags value. Each element of the array
+// is a tag name, optionally followed by a newline and a color index.
+func ParseUserTags(data []byte) ([]UserTag, error) {
+	vs, err := parseMDArray(data, TString)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]UserTag, len(vs))
+	for i, v := range vs {
+		name, color, ok := strings.Cut(v.(string), "\n")
+		out[i].Name = name
+		if ok {
+			c, err := strconv.Atoi(color)
+			if err != nil {
+				return nil, fmt.Errorf("invalid color for tag %q: %w", name, err)
+			}
+			out[i].Color = c
+		}
+	}
+	return out, nil
+}
+
+// EncodeUserTags encodes tags as a _kMDItemUserTags value.
+func EncodeUserTags(tags []UserTag) ([]byte, error) {
+	return encodeMDArray(TString, len(tags), func(i int) any { return tags[i].String() })
+}
+
+// ParseFinderComment decodes a kMDItemFinderComment value, a single string.
+func ParseFinderComment(data []byte) (string, error) {
+	var h mdHandler
+	h.want = TString
+	h.single = true
+	if err := Parse(data, &h); err != nil {
+		return "", err
+	} else if len(h.vals) != 1 {
+		return "", errors.New("missing comment string")
+	}
+	return h.vals[0].(string), nil
+}
+
+// EncodeFinderComment encodes s as a kMDItemFinderComment value.
+func EncodeFinderComment(s string) ([]byte, error) {
+	b := NewBuilder()
+	b.Value(TString, s)
+	return b.Bytes()
+}
+
+// parseMDArray parses data as an array whose elements all have type want.
+// A TUnicode element satisfies TString, and is converted to a string.
+func parseMDArray(data []byte, want Type) ([]any, error) {
+	h := mdHandler{want: want}
+	if err := Parse(data, &h); err != nil {
+		return nil, err
+	} else if !h.done {
+		return nil, errors.New("missing array")
+	}
+	return h.vals, nil
+}
+
+func encodeMDArray(typ Type, n int, elt func(int) any) ([]byte, error) {
+	b := NewBuilder()
+	b.Open(Array, func(b *Builder) {
+		for i := 0; i < n; i++ {
+			b.Value(typ, elt(i))
+		}
+	})
+	return b.Bytes()
+}
+
+// mdHandler is a Handler that accepts either a flat array of values of a
+// single type, or (if single is true) one bare value of that type.
+type mdHandler struct {
+	want   Type
+	single bool
+	open   bool
+	done   bool
+	vals   []any
+}
+
+func (*mdHandler) Version(string) error { return nil }
+
+func (h *mdHandler) Value(typ Type, datum any) error {
+	if !h.open && !h.single {
+		return fmt.Errorf("unexpected %v outside array", typ)
+	} else if h.single && len(h.vals) != 0 {
+		return fmt.Errorf("unexpected extra %v", typ)
+	}
+	if typ == TUnicode && h.want == TString {
+		typ, datum = TString, string(datum.([]rune))
+	}
+	if typ != h.want {
+		return fmt.Errorf("got %v, want %v", typ, h.want)
+	}
+	h.vals = append(h.vals, datum)
+	return nil
+}
+
+func (h *mdHandler) Open(coll Collection, n int) error {
+	if h.single || h.open || h.done || coll != Array {
+		return fmt.Errorf("unexpected %v", coll)
+	}
+	h.open = true
+	h.vals = make([]any, 0, n)
+	return nil
+}
+
+func (h *mdHandler) Close(Collection) error {
+	h.open, h.done = false, true
+	return nil
+}
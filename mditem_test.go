@@ -0,0 +1,97 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestMDItem(t *testing.T) {
+	t.Run("WhereFroms", func(t *testing.T) {
+		// The download and referrer URLs are often the same, which exercises
+		// deduplication in the encoder.
+		urls := []string{"https://example.com/x.zip", "https://example.com/x.zip"}
+		data, err := bplist.EncodeWhereFroms(urls)
+		if err != nil {
+			t.Fatalf("EncodeWhereFroms: %v", err)
+		}
+		got, err := bplist.ParseWhereFroms(data)
+		if err != nil {
+			t.Fatalf("ParseWhereFroms: %v", err)
+		}
+		if !slices.Equal(got, urls) {
+			t.Errorf("WhereFroms: got %q, want %q", got, urls)
+		}
+	})
+
+	t.Run("DownloadedDate", func(t *testing.T) {
+		when := time.Date(2020, 11, 5, 17, 30, 0, 0, time.UTC)
+		data, err := bplist.EncodeDownloadedDate(when)
+		if err != nil {
+			t.Fatalf("EncodeDownloadedDate: %v", err)
+		}
+		got, err := bplist.ParseDownloadedDate(data)
+		if err != nil {
+			t.Fatalf("ParseDownloadedDate: %v", err)
+		}
+		if len(got) != 1 || !got[0].Equal(when) {
+			t.Errorf("DownloadedDate: got %v, want [%v]", got, when)
+		}
+	})
+
+	t.Run("UserTags", func(t *testing.T) {
+		tags := []bplist.UserTag{{Name: "Red", Color: 6}, {Name: "Plain"}}
+		data, err := bplist.EncodeUserTags(tags)
+		if err != nil {
+			t.Fatalf("EncodeUserTags: %v", err)
+		}
+		got, err := bplist.ParseUserTags(data)
+		if err != nil {
+			t.Fatalf("ParseUserTags: %v", err)
+		}
+		if !slices.Equal(got, tags) {
+			t.Errorf("UserTags: got %v, want %v", got, tags)
+		}
+	})
+
+	t.Run("FinderComment", func(t *testing.T) {
+		const comment = "hello, world"
+		data, err := bplist.EncodeFinderComment(comment)
+		if err != nil {
+			t.Fatalf("EncodeFinderComment: %v", err)
+		}
+		got, err := bplist.ParseFinderComment(data)
+		if err != nil {
+			t.Fatalf("ParseFinderComment: %v", err)
+		}
+		if got != comment {
+			t.Errorf("FinderComment: got %q, want %q", got, comment)
+		}
+	})
+
+	t.Run("WrongShape", func(t *testing.T) {
+		data, err := bplist.EncodeFinderComment("not an array")
+		if err != nil {
+			t.Fatalf("EncodeFinderComment: %v", err)
+		}
+		if got, err := bplist.ParseWhereFroms(data); err == nil {
+			t.Errorf("ParseWhereFroms: got %q, wanted an error", got)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import "errors"
+
+// recover reconstructs the trailer and offset table of the input of p, whose
+// own trailer is missing or damaged, and prepares p to deliver objects to h.
+//
+// The objects of a binary property list are normally written contiguously
+// following the header, so their offsets can be recovered by decoding them in
+// sequence until the input ends or an object cannot be decoded. The size of an
+// object reference is not known, so each possible size is tried in turn, and
+// the one giving the fewest references to objects that were not recovered is
+// chosen; some such references are expected if the input is truncated. The
+// root is taken to be the first collection that is not an element of another,
+// or the first object if there is no such collection.
+func (o ParseOptions) recover(p *parser, h Handler) error {
+	p.opts, p.h = o, h
+	best := -1
+	for _, rb := range []int{1, 2, 4, 8} {
+		offsets, end := p.scanObjects(rb)
+		if len(offsets) == 0 {
+			continue
+		}
+		root, missing := p.findRoot(offsets, rb)
+		if best >= 0 && missing >= best {
+			continue
+		}
+		best = missing
+		p.t = &Trailer{
+			OffsetBytes: 8,
+			RefBytes:    rb,
+			NumObjects:  len(offsets),
+			RootObject:  root,
+			OffsetTable: end,
+		}
+		p.offsets = offsets
+	}
+	if best < 0 {
+		return errors.New("no objects could be recovered")
+	}
+	return nil
+}
+
+// scanObjects decodes the objects of the input of p in sequence from the end
+// of the header, assuming object references of rb bytes each. It returns the
+// offsets of the objects it decoded and the offset where the scan stopped.
+func (p *parser) scanObjects(rb int) ([]int, int) {
+	var offsets []int
+	off := len(magic) + 2
+	for off < p.size {
+		n, err := p.objLen(off, rb)
+		if err != nil || n > p.size-off {
+			break
+		}
+		offsets = append(offsets, off)
+		off += n
+	}
+	return offsets, off
+}
+
+// findRoot returns the ID of the first collection among the objects at the
+// given offsets that is not referred to by any other, or 0 if there is none,
+// and the number of references to objects not in the list, assuming object
+// references of rb bytes each.
+func (p *parser) findRoot(offsets []int, rb int) (root, missing int) {
+	refd := make([]bool, len(offsets))
+	var colls []int
+	for id, off := range offsets {
+		buf, _ := p.slice(off, 1)
+		tag := buf[0]
+		per := 1
+		switch tag >> 4 {
+		case 10, 11, 12:
+		case 13:
+			per = 2
+		default:
+			continue
+		}
+		colls = append(colls, id)
+		size, shift, _ := p.objSize(off, tag)
+		refs, _ := p.slice(off+1+shift, size*per*rb)
+		for i := 0; i < len(refs); i += rb {
+			if ref := parseInt(refs[i : i+rb]); ref < 0 || ref >= int64(len(offsets)) {
+				missing++
+			} else {
+				refd[ref] = true
+			}
+		}
+	}
+	for _, id := range colls {
+		if !refd[id] {
+			return id, missing
+		}
+	}
+	return 0, missing
+}
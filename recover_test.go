@@ -0,0 +1,84 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestRecover(t *testing.T) {
+	parse := func(t *testing.T, opts bplist.ParseOptions, data []byte) (string, error) {
+		t.Helper()
+		var buf strings.Builder
+		err := opts.Parse(data, testHandler{log: t.Logf, buf: &buf})
+		return buf.String(), err
+	}
+
+	t.Run("MissingTrailer", func(t *testing.T) {
+		data, err := bplist.Marshal(map[string]any{"a": 1, "b": []any{"x", true}})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		want, err := parse(t, bplist.ParseOptions{}, data)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+
+		clipped := data[:len(data)-20]
+		if _, err := parse(t, bplist.ParseOptions{}, clipped); err == nil {
+			t.Error("Parse without Recover: got nil, wanted an error")
+		}
+		var warns []string
+		got, err := parse(t, bplist.ParseOptions{
+			Recover: true,
+			Warn:    func(w bplist.Warning) { warns = append(warns, w.String()) },
+		}, clipped)
+		if err != nil {
+			t.Fatalf("Parse with Recover: unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("Parse with Recover: got %q, want %q", got, want)
+		}
+		if len(warns) == 0 || !strings.Contains(warns[0], "recovered 8 objects") {
+			t.Errorf("Warnings: got %q, wanted a recovery warning", warns)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		data := mkPlist([]byte{0xa3, 1, 2, 3}, []byte{0x51, 'x'}, []byte{0x10, 0x05}, []byte{0x52, 'y', 'z'})
+		clipped := data[:18] // ends within object 3
+
+		if _, err := parse(t, bplist.ParseOptions{Recover: true}, clipped); err == nil {
+			t.Error("Parse with Recover: got nil, wanted an error")
+		}
+		got, err := parse(t, bplist.ParseOptions{Recover: true, ContinueOnError: true}, clipped)
+		if err == nil {
+			t.Error("Parse with ContinueOnError: got nil, wanted an error")
+		}
+		const want = `V"00"<array size=3>(string=x)(int=5)(null=<nil>)</array>`
+		if got != want {
+			t.Errorf("Parse with ContinueOnError: got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Unrecoverable", func(t *testing.T) {
+		if _, err := parse(t, bplist.ParseOptions{Recover: true}, []byte("bplist00\xff\xff")); err == nil {
+			t.Error("Parse: got nil, wanted an error")
+		}
+	})
+}
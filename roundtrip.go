@@ -0,0 +1,140 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RoundTrip parses data as a binary property list, rebuilds it with a Builder,
+// and parses the result. It reports nil if both parses deliver the same
+// logical content, or otherwise an error describing the first difference.
+//
+// The comparison is logical, not byte-for-byte: TString and TUnicode values
+// with the same text are equal, since the builder may choose a different
+// string encoding than the original.
+func RoundTrip(data []byte) error {
+	var orig eventLog
+	if err := Parse(data, &orig); err != nil {
+		return fmt.Errorf("parsing input: %w", err)
+	}
+
+	b := NewBuilder()
+	if err := orig.replay(b); err != nil {
+		return fmt.Errorf("rebuilding: %w", err)
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	var next eventLog
+	if err := Parse(buf.Bytes(), &next); err != nil {
+		return fmt.Errorf("parsing output: %w", err)
+	}
+	for i, want := range orig {
+		if i >= len(next) {
+			return fmt.Errorf("output ends after %d events, missing %s", i, want)
+		} else if got := next[i]; got.String() != want.String() {
+			return fmt.Errorf("event %d: got %s, want %s", i, got, want)
+		}
+	}
+	if len(next) > len(orig) {
+		return fmt.Errorf("output has %d extra events, first %s", len(next)-len(orig), next[len(orig)])
+	}
+	return nil
+}
+
+// An event records a single Handler call.
+type event struct {
+	method string // one of "version", "value", "open", "close"
+	typ    Type
+	coll   Collection
+	n      int
+	datum  any // for version, the version string
+}
+
+func (e event) String() string {
+	switch e.method {
+	case "version":
+		return fmt.Sprintf("version %q", e.datum)
+	case "value":
+		typ, datum := e.typ, e.datum
+		switch t := datum.(type) {
+		case []rune:
+			datum = string(t)
+		case []byte:
+			datum = fmt.Sprintf("%x", t)
+		}
+		if typ == TUnicode {
+			typ = TString
+		}
+		return fmt.Sprintf("%v(%v)", typ, datum)
+	case "open":
+		return fmt.Sprintf("open %v(%d)", e.coll, e.n)
+	case "close":
+		return fmt.Sprintf("close %v", e.coll)
+	}
+	return "unknown"
+}
+
+// An eventLog is a Handler that records the calls made to it.
+type eventLog []event
+
+func (e *eventLog) Version(v string) error {
+	*e = append(*e, event{method: "version", datum: v})
+	return nil
+}
+
+func (e *eventLog) Value(typ Type, datum any) error {
+	if b, ok := datum.([]byte); ok {
+		datum = bytes.Clone(b)
+	}
+	*e = append(*e, event{method: "value", typ: typ, datum: datum})
+	return nil
+}
+
+func (e *eventLog) Open(coll Collection, n int) error {
+	*e = append(*e, event{method: "open", coll: coll, n: n})
+	return nil
+}
+
+func (e *eventLog) Close(coll Collection) error {
+	*e = append(*e, event{method: "close", coll: coll})
+	return nil
+}
+
+// replay adds the recorded events to b.
+func (e eventLog) replay(b *Builder) error {
+	h := BuilderHandler(b)
+	for _, ev := range e {
+		var err error
+		switch ev.method {
+		case "version":
+			err = h.Version(ev.datum.(string))
+		case "value":
+			err = h.Value(ev.typ, ev.datum)
+		case "open":
+			err = h.Open(ev.coll, ev.n)
+		case "close":
+			err = h.Close(ev.coll)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"io"
+	"sync"
+)
+
+// A SafeBuilder is a Builder that is safe for concurrent use by multiple
+// goroutines. Each method call is applied to the underlying Builder as a
+// single step, so that concurrent producers can contribute elements without
+// external locking. The zero value is ready for use.
+//
+// In particular, Open holds exclusive access to the builder while its
+// function runs, so each collection added by Open is complete and contains
+// only the elements added by that function. The order of the elements added
+// by different goroutines depends on the order of their calls. To collect
+// such elements as siblings in a single root collection, set the ImplicitRoot
+// option:
+//
+//	var sb bplist.SafeBuilder
+//	sb.SetOptions(&bplist.BuilderOptions{ImplicitRoot: bplist.Array})
+//	// ... start goroutines that call sb.Open and sb.Value ...
+type SafeBuilder struct {
+	mu sync.Mutex
+	b  Builder
+}
+
+// SetOptions sets the encoding options for b, as for Builder.SetOptions.
+func (b *SafeBuilder) SetOptions(opts *BuilderOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.b.SetOptions(opts)
+}
+
+// Value adds a single data element to the property list, as for Builder.Value.
+func (b *SafeBuilder) Value(typ Type, datum any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Value(typ, datum)
+}
+
+// Open adds a new collection of the given type and calls f to populate it, as
+// for Builder.Open. No other method of b can proceed until f returns, so f
+// must add elements to the *Builder it is given, not to b.
+func (b *SafeBuilder) Open(coll Collection, f func(*Builder)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.b.Open(coll, f)
+}
+
+// Err reports the last error that caused an operation on b to fail, as for
+// Builder.Err.
+func (b *SafeBuilder) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Err()
+}
+
+// Reset discards all the data associated with b, as for Builder.Reset.
+func (b *SafeBuilder) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.b.Reset()
+}
+
+// WriteTo encodes the property list and writes it in binary form to w, as for
+// Builder.WriteTo.
+func (b *SafeBuilder) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.WriteTo(w)
+}
+
+// Bytes encodes the property list and returns it in binary form, as for
+// Builder.Bytes.
+func (b *SafeBuilder) Bytes() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Bytes()
+}
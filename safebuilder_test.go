@@ -0,0 +1,68 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestSafeBuilder(t *testing.T) {
+	var sb bplist.SafeBuilder
+	sb.SetOptions(&bplist.BuilderOptions{ImplicitRoot: bplist.Array})
+
+	const numWorkers = 8
+	const numItems = 50
+	var wg sync.WaitGroup
+	for i := range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sb.Open(bplist.Array, func(b *bplist.Builder) {
+				for j := range numItems {
+					b.Value(bplist.TInteger, i*numItems+j)
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	data, err := sb.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	v, err := bplist.ParseValue(data)
+	if err != nil {
+		t.Fatalf("ParseValue failed: %v", err)
+	}
+	if got := v.Len(); got != numWorkers {
+		t.Fatalf("Root has %d elements, want %d", got, numWorkers)
+	}
+	for _, elt := range v.Array() {
+		if elt.Len() != numItems {
+			t.Errorf("Collection has %d elements, want %d", elt.Len(), numItems)
+			continue
+		}
+		// Each collection holds a contiguous run of the values of one worker.
+		first := elt.Array()[0].Int()
+		for j, x := range elt.Array() {
+			if got := x.Int(); got != first+int64(j) {
+				t.Errorf("Element %d: got %d, want %d", j, got, first+int64(j))
+			}
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// ParseReader reads a complete binary property list from r and parses it,
+// calling the methods of h to deliver the results. It uses default options;
+// see ParseOptions.ParseReader for other settings.
+func ParseReader(r io.Reader, h Handler) error { return ParseOptions{}.ParseReader(r, h) }
+
+// ParseReader reads a complete binary property list from r and parses it
+// using the options in o, calling the methods of h to deliver the results.
+//
+// Since the index of a binary property list is at the end, the whole input
+// must be read before any of it can be parsed. When r reports its size, as
+// an *os.File or *bytes.Reader does, ParseReader reads the input into a
+// single buffer of that size, without the intermediate copies made by
+// io.ReadAll. To parse a large file without reading all of it into memory,
+// see ParseReaderAt.
+func (o ParseOptions) ParseReader(r io.Reader, h Handler) error {
+	data, err := readAll(r)
+	if err != nil {
+		return err
+	}
+	return o.Parse(data, h)
+}
+
+// ParseReaderAt parses the binary property list of the given size in bytes
+// read from r, calling the methods of h to deliver the results. It uses
+// default options; see ParseOptions.ParseReaderAt for other settings.
+func ParseReaderAt(r io.ReaderAt, size int64, h Handler) error {
+	return ParseOptions{}.ParseReaderAt(r, size, h)
+}
+
+// ParseReaderAt parses the binary property list of the given size in bytes
+// read from r, using the options in o and calling the methods of h to deliver
+// the results.
+//
+// Unlike ParseReader, ParseReaderAt does not read the whole input into
+// memory. It reads the trailer and offset table, and then reads each object
+// at its offset as it is parsed, so that a large file (or a memory-mapped
+// one) can be parsed without making a copy of it. Since each object is read
+// separately, the data delivered to h are never slices of a shared buffer,
+// even with the ZeroCopy option. See also ParseLazyAt.
+func (o ParseOptions) ParseReaderAt(r io.ReaderAt, size int64, h Handler) error {
+	p, err := readerAtParser(r, size)
+	if err != nil {
+		return err
+	}
+	return o.parse(p, h)
+}
+
+// ParseAll parses data as a sequence of binary property lists written back to
+// back, calling the methods of h to deliver the contents of each in turn. The
+// start of each property list is marked by a call to h.Version. It uses
+// default options; see ParseOptions.ParseAll for other settings.
+func ParseAll(data []byte, h Handler) error { return ParseOptions{}.ParseAll(data, h) }
+
+// ParseAll parses data as a sequence of binary property lists written back to
+// back, using the options in o, and calling the methods of h to deliver the
+// contents of each in turn. An error is annotated with the offset in data of
+// the property list where it occurred. If h reports ErrStop, parsing of the
+// current property list ends, and ParseAll continues with the next.
+func (o ParseOptions) ParseAll(data []byte, h Handler) error {
+	for off := 0; off < len(data); {
+		n, err := o.ParsePrefix(data[off:], h)
+		if err != nil {
+			return fmt.Errorf("property list at offset %d: %w", off, err)
+		}
+		off += n
+	}
+	return nil
+}
+
+// ParsePrefix parses the binary property list at the beginning of data, which
+// may be followed by other data, calling the methods of h to deliver the
+// results. It returns the length in bytes of the property list. It uses
+// default options; see ParseOptions.ParsePrefix for other settings.
+//
+// By contrast, Parse requires the property list to span all of data, and
+// reports an error if any data follow it.
+func ParsePrefix(data []byte, h Handler) (int, error) { return ParseOptions{}.ParsePrefix(data, h) }
+
+// ParsePrefix parses the binary property list at the beginning of data, which
+// may be followed by other data, using the options in o and calling the
+// methods of h to deliver the results. It returns the length in bytes of the
+// property list.
+//
+// A binary property list does not record its own length at the front, so
+// ParsePrefix finds its end by searching for a trailer consistent with the
+// data preceding it.
+func (o ParseOptions) ParsePrefix(data []byte, h Handler) (int, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return 0, structErr(0, errors.New("invalid magic number"))
+	}
+	n := scanEnd(data, 0)
+	if n < 0 {
+		return 0, structErr(-1, errors.New("incomplete property list"))
+	}
+	return n, o.Parse(data[:n], h)
+}
+
+// readAll reads all of r, using its size to allocate a buffer if known.
+func readAll(r io.Reader) ([]byte, error) {
+	var size int64 = -1
+	switch t := r.(type) {
+	case interface{ Len() int }:
+		size = int64(t.Len())
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := t.Stat(); err == nil && fi.Mode().IsRegular() {
+			size = fi.Size()
+		}
+	}
+	if size < 0 || int64(int(size)) != size {
+		return io.ReadAll(r)
+	}
+	// Allow one extra byte, so that a short read confirms the size.
+	buf := make([]byte, int(size)+1)
+	n, err := io.ReadFull(r, buf)
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return buf[:n], nil
+	case nil: // the input is longer than reported
+		rest, err := io.ReadAll(r)
+		return append(buf, rest...), err
+	}
+	return nil, err
+}
+
+// An Encoder writes binary property lists to an output stream.
+type Encoder struct {
+	w    io.Writer
+	opts MarshalOptions
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder { return &Encoder{w: w} }
+
+// Encode writes the binary property list encoding of v to the stream, as
+// described for Marshal, using the options set by SetOptions.
+func (e *Encoder) Encode(v any) error {
+	data, err := e.opts.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// SetOptions sets the options used to encode subsequent values. If opts ==
+// nil, default options are restored.
+func (e *Encoder) SetOptions(opts *MarshalOptions) {
+	if opts == nil {
+		e.opts = MarshalOptions{}
+	} else {
+		e.opts = *opts
+	}
+}
+
+// A Decoder reads binary property lists from an input stream.
+type Decoder struct {
+	u    unmarshaler
+	r    io.Reader
+	buf  []byte // data read from r but not yet consumed
+	scan int    // offset in buf of the next candidate end to check
+	err  error  // error from the last read of r, if any
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+//
+// The Decoder introduces its own buffering and may read data from r beyond
+// the property lists it decodes.
+func NewDecoder(r io.Reader) *Decoder { return &Decoder{r: r} }
+
+// Decode reads the next binary property list from the stream and stores the
+// result in the value pointed to by v, as described for Unmarshal. When no
+// further input remains, Decode reports io.EOF.
+//
+// A binary property list does not record its own length at the front, so
+// Decode finds the end of each one by searching for a trailer consistent
+// with the data preceding it.
+func (d *Decoder) Decode(v any) error {
+	data, err := d.next()
+	if err != nil {
+		return err
+	}
+	return d.u.decode(data, v)
+}
+
+// DisallowUnknownFields causes the Decoder to report an error when a Dict
+// being decoded into a struct has a key that does not match any field,
+// rather than ignoring it.
+func (d *Decoder) DisallowUnknownFields() { d.u.disallowUnknown = true }
+
+// SetOptions sets the options used to decode subsequent values, so that the
+// Decoder can read values written by an Encoder with the same options. If
+// opts == nil, default options are restored. Options that affect only
+// encoding are ignored.
+func (d *Decoder) SetOptions(opts *MarshalOptions) {
+	if opts == nil {
+		d.u.durations = 0
+	} else {
+		d.u.durations = opts.Durations
+	}
+}
+
+// Parse reads the next binary property list from the stream and delivers its
+// contents to h, as described for Parse. It returns the length in bytes of the
+// property list consumed from the stream. When no further input remains,
+// Parse reports io.EOF.
+func (d *Decoder) Parse(h Handler) (int, error) {
+	data, err := d.next()
+	if err != nil {
+		return 0, err
+	}
+	return len(data), Parse(data, h)
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer.
+// The reader is valid until the next call to Decode.
+func (d *Decoder) Buffered() io.Reader { return bytes.NewReader(d.buf) }
+
+// minPlist is the length of the smallest possible binary property list: the
+// header, a single one-byte object, its one-byte offset, and the trailer.
+const minPlist = len(magic) + 2 + 1 + 1 + trailerBytes
+
+// next consumes and returns the next complete property list from the input.
+func (d *Decoder) next() ([]byte, error) {
+	if d.scan < minPlist {
+		d.scan = minPlist
+	}
+	for {
+		if len(d.buf) >= len(magic) && !bytes.HasPrefix(d.buf, []byte(magic)) {
+			return nil, structErr(0, errors.New("invalid magic number"))
+		}
+		if end := scanEnd(d.buf, d.scan); end > 0 {
+			data := d.buf[:end:end]
+			d.buf, d.scan = d.buf[end:], 0
+			return data, nil
+		}
+		d.scan = len(d.buf) + 1
+		if d.err != nil {
+			if d.err == io.EOF && len(d.buf) != 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, d.err
+		}
+		d.fill()
+	}
+}
+
+// fill reads more data from the input into the buffer.
+func (d *Decoder) fill() {
+	const chunk = 4096
+	d.buf = append(d.buf, make([]byte, chunk)...)
+	nr, err := d.r.Read(d.buf[len(d.buf)-chunk:])
+	d.buf = d.buf[:len(d.buf)-chunk+nr]
+	d.err = err
+}
+
+// scanEnd returns the offset of the first candidate end of a property list in
+// data at or after offset from, or -1 if there is none.
+func scanEnd(data []byte, from int) int {
+	for end := max(from, minPlist); end <= len(data); end++ {
+		if isTrailerAt(data, end) {
+			return end
+		}
+	}
+	return -1
+}
+
+// isTrailerAt reports whether the trailerBytes ending at offset end of data
+// form a trailer whose offset table ends immediately before it.
+func isTrailerAt(data []byte, end int) bool {
+	if end < minPlist {
+		return false
+	}
+	t := data[end-trailerBytes : end]
+	offBytes, refBytes := uint64(t[6]), uint64(t[7])
+	numObjects := binary.BigEndian.Uint64(t[8:])
+	rootObject := binary.BigEndian.Uint64(t[16:])
+	offsetTable := binary.BigEndian.Uint64(t[24:])
+	limit := uint64(end - trailerBytes)
+	if offBytes < 1 || offBytes > 8 || refBytes < 1 || refBytes > 8 ||
+		numObjects == 0 || numObjects > limit || rootObject >= numObjects ||
+		offsetTable < uint64(len(magic)+2) || offsetTable > limit {
+		return false
+	}
+	return offsetTable+numObjects*offBytes == limit
+}
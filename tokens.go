@@ -0,0 +1,241 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+// TokenKind enumerates the kinds of parse events reported as tokens.
+type TokenKind int
+
+// Constants defining the token kinds.
+const (
+	VersionToken TokenKind = iota + 1 // the version string of the input
+	ValueToken                        // a primitive value
+	OpenToken                         // the beginning of a collection
+	CloseToken                        // the end of a collection
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case VersionToken:
+		return "version"
+	case ValueToken:
+		return "value"
+	case OpenToken:
+		return "open"
+	case CloseToken:
+		return "close"
+	}
+	return "unknown"
+}
+
+// A Token is a single parse event from a binary property list, corresponding
+// to one call of a Handler method.
+type Token struct {
+	Kind TokenKind
+
+	Version    string     // for VersionToken: the version string
+	Type       Type       // for ValueToken: the type of the value
+	Datum      any        // for ValueToken: the datum, as for Handler.Value
+	Collection Collection // for OpenToken and CloseToken: the collection type
+	Len        int        // for OpenToken: the number of elements, as for Handler.Open
+
+	// The byte offset in the input of the object the token describes, or of
+	// the version string for a VersionToken. A CloseToken has the offset of
+	// the collection it closes. The offset is -1 for a placeholder delivered
+	// in place of an invalid object (see ParseOptions.ContinueOnError).
+	Offset int
+}
+
+// Tokens returns a sequence of the parse events for the binary property list
+// in data, in the order they would be delivered to a Handler by Parse. If an
+// error occurs, it is reported by a final element with a zero Token, and the
+// sequence ends. It uses default options; see ParseOptions.Tokens for other
+// settings.
+func Tokens(data []byte) iter.Seq2[Token, error] { return ParseOptions{}.Tokens(data) }
+
+// Tokens returns a sequence of the parse events for the binary property list
+// in data, using the options in o, as described for Tokens.
+func (o ParseOptions) Tokens(data []byte) iter.Seq2[Token, error] {
+	return func(yield func(Token, error) bool) {
+		h := &tokenHandler{yield: yield, off: -1}
+		if err := o.Parse(data, h); err != nil {
+			yield(Token{}, err)
+		}
+	}
+}
+
+// A TokenDecoder reads the parse events of a binary property list one at a
+// time, as tokens. Unlike Tokens, it allows the caller to skip the contents
+// of a collection without decoding them.
+type TokenDecoder struct {
+	opts ParseOptions
+	data []byte
+	p    *parser // nil until the version is read
+	ver  []byte  // the version string
+	th   tokenHandler
+	tok  Token   // the last token delivered to th
+	stk  []frame // the open collections
+	err  error   // the first error reported by Next
+}
+
+// NewTokenDecoder returns a TokenDecoder for the binary property list in data.
+// It uses default options; see ParseOptions.NewTokenDecoder for other settings.
+func NewTokenDecoder(data []byte) *TokenDecoder { return ParseOptions{}.NewTokenDecoder(data) }
+
+// NewTokenDecoder returns a TokenDecoder for the binary property list in data,
+// using the options in o. The Warn option is ignored, since a TokenDecoder
+// need not visit every object.
+func (o ParseOptions) NewTokenDecoder(data []byte) *TokenDecoder {
+	o.Warn = nil
+	d := &TokenDecoder{opts: o, data: data}
+	d.th = tokenHandler{yield: d.capture, off: -1}
+	return d
+}
+
+func (d *TokenDecoder) capture(tok Token, _ error) bool { d.tok = tok; return true }
+
+// Next returns the next token from the input. The tokens are those that
+// Tokens would report, in the same order. When no tokens remain, Next
+// reports io.EOF. After any other error, Next reports the same error on each
+// subsequent call.
+//
+// With the ContinueOnError option, the problems recorded during decoding are
+// reported together, as for Parse, by the call to Next after the last token.
+func (d *TokenDecoder) Next() (Token, error) {
+	if d.err != nil {
+		return Token{}, d.err
+	} else if d.p != nil && d.p.t != nil && len(d.stk) == 0 && len(d.p.errs) != 0 {
+		err := errors.Join(d.p.errs...)
+		d.p.errs = nil
+		return Token{}, err
+	}
+	tok, err := d.next()
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+	return tok, err
+}
+
+func (d *TokenDecoder) next() (Token, error) {
+	if d.p == nil {
+		if err := d.opts.checkFraming(d.data); err != nil {
+			return Token{}, err
+		}
+		p := &parser{data: d.data, size: len(d.data)}
+		ver, err := p.slice(len(magic), 2)
+		if err != nil {
+			return Token{}, err
+		}
+		d.p, d.ver = p, ver
+		d.th.Version(string(ver))
+		return d.tok, nil
+	} else if d.p.t == nil {
+		if err := d.opts.prepare(d.p, &d.th, d.ver); err != nil {
+			return Token{}, err
+		}
+		return d.elem(d.p.t.RootObject)
+	}
+
+	if len(d.stk) == 0 {
+		return Token{}, io.EOF
+	}
+	top := &d.stk[len(d.stk)-1]
+	if id := top.nextRef(d.p); id >= 0 {
+		return d.elem(id)
+	}
+	d.close()
+	return d.tok, nil
+}
+
+// elem delivers the object with the given ID, and opens it if it is a
+// collection.
+func (d *TokenDecoder) elem(id int) (Token, error) {
+	f, err := d.p.parseElem(id)
+	if err != nil {
+		return Token{}, err
+	} else if f.coll != 0 {
+		d.stk = append(d.stk, f)
+	}
+	return d.tok, nil
+}
+
+// close closes the innermost open collection.
+func (d *TokenDecoder) close() {
+	top := d.stk[len(d.stk)-1]
+	d.stk = d.stk[:len(d.stk)-1]
+	d.p.leave(top.id)
+	d.th.Close(top.coll)
+}
+
+// Skip discards the remaining elements of the innermost open collection,
+// without decoding them, along with the token that would close it. It is
+// typically called after Next reports an OpenToken, to skip a collection the
+// caller does not need. If no collection is open, Skip does nothing.
+func (d *TokenDecoder) Skip() error {
+	if d.err != nil {
+		return d.err
+	} else if len(d.stk) != 0 {
+		d.close()
+	}
+	return nil
+}
+
+// tokenHandler is a SpanHandler that delivers each event as a Token.
+type tokenHandler struct {
+	yield func(Token, error) bool
+	off   int   // the offset of the next object, or -1 if unknown
+	open  []int // the offsets of the open collections
+}
+
+// next returns the offset of the current object, and resets it.
+func (h *tokenHandler) next() int {
+	off := h.off
+	h.off = -1
+	return off
+}
+
+func (h *tokenHandler) emit(tok Token) error {
+	if !h.yield(tok, nil) {
+		return ErrStop
+	}
+	return nil
+}
+
+func (h *tokenHandler) Span(off, _ int) error { h.off = off; return nil }
+
+func (h *tokenHandler) Version(v string) error {
+	return h.emit(Token{Kind: VersionToken, Version: v, Offset: len(magic)})
+}
+
+func (h *tokenHandler) Value(typ Type, datum any) error {
+	return h.emit(Token{Kind: ValueToken, Type: typ, Datum: datum, Offset: h.next()})
+}
+
+func (h *tokenHandler) Open(coll Collection, n int) error {
+	off := h.next()
+	h.open = append(h.open, off)
+	return h.emit(Token{Kind: OpenToken, Collection: coll, Len: n, Offset: off})
+}
+
+func (h *tokenHandler) Close(coll Collection) error {
+	off := h.open[len(h.open)-1]
+	h.open = h.open[:len(h.open)-1]
+	return h.emit(Token{Kind: CloseToken, Collection: coll, Offset: off})
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/bplist"
+)
+
+// formatToken renders tok compactly for comparison in tests.
+func formatToken(tok bplist.Token) string {
+	switch tok.Kind {
+	case bplist.VersionToken:
+		return fmt.Sprintf("V%q@%d", tok.Version, tok.Offset)
+	case bplist.ValueToken:
+		return fmt.Sprintf("(%s=%v)@%d", tok.Type, tok.Datum, tok.Offset)
+	case bplist.OpenToken:
+		return fmt.Sprintf("<%s size=%d>@%d", tok.Collection, tok.Len, tok.Offset)
+	case bplist.CloseToken:
+		return fmt.Sprintf("</%s>@%d", tok.Collection, tok.Offset)
+	}
+	return "?"
+}
+
+func TestTokens(t *testing.T) {
+	// {"a": ["bc", 1000]}
+	input := mkPlist(
+		[]byte{0xd1, 1, 2},
+		[]byte{0x51, 'a'},
+		[]byte{0xa2, 3, 4},
+		[]byte{0x52, 'b', 'c'},
+		[]byte{0x11, 0x03, 0xe8},
+	)
+
+	t.Run("All", func(t *testing.T) {
+		var got []string
+		for tok, err := range bplist.Tokens(input) {
+			if err != nil {
+				t.Fatalf("Tokens: unexpected error: %v", err)
+			}
+			got = append(got, formatToken(tok))
+		}
+		const want = `V"00"@6 <dict size=1>@8 (string=a)@11 <array size=2>@13 ` +
+			`(string=bc)@16 (int=1000)@19 </array>@13 </dict>@8`
+		if s := strings.Join(got, " "); s != want {
+			t.Errorf("Tokens:\n got %s\nwant %s", s, want)
+		}
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		var n int
+		for _, err := range bplist.Tokens(input) {
+			if err != nil {
+				t.Fatalf("Tokens: unexpected error: %v", err)
+			}
+			if n++; n == 3 {
+				break
+			}
+		}
+		if n != 3 {
+			t.Errorf("Tokens: got %d tokens, want 3", n)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		bad := mkPlist([]byte{0xa2, 1, 7}, []byte{0x09})
+		var got []string
+		var lastErr error
+		for tok, err := range bplist.Tokens(bad) {
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			got = append(got, formatToken(tok))
+		}
+		if lastErr == nil {
+			t.Error("Tokens: got no error, wanted one")
+		}
+		const want = `V"00"@6 <array size=2>@8 (bool=true)@11`
+		if s := strings.Join(got, " "); s != want {
+			t.Errorf("Tokens:\n got %s\nwant %s", s, want)
+		}
+	})
+
+	t.Run("Placeholder", func(t *testing.T) {
+		bad := mkPlist([]byte{0xa2, 1, 7}, []byte{0x09})
+		opts := bplist.ParseOptions{ContinueOnError: true}
+		var got []string
+		for tok, err := range opts.Tokens(bad) {
+			if err == nil {
+				got = append(got, formatToken(tok))
+			}
+		}
+		const want = `V"00"@6 <array size=2>@8 (bool=true)@11 (null=<nil>)@-1 </array>@8`
+		if s := strings.Join(got, " "); s != want {
+			t.Errorf("Tokens:\n got %s\nwant %s", s, want)
+		}
+	})
+}
+
+func TestTokenDecoder(t *testing.T) {
+	// {"a": ["bc", 1000], "d": true}
+	input := mkPlist(
+		[]byte{0xd2, 1, 5, 2, 6},
+		[]byte{0x51, 'a'},
+		[]byte{0xa2, 3, 4},
+		[]byte{0x52, 'b', 'c'},
+		[]byte{0x11, 0x03, 0xe8},
+		[]byte{0x51, 'd'},
+		[]byte{0x09},
+	)
+	readAll := func(t *testing.T, d *bplist.TokenDecoder, skip bool) string {
+		t.Helper()
+		var got []string
+		for {
+			tok, err := d.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Next: unexpected error: %v", err)
+			}
+			got = append(got, formatToken(tok))
+			if skip && tok.Kind == bplist.OpenToken && tok.Collection == bplist.Array {
+				if err := d.Skip(); err != nil {
+					t.Fatalf("Skip: unexpected error: %v", err)
+				}
+			}
+		}
+		if _, err := d.Next(); err != io.EOF {
+			t.Errorf("Next at end: got %v, want %v", err, io.EOF)
+		}
+		return strings.Join(got, " ")
+	}
+
+	t.Run("All", func(t *testing.T) {
+		var want []string
+		for tok, err := range bplist.Tokens(input) {
+			if err != nil {
+				t.Fatalf("Tokens: unexpected error: %v", err)
+			}
+			want = append(want, formatToken(tok))
+		}
+		if got := readAll(t, bplist.NewTokenDecoder(input), false); got != strings.Join(want, " ") {
+			t.Errorf("Next:\n got %s\nwant %s", got, strings.Join(want, " "))
+		}
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		// The skipped elements are not decoded, so an invalid one is not noticed.
+		bad := slices.Clone(input)
+		bad[21] = 0xf0 // the tag of object 4
+
+		const want = `V"00"@6 <dict size=2>@8 (string=a)@13 <array size=2>@15 ` +
+			`(string=d)@24 (bool=true)@26 </dict>@8`
+		if got := readAll(t, bplist.NewTokenDecoder(bad), true); got != want {
+			t.Errorf("Next:\n got %s\nwant %s", got, want)
+		}
+		if err := bplist.Parse(bad, nopHandler{}); err == nil {
+			t.Error("Parse: got nil, wanted an error")
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		d := bplist.NewTokenDecoder(input[:len(input)-1])
+		if tok, err := d.Next(); err != nil || tok.Kind != bplist.VersionToken {
+			t.Fatalf("Next: got (%v, %v), wanted a version token", tok, err)
+		}
+		if _, err := d.Next(); err == nil {
+			t.Fatal("Next: got nil, wanted an error")
+		}
+		if _, err := d.Next(); err == nil || err == io.EOF {
+			t.Errorf("Next after error: got %v, wanted the same error", err)
+		}
+	})
+
+	t.Run("ContinueOnError", func(t *testing.T) {
+		bad := mkPlist([]byte{0xa2, 1, 7}, []byte{0x09})
+		d := bplist.ParseOptions{ContinueOnError: true}.NewTokenDecoder(bad)
+		var got []string
+		for {
+			tok, err := d.Next()
+			if err != nil {
+				if err == io.EOF {
+					t.Error("Next: got EOF, wanted the recorded error")
+				}
+				break
+			}
+			got = append(got, formatToken(tok))
+		}
+		const want = `V"00"@6 <array size=2>@8 (bool=true)@11 (null=<nil>)@-1 </array>@8`
+		if s := strings.Join(got, " "); s != want {
+			t.Errorf("Next:\n got %s\nwant %s", s, want)
+		}
+		if _, err := d.Next(); err != io.EOF {
+			t.Errorf("Next at end: got %v, want %v", err, io.EOF)
+		}
+	})
+}
@@ -0,0 +1,368 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// Unmarshal decodes the binary property list in data and stores the result in
+// the value pointed to by v, which must be a non-nil pointer.
+//
+// Unmarshal uses the inverse of the rules described for Marshal, allocating
+// pointers, slices, and maps as needed. In addition:
+//
+//   - A Dict is decoded into a struct by matching each key to the name
+//     Marshal would use for a field, including names given by struct tags,
+//     preferring an exact match but accepting one that differs only in
+//     case. Keys that match no field are ignored.
+//   - An Array, Set, or OrderedSet is decoded into a slice, or into an array,
+//     in which case excess elements are discarded and missing ones zeroed.
+//   - A TNull leaves the target unchanged, except that it sets a pointer,
+//     interface, slice, or map to nil.
+//   - A TInteger may be decoded into a floating-point value.
+//   - A TString is decoded into a value whose pointer implements
+//     encoding.TextUnmarshaler by calling its UnmarshalText method.
+//   - Decoding into a Value or *Value stores the Value tree for the data.
+//   - Decoding into an empty interface stores a bool, int64, float64, string,
+//     []byte, time.Time, []any, or map[string]any, or nil for a TNull. An
+//     integer outside the range of int64 is a uint64 or *big.Int, and a TUID
+//     is a []byte.
+//
+// A converter registered with RegisterConverter for the type of a target
+// takes precedence over the rules above when decoding a value other than a
+// collection or TNull.
+//
+// If a value cannot be decoded into its target, Unmarshal reports an error.
+func Unmarshal(data []byte, v any) error {
+	return (&unmarshaler{}).decode(data, v)
+}
+
+// decode parses data and decodes the result into v.
+func (u *unmarshaler) decode(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cannot decode into %T", v)
+	}
+	root, err := ParseValue(data)
+	if err != nil {
+		return err
+	}
+	return u.unmarshal(root, rv.Elem())
+}
+
+// ParseAny parses the binary property list in data and returns its contents
+// as a generic Go value, as Unmarshal does when decoding into an empty
+// interface: a Dict is a map[string]any, an Array, Set, or OrderedSet is an
+// []any, and other values are their data as delivered to a Handler, except
+// that a TUnicode value is a string and a TNull is nil.
+func ParseAny(data []byte) (any, error) {
+	v, err := ParseValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+var (
+	valueType    = reflect.TypeFor[Value]()
+	valuePtrType = reflect.TypeFor[*Value]()
+)
+
+// An unmarshaler decodes values into Go values.
+type unmarshaler struct {
+	disallowUnknown bool           // report an error for keys that match no field
+	durations       DurationFormat // the representation of a time.Duration
+}
+
+func (u *unmarshaler) unmarshal(n *Value, v reflect.Value) error {
+	switch v.Type() {
+	case valueType:
+		v.Set(reflect.ValueOf(*n))
+		return nil
+	case valuePtrType:
+		v.Set(reflect.ValueOf(n))
+		return nil
+	}
+	if n.kind == KNull {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+			v.SetZero()
+		}
+		return nil
+	}
+	typ, isElem := n.kind.elementType()
+	if isElem {
+		if c := lookupConverter(v.Type()); c != nil && c.decode != nil {
+			cv, err := c.decode(typ, n.datum)
+			if err != nil {
+				return err
+			}
+			v.Set(cv)
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return u.unmarshal(n, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			break
+		}
+		if x := n.Interface(); x == nil {
+			v.SetZero()
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		if n.kind == KTime {
+			v.Set(reflect.ValueOf(n.datum))
+			return nil
+		}
+		return u.mismatch(n, v)
+	case durationType:
+		d, err := u.durations.Decode(typ, n.datum)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case urlType:
+		url, err := DecodeURL(typ, n.datum)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*url))
+		return nil
+	case addrType:
+		addr, err := DecodeAddr(typ, n.datum)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(addr))
+		return nil
+	case prefixType:
+		pfx, err := DecodePrefix(typ, n.datum)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(pfx))
+		return nil
+	}
+	if s, ok := n.datum.(string); ok && n.kind == KString && v.CanAddr() {
+		if pv := v.Addr(); pv.Type().Implements(textUnmarshalerType) {
+			return pv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	switch n.kind {
+	case KArray, KSet, KOrderedSet:
+		return u.unmarshalList(n, v)
+	case KDict:
+		return u.unmarshalDict(n, v)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if b, ok := n.datum.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if z, ok := n.datum.(int64); ok {
+			if v.OverflowInt(z) {
+				return fmt.Errorf("integer %d overflows %v", z, v.Type())
+			}
+			v.SetInt(z)
+			return nil
+		} else if n.kind == KInteger {
+			return fmt.Errorf("integer %v overflows %v", n.datum, v.Type())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var z uint64
+		switch t := n.datum.(type) {
+		case int64:
+			if t < 0 {
+				return fmt.Errorf("integer %d overflows %v", t, v.Type())
+			}
+			z = uint64(t)
+		case uint64:
+			z = t
+		default:
+			if n.kind == KInteger {
+				return fmt.Errorf("integer %v overflows %v", n.datum, v.Type())
+			}
+			return u.mismatch(n, v)
+		}
+		if v.OverflowUint(z) {
+			return fmt.Errorf("integer %d overflows %v", z, v.Type())
+		}
+		v.SetUint(z)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch t := n.datum.(type) {
+		case float64:
+			f = t
+		case int64:
+			f = float64(t)
+		case uint64:
+			f = float64(t)
+		case *big.Int:
+			f, _ = new(big.Float).SetInt(t).Float64()
+		default:
+			return u.mismatch(n, v)
+		}
+		if v.Kind() == reflect.Float32 && math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+			return fmt.Errorf("real %v overflows %v", f, v.Type())
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.String:
+		if s, ok := n.datum.(string); ok && n.kind == KString {
+			v.SetString(s)
+			return nil
+		}
+	case reflect.Slice:
+		if b, ok := n.datum.([]byte); ok && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(bytes.Clone(b))
+			return nil
+		}
+	case reflect.Array:
+		if b, ok := n.datum.([]byte); ok && v.Type().Elem().Kind() == reflect.Uint8 {
+			if len(b) != v.Len() {
+				return fmt.Errorf("cannot decode %d bytes into %v", len(b), v.Type())
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+	}
+	return u.mismatch(n, v)
+}
+
+func (u *unmarshaler) unmarshalList(n *Value, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), len(n.elts), len(n.elts)))
+	case reflect.Array:
+		v.SetZero()
+	default:
+		return u.mismatch(n, v)
+	}
+	for i, elt := range n.elts {
+		if i >= v.Len() {
+			break
+		}
+		if err := u.unmarshal(elt, v.Index(i)); err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (u *unmarshaler) unmarshalDict(n *Value, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("cannot decode dict into %v", v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(n.ents)))
+		}
+		for _, e := range n.ents {
+			elt := reflect.New(v.Type().Elem()).Elem()
+			if err := u.unmarshal(e.Value, elt); err != nil {
+				return fmt.Errorf("key %q: %w", e.Key, err)
+			}
+			v.SetMapIndex(reflect.ValueOf(e.Key).Convert(v.Type().Key()), elt)
+		}
+		return nil
+
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		for _, e := range n.ents {
+			key := e.Key
+			f, ok := findField(fields, key)
+			if !ok {
+				if u.disallowUnknown {
+					return fmt.Errorf("unknown field %q in %v", key, v.Type())
+				}
+				continue
+			}
+			fv, err := allocFieldByIndex(v, f.index)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+			if err := u.unmarshal(e.Value, fv); err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+		}
+		return nil
+	}
+	return u.mismatch(n, v)
+}
+
+func (u *unmarshaler) mismatch(n *Value, v reflect.Value) error {
+	return fmt.Errorf("cannot decode %v into %v", n.kind, v.Type())
+}
+
+// findField returns the field whose name matches key, preferring an exact
+// match to a case-insensitive one.
+func findField(fields []field, key string) (field, bool) {
+	for _, f := range fields {
+		if f.name == key {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// allocFieldByIndex is like v.FieldByIndex, but allocates nil embedded
+// pointers along the path. It reports an error if such a pointer is to an
+// unexported type, since it cannot be set.
+func allocFieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
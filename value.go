@@ -0,0 +1,876 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Value is a node in an in-memory tree representing a property list. A
+// Value is either an element, whose datum has one of the types described for
+// the Type enumerators, or a collection of further values.
+//
+// Both string encodings of the binary format are represented as KString, and
+// the datum of a string value is always a Go string. The keys of a Dict must
+// be strings.
+//
+// A tree may be obtained from ParseValue or built with NewValue and related
+// functions, modified in place, and encoded with WriteTo.
+//
+// A nil *Value is valid, and has kind KInvalid.
+type Value struct {
+	kind  Kind
+	datum any      // for an element
+	elts  []*Value // for an Array, Set, or OrderedSet
+	ents  []Entry  // for a Dict
+}
+
+// ParseValue parses the binary property list in data and returns the tree of
+// values it contains. The result does not alias data.
+func ParseValue(data []byte) (*Value, error) {
+	var vh ValueHandler
+	if err := Parse(data, &vh); err != nil {
+		return nil, err
+	} else if vh.root == nil {
+		return nil, errors.New("no root value")
+	}
+	return vh.root, nil
+}
+
+// An Entry is a single key/value pair of a Dict.
+type Entry struct {
+	Key   string
+	Value *Value
+}
+
+// Kind enumerates the kinds of Value.
+type Kind int
+
+// Constants defining the kinds of Value.
+const (
+	KInvalid    Kind = iota // a nil *Value
+	KNull                   // TNull
+	KBool                   // TBool
+	KInteger                // TInteger
+	KFloat                  // TFloat
+	KTime                   // TTime
+	KBytes                  // TBytes
+	KString                 // TString or TUnicode
+	KUID                    // TUID
+	KArray                  // an Array collection
+	KSet                    // a Set collection
+	KOrderedSet             // an OrderedSet collection
+	KDict                   // a Dict collection
+	KRaw                    // TRaw
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KInvalid:
+		return "invalid"
+	case KArray:
+		return Array.String()
+	case KSet:
+		return Set.String()
+	case KOrderedSet:
+		return OrderedSet.String()
+	case KDict:
+		return Dict.String()
+	}
+	if typ, ok := k.elementType(); ok {
+		return typ.String()
+	}
+	return "unknown"
+}
+
+// elementType returns the element type for k, and reports whether k is the
+// kind of an element.
+func (k Kind) elementType() (Type, bool) {
+	switch k {
+	case KNull:
+		return TNull, true
+	case KBool:
+		return TBool, true
+	case KInteger:
+		return TInteger, true
+	case KFloat:
+		return TFloat, true
+	case KTime:
+		return TTime, true
+	case KBytes:
+		return TBytes, true
+	case KString:
+		return TString, true
+	case KUID:
+		return TUID, true
+	case KRaw:
+		return TRaw, true
+	}
+	return 0, false
+}
+
+// collection returns the collection type for k, or 0 if k is not the kind
+// of a collection.
+func (k Kind) collection() Collection {
+	switch k {
+	case KArray:
+		return Array
+	case KSet:
+		return Set
+	case KOrderedSet:
+		return OrderedSet
+	case KDict:
+		return Dict
+	}
+	return 0
+}
+
+func typeKind(typ Type) Kind {
+	switch typ {
+	case TNull:
+		return KNull
+	case TBool:
+		return KBool
+	case TInteger:
+		return KInteger
+	case TFloat:
+		return KFloat
+	case TTime:
+		return KTime
+	case TBytes:
+		return KBytes
+	case TString, TUnicode:
+		return KString
+	case TUID:
+		return KUID
+	case TRaw:
+		return KRaw
+	}
+	return KInvalid
+}
+
+func collectionKind(coll Collection) Kind {
+	switch coll {
+	case Array:
+		return KArray
+	case Set:
+		return KSet
+	case OrderedSet:
+		return KOrderedSet
+	case Dict:
+		return KDict
+	}
+	return KInvalid
+}
+
+// Kind returns the kind of v.
+func (v *Value) Kind() Kind {
+	if v == nil {
+		return KInvalid
+	}
+	return v.kind
+}
+
+// Datum returns the datum of an element, or nil for a collection.
+func (v *Value) Datum() any {
+	if v == nil {
+		return nil
+	}
+	return v.datum
+}
+
+// Bool returns the value of a KBool, or false for other kinds.
+func (v *Value) Bool() bool {
+	b, _ := v.Datum().(bool)
+	return b
+}
+
+// Int returns the value of a KInteger, or 0 for other kinds. If the integer
+// is outside the range of int64, Int returns 0; use Datum to obtain it.
+func (v *Value) Int() int64 {
+	z, _ := v.Datum().(int64)
+	return z
+}
+
+// Float returns the value of a KFloat or KInteger, or 0 for other kinds.
+func (v *Value) Float() float64 {
+	switch t := v.Datum().(type) {
+	case float64:
+		return t
+	case int64:
+		return float64(t)
+	case uint64:
+		return float64(t)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(t).Float64()
+		return f
+	}
+	return 0
+}
+
+// Time returns the value of a KTime, or the zero time for other kinds.
+func (v *Value) Time() time.Time {
+	t, _ := v.Datum().(time.Time)
+	return t
+}
+
+// Bytes returns the contents of a KBytes, KUID, or KRaw, or nil for other
+// kinds.
+// The caller must not modify the result.
+func (v *Value) Bytes() []byte {
+	b, _ := v.Datum().([]byte)
+	return b
+}
+
+// UID returns the value of a KUID, or 0 for other kinds. If the UID is more
+// than 8 bytes long, only the low-order 8 bytes are used.
+func (v *Value) UID() uint64 {
+	if v.Kind() != KUID {
+		return 0
+	}
+	var buf [8]byte
+	b := v.Bytes()
+	if len(b) > len(buf) {
+		b = b[len(b)-len(buf):]
+	}
+	copy(buf[len(buf)-len(b):], b)
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// String returns the value of a KString. For other kinds, it returns a
+// string of the form "<kind value>", as reflect.Value does.
+func (v *Value) String() string {
+	if s, ok := v.Datum().(string); ok && v.kind == KString {
+		return s
+	}
+	return "<" + v.Kind().String() + " value>"
+}
+
+// Len returns the number of elements of a collection, counting each entry of
+// a Dict once. It returns 0 for other kinds.
+func (v *Value) Len() int {
+	if v == nil {
+		return 0
+	} else if v.kind == KDict {
+		return len(v.ents)
+	}
+	return len(v.elts)
+}
+
+// Array returns the elements of a KArray, KSet, or KOrderedSet, or nil for
+// other kinds. The caller must not modify the result.
+func (v *Value) Array() []*Value {
+	if v == nil {
+		return nil
+	}
+	return v.elts
+}
+
+// Dict returns the entries of a KDict in order, or nil for other kinds.
+// The caller must not modify the result.
+func (v *Value) Dict() []Entry {
+	if v == nil {
+		return nil
+	}
+	return v.ents
+}
+
+// Interface returns the contents of v as a generic Go value: a Dict is a
+// map[string]any, an Array, Set, or OrderedSet is an []any, a KNull or
+// KInvalid is nil, and any other value is its datum.
+func (v *Value) Interface() any {
+	switch v.Kind() {
+	case KInvalid, KNull:
+		return nil
+	case KDict:
+		m := make(map[string]any, len(v.ents))
+		for _, e := range v.ents {
+			m[e.Key] = e.Value.Interface()
+		}
+		return m
+	case KArray, KSet, KOrderedSet:
+		out := make([]any, len(v.elts))
+		for i, elt := range v.elts {
+			out[i] = elt.Interface()
+		}
+		return out
+	}
+	return v.datum
+}
+
+// A ValueHandler is a Handler that assembles the objects it receives into a
+// tree of values. It may be passed to Parse to decode a whole property list,
+// or another Handler may delegate a subtree to it by forwarding the events
+// for that subtree. A zero ValueHandler is ready for use.
+//
+// The data of the values do not alias those delivered to the handler, and
+// strings delivered as []byte with the ZeroCopy option are decoded.
+type ValueHandler struct {
+	stk  []*Value
+	keys [][]*Value // pending dict keys and values, parallel to stk
+	root *Value
+}
+
+// Root returns the root of the tree assembled by h, or nil if h has not yet
+// received a complete value.
+func (h *ValueHandler) Root() *Value {
+	if len(h.stk) != 0 {
+		return nil
+	}
+	return h.root
+}
+
+// Reset discards the state of h, so that it can assemble another value.
+func (h *ValueHandler) Reset() { *h = ValueHandler{} }
+
+// Version implements part of the Handler interface. It does nothing.
+func (*ValueHandler) Version(string) error { return nil }
+
+// Value implements part of the Handler interface.
+func (h *ValueHandler) Value(typ Type, datum any) error {
+	switch d := datum.(type) {
+	case []byte:
+		switch typ {
+		case TString: // ZeroCopy ASCII or UTF-8
+			datum = string(d)
+		case TUnicode: // ZeroCopy UTF-16
+			datum = string(decodeUTF16(d))
+		default:
+			datum = bytes.Clone(d) // do not alias the input
+		}
+	case []rune:
+		datum = string(d)
+	}
+	return h.add(&Value{kind: typeKind(typ), datum: datum})
+}
+
+// Open implements part of the Handler interface.
+func (h *ValueHandler) Open(coll Collection, n int) error {
+	v := &Value{kind: collectionKind(coll)}
+	var pend []*Value
+	if coll == Dict {
+		v.ents = make([]Entry, 0, n)
+		pend = make([]*Value, 0, 2*n)
+	} else {
+		v.elts = make([]*Value, 0, n)
+	}
+	if err := h.add(v); err != nil {
+		return err
+	}
+	h.stk = append(h.stk, v)
+	h.keys = append(h.keys, pend)
+	return nil
+}
+
+// Close implements part of the Handler interface. It reports an error if
+// the collection is a dict with a key that is not a string.
+func (h *ValueHandler) Close(Collection) error {
+	top, pend := h.stk[len(h.stk)-1], h.keys[len(h.keys)-1]
+	h.stk, h.keys = h.stk[:len(h.stk)-1], h.keys[:len(h.keys)-1]
+	if top.kind != KDict {
+		return nil
+	}
+	for i := 0; i+1 < len(pend); i += 2 {
+		key, ok := pend[i].datum.(string)
+		if !ok || pend[i].kind != KString {
+			return fmt.Errorf("dict key is %v, not string", pend[i].kind)
+		}
+		top.ents = append(top.ents, Entry{Key: key, Value: pend[i+1]})
+	}
+	return nil
+}
+
+func (h *ValueHandler) add(v *Value) error {
+	if len(h.stk) == 0 {
+		if h.root != nil {
+			return errors.New("multiple root values")
+		}
+		h.root = v
+		return nil
+	}
+	i := len(h.stk) - 1
+	if top := h.stk[i]; top.kind == KDict {
+		h.keys[i] = append(h.keys[i], v)
+	} else {
+		top.elts = append(top.elts, v)
+	}
+	return nil
+}
+
+// NewValue returns a new element value of the given type and datum. A
+// TUnicode value is converted to a KString, with a string datum, and an int
+// or int32 datum for a TInteger is converted to int64. The datum is not
+// otherwise checked until the value is written.
+func NewValue(typ Type, datum any) *Value {
+	if r, ok := datum.([]rune); ok {
+		datum = string(r)
+	} else if z, ok := intValue(datum); ok && typ == TInteger {
+		datum = z
+	}
+	return &Value{kind: typeKind(typ), datum: datum}
+}
+
+// NewCollection returns a new empty collection of the given type.
+func NewCollection(coll Collection) *Value {
+	return &Value{kind: collectionKind(coll)}
+}
+
+// NewArray returns a new Array containing the given elements.
+func NewArray(elts ...*Value) *Value {
+	return &Value{kind: KArray, elts: elts}
+}
+
+// NewDict returns a new Dict containing the given entries. If a key occurs
+// more than once, only the last entry with that key is kept, at the position
+// of the first.
+func NewDict(ents ...Entry) *Value {
+	v := &Value{kind: KDict}
+	for _, e := range ents {
+		v.SetKey(e.Key, e.Value)
+	}
+	return v
+}
+
+// SetKey sets the value of key in a Dict to val. If key is already present
+// its value is replaced in place; otherwise a new entry is added at the end.
+// SetKey panics if v is not a KDict.
+func (v *Value) SetKey(key string, val *Value) {
+	v.mustBe(KDict, "SetKey")
+	for i, e := range v.ents {
+		if e.Key == key {
+			v.ents[i].Value = val
+			return
+		}
+	}
+	v.ents = append(v.ents, Entry{Key: key, Value: val})
+}
+
+// Delete removes key from a Dict, and reports whether it was present.
+// Delete panics if v is not a KDict.
+func (v *Value) Delete(key string) bool {
+	v.mustBe(KDict, "Delete")
+	for i, e := range v.ents {
+		if e.Key == key {
+			v.ents = slices.Delete(v.ents, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// Append adds vals to the end of an Array, Set, or OrderedSet. Append panics
+// if v is not one of those kinds.
+func (v *Value) Append(vals ...*Value) {
+	v.mustBeList("Append")
+	v.elts = append(v.elts, vals...)
+}
+
+// Replace replaces element i of an Array, Set, or OrderedSet with val.
+// Replace panics if v is not one of those kinds, or if i is out of range.
+func (v *Value) Replace(i int, val *Value) {
+	v.mustBeList("Replace")
+	v.elts[i] = val
+}
+
+// Remove removes element i of an Array, Set, or OrderedSet, shifting the
+// elements after it down. Remove panics if v is not one of those kinds, or if
+// i is out of range.
+func (v *Value) Remove(i int) {
+	v.mustBeList("Remove")
+	v.elts = slices.Delete(v.elts, i, i+1)
+}
+
+func (v *Value) mustBe(k Kind, method string) {
+	if v.Kind() != k {
+		panic(fmt.Sprintf("bplist: Value.%s of %v value", method, v.Kind()))
+	}
+}
+
+func (v *Value) mustBeList(method string) {
+	switch v.Kind() {
+	case KArray, KSet, KOrderedSet:
+	default:
+		panic(fmt.Sprintf("bplist: Value.%s of %v value", method, v.Kind()))
+	}
+}
+
+// WriteTo encodes v as a binary property list and writes it to w, using
+// default builder options.
+func (v *Value) WriteTo(w io.Writer) (int64, error) {
+	b := NewBuilder()
+	if err := v.build(b); err != nil {
+		return 0, err
+	}
+	return b.WriteTo(w)
+}
+
+// build adds v and its contents to b.
+func (v *Value) build(b *Builder) error {
+	if typ, ok := v.Kind().elementType(); ok {
+		return b.Value(typ, v.datum)
+	}
+	coll := v.Kind().collection()
+	if coll == 0 {
+		return errors.New("invalid value")
+	}
+	b.open(coll)
+	for i, elt := range v.elts {
+		if err := elt.build(b); err != nil {
+			return fmt.Errorf("index %d: %w", i, err)
+		}
+	}
+	for _, e := range v.ents {
+		if err := b.Value(TString, e.Key); err != nil {
+			return err
+		}
+		if err := e.Value.build(b); err != nil {
+			return fmt.Errorf("key %q: %w", e.Key, err)
+		}
+	}
+	return b.close(coll)
+}
+
+// ErrNotFound is reported by Lookup and Get when a path does not address a
+// value in the tree.
+var ErrNotFound = errors.New("value not found")
+
+// Key returns the value of key in a Dict, or nil if v is not a Dict or does
+// not contain key.
+func (v *Value) Key(key string) *Value {
+	for _, e := range v.Dict() {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// Index returns element i of an Array, Set, or OrderedSet, or nil if v is not
+// one of those kinds or i is out of range.
+func (v *Value) Index(i int) *Value {
+	if elts := v.Array(); i >= 0 && i < len(elts) {
+		return elts[i]
+	}
+	return nil
+}
+
+// Lookup returns the value addressed by path, starting from v. Each element
+// of path must be a string, selecting a key of a Dict, or an int, selecting
+// an element of an Array, Set, or OrderedSet. If the path does not address
+// a value, Lookup reports an error wrapping ErrNotFound.
+func (v *Value) Lookup(path ...any) (*Value, error) {
+	cur := v
+	for i, p := range path {
+		var next *Value
+		switch t := p.(type) {
+		case string:
+			next = cur.Key(t)
+		case int:
+			next = cur.Index(t)
+		default:
+			return nil, fmt.Errorf("invalid path element %T", p)
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%w: %v at %s", ErrNotFound, formatPathElem(p), formatPath(path[:i]))
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// Get returns the value addressed by keypath, starting from v. A keypath is
+// a sequence of dictionary keys separated by periods, as used by plutil, for
+// example "Items.3.Name". An array element may be selected either by a
+// decimal index as a component, as in the example, or by an index in
+// brackets following a component, as in "Items[3].Name". A backslash
+// escapes the following character, so that a key may contain a period or a
+// bracket. If the keypath does not address a value, Get reports an error
+// wrapping ErrNotFound.
+func (v *Value) Get(keypath string) (*Value, error) {
+	path, err := parseKeypath(keypath)
+	if err != nil {
+		return nil, err
+	}
+	cur := v
+	for i, p := range path {
+		// A numeric component selects an element if cur is not a Dict.
+		if s, ok := p.(string); ok && cur.Kind() != KDict {
+			if n, err := strconv.Atoi(s); err == nil {
+				path[i] = n
+			}
+		}
+		next, err := cur.Lookup(path[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v at %s", ErrNotFound, formatPathElem(path[i]), formatPath(path[:i]))
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// parseKeypath splits a keypath into a sequence of string keys and int
+// indexes, as described for Get. An empty keypath addresses the root.
+func parseKeypath(s string) ([]any, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var path []any
+	var key strings.Builder
+	inKey := true // reading the key of the current component
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '.':
+			if inKey {
+				path = append(path, key.String())
+				key.Reset()
+			}
+			inKey = true
+		case c == '[':
+			if key.Len() != 0 {
+				path = append(path, key.String())
+				key.Reset()
+			}
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket at offset %d", i)
+			}
+			n, err := strconv.Atoi(s[i+1 : i+end])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid index %q at offset %d", s[i+1:i+end], i)
+			}
+			path = append(path, n)
+			i += end
+			inKey = false
+		case !inKey:
+			return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, errors.New("keypath ends with an escape")
+			}
+			i++
+			key.WriteByte(s[i])
+		default:
+			key.WriteByte(c)
+		}
+	}
+	if inKey {
+		path = append(path, key.String())
+	}
+	return path, nil
+}
+
+func formatPathElem(p any) string {
+	if n, ok := p.(int); ok {
+		return "index " + strconv.Itoa(n)
+	}
+	return fmt.Sprintf("key %q", p)
+}
+
+// formatPath renders a path for diagnostics, in the form accepted by Get.
+func formatPath(path []any) string {
+	if len(path) == 0 {
+		return "root"
+	}
+	var sb strings.Builder
+	for i, p := range path {
+		switch t := p.(type) {
+		case int:
+			fmt.Fprintf(&sb, "[%d]", t)
+		default:
+			if i > 0 {
+				sb.WriteByte('.')
+			}
+			s := fmt.Sprint(t)
+			for j := 0; j < len(s); j++ {
+				if c := s[j]; c == '.' || c == '[' || c == ']' || c == '\\' {
+					sb.WriteByte('\\')
+				}
+				sb.WriteByte(s[j])
+			}
+		}
+	}
+	return sb.String()
+}
+
+// SkipChildren and SkipAll may be returned by the callback to Walk to skip
+// the contents of the current collection, or all remaining values.
+var (
+	SkipChildren = errors.New("skip children")
+	SkipAll      = errors.New("skip all")
+)
+
+// Walk calls f for v and each value it contains, in depth-first order. The
+// path gives the keys (strings) and indexes (ints) leading from v to each
+// value, as accepted by Lookup; it is empty for v itself. The contents of
+// the path slice are valid only during the call to f.
+//
+// If f returns SkipChildren for a collection, its contents are not visited.
+// If f returns SkipAll, Walk stops and returns nil. If f returns any other
+// error, Walk stops and returns that error.
+func (v *Value) Walk(f func(path []any, v *Value) error) error {
+	err := v.walk(nil, f)
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (v *Value) walk(path []any, f func([]any, *Value) error) error {
+	if err := f(slices.Clip(path), v); err == SkipChildren {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for i, elt := range v.Array() {
+		if err := elt.walk(append(path, i), f); err != nil {
+			return err
+		}
+	}
+	for _, e := range v.Dict() {
+		if err := e.Value.walk(append(path, e.Key), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Equal reports whether v and w are deeply equal. Values are equal if they
+// have the same kind and:
+//
+//   - Integers are numerically equal, regardless of their datum types.
+//   - Floats are equal according to ==, except that NaN equals NaN.
+//   - Times represent the same instant.
+//   - The elements of an Array or OrderedSet are pairwise equal, in order.
+//   - The elements of a Set are equal in some order, counting duplicates.
+//   - Dicts have the same keys, with equal values, in any order.
+//
+// Other elements are equal if their data are equal.
+func (v *Value) Equal(w *Value) bool {
+	if v.Kind() != w.Kind() {
+		return false
+	}
+	switch v.Kind() {
+	case KInvalid, KNull:
+		return true
+	case KInteger:
+		x, xok := bigIntValue(v.datum)
+		y, yok := bigIntValue(w.datum)
+		if xok && yok {
+			return x.Cmp(y) == 0
+		}
+	case KFloat:
+		x, xok := v.datum.(float64)
+		y, yok := w.datum.(float64)
+		if xok && yok {
+			return x == y || (math.IsNaN(x) && math.IsNaN(y))
+		}
+	case KTime:
+		x, xok := v.datum.(time.Time)
+		y, yok := w.datum.(time.Time)
+		if xok && yok {
+			return x.Equal(y)
+		}
+	case KArray, KOrderedSet:
+		return slices.EqualFunc(v.elts, w.elts, (*Value).Equal)
+	case KSet:
+		return setEqual(v.elts, w.elts)
+	case KDict:
+		if len(v.ents) != len(w.ents) {
+			return false
+		}
+		m := make(map[string]*Value, len(w.ents))
+		for _, e := range w.ents {
+			m[e.Key] = e.Value
+		}
+		for _, e := range v.ents {
+			o, ok := m[e.Key]
+			if !ok || !e.Value.Equal(o) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(v.datum, w.datum)
+}
+
+// setEqual reports whether xs and ys contain equal elements in some order.
+func setEqual(xs, ys []*Value) bool {
+	if len(xs) != len(ys) {
+		return false
+	}
+	used := make([]bool, len(ys))
+nextX:
+	for _, x := range xs {
+		for i, y := range ys {
+			if !used[i] && x.Equal(y) {
+				used[i] = true
+				continue nextX
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// bigIntValue converts an integer datum to a *big.Int.
+func bigIntValue(datum any) (*big.Int, bool) {
+	switch t := datum.(type) {
+	case int64:
+		return big.NewInt(t), true
+	case uint64:
+		return new(big.Int).SetUint64(t), true
+	case *big.Int:
+		return t, true
+	}
+	return nil, false
+}
+
+// Clone returns a deep copy of v, sharing no mutable state with it.
+func (v *Value) Clone() *Value {
+	if v == nil {
+		return nil
+	}
+	c := &Value{kind: v.kind, datum: v.datum}
+	switch t := v.datum.(type) {
+	case []byte:
+		c.datum = bytes.Clone(t)
+	case *big.Int:
+		c.datum = new(big.Int).Set(t)
+	}
+	if v.elts != nil {
+		c.elts = make([]*Value, len(v.elts))
+		for i, elt := range v.elts {
+			c.elts[i] = elt.Clone()
+		}
+	}
+	if v.ents != nil {
+		c.ents = make([]Entry, len(v.ents))
+		for i, e := range v.ents {
+			c.ents[i] = Entry{Key: e.Key, Value: e.Value.Clone()}
+		}
+	}
+	return c
+}
@@ -0,0 +1,574 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/creachadair/bplist"
+)
+
+func TestValue(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := bplist.Marshal(map[string]any{
+		"bool":  true,
+		"int":   -25,
+		"float": 1.5,
+		"time":  when,
+		"bytes": []byte("xyz"),
+		"list":  []any{"a", nil, "c"},
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	root, err := bplist.ParseValue(data)
+	if err != nil {
+		t.Fatalf("ParseValue failed: %v", err)
+	}
+	if got := root.Kind(); got != bplist.KDict {
+		t.Fatalf("Root kind: got %v, want %v", got, bplist.KDict)
+	}
+	vals := make(map[string]*bplist.Value)
+	var keys []string
+	for _, e := range root.Dict() {
+		keys = append(keys, e.Key)
+		vals[e.Key] = e.Value
+	}
+	if got, want := root.Len(), len(keys); got != want {
+		t.Errorf("Len: got %d, want %d", got, want)
+	}
+	if got := vals["bool"].Bool(); !got {
+		t.Errorf("Bool: got %v, want true", got)
+	}
+	if got := vals["int"].Int(); got != -25 {
+		t.Errorf("Int: got %v, want -25", got)
+	}
+	if got := vals["float"].Float(); got != 1.5 {
+		t.Errorf("Float: got %v, want 1.5", got)
+	}
+	if got := vals["time"].Time(); !got.Equal(when) {
+		t.Errorf("Time: got %v, want %v", got, when)
+	}
+	if got := string(vals["bytes"].Bytes()); got != "xyz" {
+		t.Errorf("Bytes: got %q, want xyz", got)
+	}
+
+	list := vals["list"]
+	if got := list.Kind(); got != bplist.KArray {
+		t.Fatalf("List kind: got %v, want %v", got, bplist.KArray)
+	}
+	elts := list.Array()
+	if len(elts) != 3 {
+		t.Fatalf("List: got %d elements, want 3", len(elts))
+	}
+	if got := elts[0].String(); got != "a" {
+		t.Errorf("Element 0: got %q, want a", got)
+	}
+	if got := elts[1].Kind(); got != bplist.KNull {
+		t.Errorf("Element 1: got %v, want %v", got, bplist.KNull)
+	}
+
+	// Accessors of the wrong kind return zero values.
+	if got := vals["bool"].Int(); got != 0 {
+		t.Errorf("Int of bool: got %d, want 0", got)
+	}
+	if got := vals["int"].String(); got != "<int value>" {
+		t.Errorf("String of int: got %q, want <int value>", got)
+	}
+	// Unmarshal into a *Value produces the same tree.
+	var cmp *bplist.Value
+	if err := bplist.Unmarshal(data, &cmp); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	} else if !reflect.DeepEqual(cmp, root) {
+		t.Errorf("Unmarshal: got %v, want %v", cmp.Interface(), root.Interface())
+	}
+
+	if _, err := bplist.ParseValue([]byte("bplist00 nonsense")); err == nil {
+		t.Error("ParseValue of invalid data: got nil, wanted an error")
+	}
+
+	var missing *bplist.Value
+	if got := missing.Kind(); got != bplist.KInvalid {
+		t.Errorf("Nil kind: got %v, want %v", got, bplist.KInvalid)
+	}
+}
+
+func TestValueMutate(t *testing.T) {
+	doc := bplist.NewDict(
+		bplist.Entry{Key: "name", Value: bplist.NewValue(bplist.TString, "old")},
+		bplist.Entry{Key: "drop", Value: bplist.NewValue(bplist.TBool, true)},
+		bplist.Entry{Key: "items", Value: bplist.NewArray(
+			bplist.NewValue(bplist.TInteger, 1),
+			bplist.NewValue(bplist.TInteger, 2),
+		)},
+	)
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	// Read the encoded document back, modify it, and write it again.
+	root, err := bplist.ParseValue(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseValue failed: %v", err)
+	}
+	root.SetKey("name", bplist.NewValue(bplist.TUnicode, []rune("néw")))
+	root.SetKey("extra", bplist.NewCollection(bplist.Set))
+	if !root.Delete("drop") {
+		t.Error(`Delete "drop": got false, want true`)
+	}
+	if root.Delete("nonesuch") {
+		t.Error(`Delete "nonesuch": got true, want false`)
+	}
+	items := root.Dict()[1].Value
+	items.Append(bplist.NewValue(bplist.TFloat, 3.5))
+	items.Replace(0, bplist.NewValue(bplist.TNull, nil))
+	items.Remove(1)
+
+	buf.Reset()
+	if _, err := root.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	const want = `V"00"<dict size=3>` +
+		`(string=name)(string=néw)` +
+		`(string=items)<array size=2>(null=<nil>)(float=3.5)</array>` +
+		`(string=extra)<set size=0></set>` +
+		`</dict>`
+	if got := parseString(t, buf.Bytes()); got != want {
+		t.Errorf("Result:\n got %s\nwant %s", got, want)
+	}
+
+	if _, err := bplist.NewArray(nil).WriteTo(&buf); err == nil {
+		t.Error("WriteTo with a nil element: got nil, wanted an error")
+	}
+	mustPanic(t, "SetKey of array", func() { items.SetKey("x", nil) })
+	mustPanic(t, "Append of dict", func() { root.Append(nil) })
+}
+
+func mustPanic(t *testing.T, what string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: did not panic", what)
+		}
+	}()
+	f()
+}
+
+func TestValueLookup(t *testing.T) {
+	doc := bplist.NewDict(
+		bplist.Entry{Key: "Items", Value: bplist.NewArray(
+			bplist.NewDict(bplist.Entry{Key: "Name", Value: bplist.NewValue(bplist.TString, "zero")}),
+			bplist.NewDict(bplist.Entry{Key: "Name", Value: bplist.NewValue(bplist.TString, "one")}),
+		)},
+		bplist.Entry{Key: "a.b", Value: bplist.NewValue(bplist.TString, "dotted")},
+		bplist.Entry{Key: "7", Value: bplist.NewValue(bplist.TString, "seven")},
+	)
+
+	for _, test := range []struct {
+		keypath, want string
+	}{
+		{"Items.1.Name", "one"},
+		{"Items[0].Name", "zero"},
+		{"Items.[1].Name", "one"},
+		{`a\.b`, "dotted"},
+		{"7", "seven"},
+	} {
+		v, err := doc.Get(test.keypath)
+		if err != nil {
+			t.Errorf("Get %q: unexpected error: %v", test.keypath, err)
+		} else if got := v.String(); got != test.want {
+			t.Errorf("Get %q: got %q, want %q", test.keypath, got, test.want)
+		}
+	}
+	if v, err := doc.Get(""); err != nil || v != doc {
+		t.Errorf(`Get "": got %v, %v; want root`, v, err)
+	}
+	for _, bad := range []string{"Items.2.Name", "Items[0].Nom", "a.b", "Items[x]", "Items[0", `x\`} {
+		if v, err := doc.Get(bad); err == nil {
+			t.Errorf("Get %q: got %v, wanted an error", bad, v)
+		}
+	}
+
+	if v, err := doc.Lookup("Items", 0, "Name"); err != nil || v.String() != "zero" {
+		t.Errorf("Lookup: got %v, %v; want zero", v, err)
+	}
+	_, err := doc.Lookup("Items", 5)
+	if !errors.Is(err, bplist.ErrNotFound) {
+		t.Errorf("Lookup missing: got %v, want %v", err, bplist.ErrNotFound)
+	} else if got, want := err.Error(), "value not found: index 5 at Items"; got != want {
+		t.Errorf("Lookup missing: got %q, want %q", got, want)
+	}
+}
+
+func TestValueWalk(t *testing.T) {
+	doc := bplist.NewDict(
+		bplist.Entry{Key: "a", Value: bplist.NewArray(
+			bplist.NewValue(bplist.TInteger, 1),
+			bplist.NewValue(bplist.TInteger, 2),
+		)},
+		bplist.Entry{Key: "skip", Value: bplist.NewArray(bplist.NewValue(bplist.TInteger, 3))},
+		bplist.Entry{Key: "b", Value: bplist.NewValue(bplist.TString, "x")},
+		bplist.Entry{Key: "stop", Value: bplist.NewValue(bplist.TBool, true)},
+		bplist.Entry{Key: "after", Value: bplist.NewValue(bplist.TBool, false)},
+	)
+	var got []string
+	err := doc.Walk(func(path []any, v *bplist.Value) error {
+		got = append(got, fmt.Sprintf("%v:%v", path, v.Kind()))
+		if len(path) == 1 && path[0] == "skip" {
+			return bplist.SkipChildren
+		} else if len(path) == 1 && path[0] == "stop" {
+			return bplist.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: unexpected error: %v", err)
+	}
+	want := []string{
+		"[]:dict", "[a]:array", "[a 0]:int", "[a 1]:int",
+		"[skip]:array", "[b]:string", "[stop]:bool",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk:\n got %q\nwant %q", got, want)
+	}
+
+	stop := errors.New("stop")
+	if err := doc.Walk(func([]any, *bplist.Value) error { return stop }); err != stop {
+		t.Errorf("Walk: got error %v, want %v", err, stop)
+	}
+}
+
+func TestValueEqual(t *testing.T) {
+	str := func(s string) *bplist.Value { return bplist.NewValue(bplist.TString, s) }
+	num := func(z any) *bplist.Value { return bplist.NewValue(bplist.TInteger, z) }
+	flt := func(f float64) *bplist.Value { return bplist.NewValue(bplist.TFloat, f) }
+	set := func(elts ...*bplist.Value) *bplist.Value {
+		v := bplist.NewCollection(bplist.Set)
+		v.Append(elts...)
+		return v
+	}
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		a, b *bplist.Value
+		want bool
+	}{
+		{nil, nil, true},
+		{str("a"), str("a"), true},
+		{str("a"), bplist.NewValue(bplist.TUnicode, []rune("a")), true},
+		{str("a"), str("b"), false},
+		{num(5), num(int64(5)), true},
+		{num(uint64(5)), num(5), true},
+		{num(5), flt(5), false},
+		{flt(math.NaN()), flt(math.NaN()), true},
+		{flt(0), flt(math.Copysign(0, -1)), true},
+		{bplist.NewValue(bplist.TTime, when), bplist.NewValue(bplist.TTime, when.In(time.Local)), true},
+		{bplist.NewValue(bplist.TBytes, []byte("x")), bplist.NewValue(bplist.TBytes, []byte("x")), true},
+		{bplist.NewArray(num(1), num(2)), bplist.NewArray(num(1), num(2)), true},
+		{bplist.NewArray(num(1), num(2)), bplist.NewArray(num(2), num(1)), false},
+		{set(num(1), num(2), num(2)), set(num(2), num(1), num(2)), true},
+		{set(num(1), num(1), num(2)), set(num(2), num(1), num(2)), false},
+		{bplist.NewArray(), bplist.NewCollection(bplist.OrderedSet), false},
+		{
+			bplist.NewDict(bplist.Entry{Key: "a", Value: num(1)}, bplist.Entry{Key: "b", Value: str("x")}),
+			bplist.NewDict(bplist.Entry{Key: "b", Value: str("x")}, bplist.Entry{Key: "a", Value: num(1)}),
+			true,
+		},
+		{
+			bplist.NewDict(bplist.Entry{Key: "a", Value: num(1)}),
+			bplist.NewDict(bplist.Entry{Key: "a", Value: num(2)}),
+			false,
+		},
+	}
+	for i, test := range tests {
+		if got := test.a.Equal(test.b); got != test.want {
+			t.Errorf("Test %d: %v.Equal(%v): got %v, want %v", i, test.a, test.b, got, test.want)
+		}
+		if got := test.b.Equal(test.a); got != test.want {
+			t.Errorf("Test %d: %v.Equal(%v): got %v, want %v", i, test.b, test.a, got, test.want)
+		}
+	}
+}
+
+func TestValueClone(t *testing.T) {
+	data := []byte("abc")
+	orig := bplist.NewDict(
+		bplist.Entry{Key: "list", Value: bplist.NewArray(bplist.NewValue(bplist.TInteger, 1))},
+		bplist.Entry{Key: "data", Value: bplist.NewValue(bplist.TBytes, data)},
+	)
+	c := orig.Clone()
+	if !c.Equal(orig) {
+		t.Fatalf("Clone: got %v, want %v", c.Interface(), orig.Interface())
+	}
+
+	c.Key("list").Append(bplist.NewValue(bplist.TInteger, 2))
+	c.SetKey("new", bplist.NewValue(bplist.TBool, true))
+	c.Key("data").Bytes()[0] = 'X'
+	if got := orig.Key("list").Len(); got != 1 {
+		t.Errorf("Original list length: got %d, want 1", got)
+	}
+	if got := orig.Key("new"); got != nil {
+		t.Errorf("Original new key: got %v, want nil", got)
+	}
+	if got := string(data); got != "abc" {
+		t.Errorf("Original data: got %q, want abc", got)
+	}
+	if c.Equal(orig) {
+		t.Error("Modified clone is still equal to the original")
+	}
+	if got := (*bplist.Value)(nil).Clone(); got != nil {
+		t.Errorf("Clone of nil: got %v, want nil", got)
+	}
+}
+
+func TestValueCanonicalize(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	set := func(elts ...*bplist.Value) *bplist.Value {
+		v := bplist.NewCollection(bplist.Set)
+		v.Append(elts...)
+		return v
+	}
+	sub := func() *bplist.Value {
+		return bplist.NewDict(bplist.Entry{Key: "k", Value: bplist.NewValue(bplist.TString, "v")})
+	}
+	a := bplist.NewDict(
+		bplist.Entry{Key: "b", Value: set(bplist.NewValue(bplist.TInteger, 2), bplist.NewValue(bplist.TString, "x"))},
+		bplist.Entry{Key: "a", Value: bplist.NewValue(bplist.TInteger, uint64(7))},
+		bplist.Entry{Key: "t", Value: bplist.NewValue(bplist.TTime, when.In(time.FixedZone("X", 3600)))},
+		bplist.Entry{Key: "s1", Value: sub()},
+		bplist.Entry{Key: "s2", Value: sub()},
+	)
+	b := bplist.NewDict(
+		bplist.Entry{Key: "s2", Value: sub()},
+		bplist.Entry{Key: "t", Value: bplist.NewValue(bplist.TTime, when)},
+		bplist.Entry{Key: "a", Value: bplist.NewValue(bplist.TInteger, 7)},
+		bplist.Entry{Key: "s1", Value: sub()},
+		bplist.Entry{Key: "b", Value: set(bplist.NewValue(bplist.TString, "x"), bplist.NewValue(bplist.TInteger, 2))},
+	)
+	if !a.Equal(b) {
+		t.Fatal("Test inputs are not equal")
+	}
+
+	ca, cb := a.Canonicalize(), b.Canonicalize()
+	if !ca.Equal(a) {
+		t.Errorf("Canonicalize: got %v, want %v", ca.Interface(), a.Interface())
+	}
+	var abuf, bbuf bytes.Buffer
+	if _, err := ca.WriteTo(&abuf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if _, err := cb.WriteTo(&bbuf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(abuf.Bytes(), bbuf.Bytes()) {
+		t.Errorf("Canonical encodings differ:\n%s\n%s",
+			parseString(t, abuf.Bytes()), parseString(t, bbuf.Bytes()))
+	}
+
+	var keys []string
+	for _, e := range ca.Dict() {
+		keys = append(keys, e.Key)
+	}
+	if want := []string{"a", "b", "s1", "s2", "t"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Canonical keys: got %q, want %q", keys, want)
+	}
+	if ca.Key("s1") != ca.Key("s2") {
+		t.Error("Identical subtrees are not shared")
+	}
+	if a.Key("s1") == a.Key("s2") {
+		t.Error("Canonicalize modified its input")
+	}
+}
+
+func TestValueCanonicalizeZero(t *testing.T) {
+	negZero := math.Copysign(0, -1)
+	mk := func(a, b float64) *bplist.Value {
+		set := bplist.NewCollection(bplist.Set)
+		set.Append(bplist.NewValue(bplist.TFloat, a), bplist.NewValue(bplist.TFloat, b))
+		return bplist.NewArray(bplist.NewValue(bplist.TFloat, a), set)
+	}
+	a, b := mk(0, negZero), mk(negZero, 0)
+	if !a.Equal(b) {
+		t.Fatal("Test inputs are not equal")
+	}
+	var abuf, bbuf bytes.Buffer
+	if _, err := a.Canonicalize().WriteTo(&abuf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if _, err := b.Canonicalize().WriteTo(&bbuf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(abuf.Bytes(), bbuf.Bytes()) {
+		t.Errorf("Canonical encodings differ:\n%s\n%s",
+			parseString(t, abuf.Bytes()), parseString(t, bbuf.Bytes()))
+	}
+}
+
+// subtreeHandler delegates the subtree for the value of the root dict key
+// "want" to a ValueHandler, and ignores everything else.
+type subtreeHandler struct {
+	bplist.HandlerFuncs
+	vh    bplist.ValueHandler
+	depth int  // the number of open collections
+	match bool // the last key of the root dict was "want"
+	in    bool // events are being forwarded to vh
+}
+
+func (h *subtreeHandler) Key(typ bplist.Type, datum any) error {
+	if h.in {
+		return h.vh.Value(typ, datum)
+	}
+	h.match = h.depth == 1 && datum == "want"
+	return nil
+}
+
+func (h *subtreeHandler) Value(typ bplist.Type, datum any) error {
+	if h.in || h.match {
+		return h.vh.Value(typ, datum)
+	}
+	return nil
+}
+
+func (h *subtreeHandler) Open(coll bplist.Collection, n int) error {
+	h.depth++
+	if h.match && h.depth == 2 {
+		h.in, h.match = true, false
+	}
+	if h.in {
+		return h.vh.Open(coll, n)
+	}
+	return nil
+}
+
+func (h *subtreeHandler) Close(coll bplist.Collection) error {
+	h.depth--
+	if h.in {
+		h.in = h.depth > 1
+		return h.vh.Close(coll)
+	}
+	return nil
+}
+
+func TestValueHandler(t *testing.T) {
+	data, err := bplist.Marshal(map[string]any{
+		"skip": []any{"a", "b"},
+		"want": map[string]any{"x": []any{int64(1), "two"}},
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	t.Run("Whole", func(t *testing.T) {
+		var vh bplist.ValueHandler
+		if err := bplist.Parse(data, &vh); err != nil {
+			t.Fatalf("Parse: unexpected error: %v", err)
+		}
+		want, err := bplist.ParseValue(data)
+		if err != nil {
+			t.Fatalf("ParseValue: unexpected error: %v", err)
+		}
+		if got := vh.Root(); !got.Equal(want) {
+			t.Errorf("Root: got %v, want %v", got, want)
+		}
+
+		vh.Reset()
+		if got := vh.Root(); got != nil {
+			t.Errorf("Root after Reset: got %v, want nil", got)
+		}
+		vh.Open(bplist.Array, 1)
+		if got := vh.Root(); got != nil {
+			t.Errorf("Root while open: got %v, want nil", got)
+		}
+	})
+
+	t.Run("Subtree", func(t *testing.T) {
+		h := new(subtreeHandler)
+		if err := bplist.Parse(data, h); err != nil {
+			t.Fatalf("Parse: unexpected error: %v", err)
+		}
+		want := bplist.NewDict(bplist.Entry{
+			Key:   "x",
+			Value: bplist.NewArray(bplist.NewValue(bplist.TInteger, 1), bplist.NewValue(bplist.TString, "two")),
+		})
+		if got := h.vh.Root(); !got.Equal(want) {
+			t.Errorf("Root: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("ZeroCopy", func(t *testing.T) {
+		data, err := bplist.Marshal(map[string]any{"ascii": "text", "ключ": "значение"})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		var vh bplist.ValueHandler
+		if err := (bplist.ParseOptions{ZeroCopy: true}).Parse(data, &vh); err != nil {
+			t.Fatalf("Parse: unexpected error: %v", err)
+		}
+		want, err := bplist.ParseValue(data)
+		if err != nil {
+			t.Fatalf("ParseValue: unexpected error: %v", err)
+		}
+		got := vh.Root()
+		if !got.Equal(want) {
+			t.Errorf("Root: got %v, want %v", got.Interface(), want.Interface())
+		}
+		if s, ok := got.Key("ключ").Interface().(string); !ok || s != "значение" {
+			t.Errorf("Key: got %#v, want %q", got.Key("ключ").Interface(), "значение")
+		}
+	})
+}
+
+func TestValueRaw(t *testing.T) {
+	// [<unknown 0x93 object>, 5]
+	input := mkPlist([]byte{0xa2, 1, 2}, []byte{0x93, 1, 2, 3}, []byte{0x10, 0x05})
+	var vh bplist.ValueHandler
+	if err := (bplist.ParseOptions{Raw: true}).Parse(input, &vh); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	root := vh.Root()
+	raw := root.Index(0)
+	if got := raw.Kind(); got != bplist.KRaw {
+		t.Fatalf("Kind: got %v, want %v", got, bplist.KRaw)
+	}
+	if got, want := raw.Bytes(), []byte{0x93, 1, 2, 3}; !bytes.Equal(got, want) {
+		t.Errorf("Bytes: got %x, want %x", got, want)
+	}
+
+	// The tree can be compared, copied, and rebuilt.
+	if c := root.Clone(); !c.Equal(root) {
+		t.Error("Clone is not equal to the original")
+	}
+	if other := bplist.NewValue(bplist.TRaw, []byte{0x93, 1, 2, 4}); raw.Equal(other) {
+		t.Error("Different raw values are equal")
+	}
+	var buf bytes.Buffer
+	if _, err := root.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte{0x93, 1, 2, 3}) {
+		t.Errorf("Raw object not found in %q", buf.Bytes())
+	}
+
+	if _, err := raw.MarshalJSON(); err == nil {
+		t.Error("MarshalJSON of raw value: got nil, wanted an error")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wire provides low-level primitives for encoding the objects of a
+// binary property list. The bplist.Builder uses these to write its output;
+// they are exported so that advanced callers can emit objects the Builder
+// does not model, without reimplementing the encoding rules.
+//
+// Each object begins with a marker byte, whose high-order four bits give the
+// object type (the tag) and whose low-order four bits give either a size or a
+// type-specific value. The functions in this package append their encodings
+// to a caller-provided slice and return the extended slice, in the style of
+// the strconv.Append functions.
+package wire
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Marker bytes and tags for the object types. The constants ending in a zero
+// nibble are tags, to be combined with a size by AppendHeader.
+const (
+	Null       = 0x00 // the null singleton
+	False      = 0x08 // Boolean false
+	True       = 0x09 // Boolean true
+	Int        = 0x10 // integer; low nibble is log2 of the byte width
+	Real       = 0x20 // real; low nibble is log2 of the byte width
+	Date       = 0x33 // date, an 8-byte real
+	Data       = 0x40 // arbitrary bytes
+	ASCII      = 0x50 // ASCII string
+	UTF16      = 0x60 // UTF-16 string; the size counts code units
+	UTF8       = 0x70 // UTF-8 string
+	UID        = 0x80 // UID; low nibble is the byte width minus one
+	Array      = 0xa0 // array of object references
+	OrderedSet = 0xb0 // ordered set of object references
+	Set        = 0xc0 // set of object references
+	Dict       = 0xd0 // dictionary; the size counts key/value pairs
+)
+
+// Width reports the minimum number of bytes, from 1 to 8, needed to hold v as
+// an unsigned integer. This is the width to use for object references when v
+// is the largest object ID, and for offsets when v is the largest offset.
+func Width(v uint64) int {
+	nb := 1
+	for s := uint64(256); nb < 8 && s <= v; s *= 256 {
+		nb++
+	}
+	return nb
+}
+
+// AppendUint appends v to buf as a big-endian unsigned integer of exactly
+// width bytes, discarding any higher-order bits. It panics if width is not
+// between 1 and 8 inclusive.
+func AppendUint(buf []byte, width int, v uint64) []byte {
+	if width < 1 || width > 8 {
+		panic("wire: invalid integer width")
+	}
+	var zbuf [8]byte
+	binary.BigEndian.PutUint64(zbuf[:], v)
+	return append(buf, zbuf[8-width:]...)
+}
+
+// AppendRef appends a reference to the object with the given ID, as an
+// unsigned integer of width bytes. It is shorthand for AppendUint.
+func AppendRef(buf []byte, width, id int) []byte {
+	return AppendUint(buf, width, uint64(id))
+}
+
+// AppendInt appends an integer object for v to buf, using the smallest of the
+// 1, 2, 4, or 8 byte widths that holds v as an unsigned value. A negative
+// integer should be passed as uint64(v), and will use 8 bytes.
+func AppendInt(buf []byte, v uint64) []byte { return AppendIntWidth(buf, 1, v) }
+
+// AppendIntWidth appends an integer object for v to buf, as AppendInt does,
+// but using at least width bytes. This is for matching the output of writers
+// that use a fixed width. It panics if width is not 1, 2, 4, or 8.
+func AppendIntWidth(buf []byte, width int, v uint64) []byte {
+	switch width {
+	case 1, 2, 4, 8:
+	default:
+		panic("wire: invalid integer width")
+	}
+	nb, p2 := 1, 0
+	for nb < width || (nb < 8 && v >= 1<<(8*nb)) {
+		nb *= 2
+		p2++
+	}
+	buf = append(buf, Int|byte(p2))
+	return AppendUint(buf, nb, v)
+}
+
+// AppendUID appends a UID object for v to buf, using the fewest of 1, 2, 4,
+// or 8 bytes that hold v, but at least width bytes. It panics if width is not
+// 1, 2, 4, or 8.
+func AppendUID(buf []byte, width int, v uint64) []byte {
+	switch width {
+	case 1, 2, 4, 8:
+	default:
+		panic("wire: invalid UID width")
+	}
+	nb := 1
+	for nb < width || (nb < 8 && v >= 1<<(8*nb)) {
+		nb *= 2
+	}
+	buf = append(buf, UID|byte(nb-1))
+	return AppendUint(buf, nb, v)
+}
+
+// AppendInt128 appends a 16-byte integer object to buf, whose high-order and
+// low-order 64 bits are hi and lo. The format treats 16-byte integers as
+// unsigned, so this is used for values too large for the signed 8-byte form.
+func AppendInt128(buf []byte, hi, lo uint64) []byte {
+	buf = AppendUint(append(buf, Int|4), 8, hi)
+	return AppendUint(buf, 8, lo)
+}
+
+// AppendHeader appends the marker for an object with the given tag and size.
+// If n < 15 it is stored in the marker; otherwise the marker has size 15 and
+// is followed by an integer object giving the size.
+func AppendHeader(buf []byte, tag byte, n int) []byte {
+	if n < 15 {
+		return append(buf, tag|byte(n))
+	}
+	return AppendInt(append(buf, tag|0xf), uint64(n))
+}
+
+// AppendReal appends an 8-byte real object for f to buf.
+func AppendReal(buf []byte, f float64) []byte {
+	return AppendUint(append(buf, Real|3), 8, math.Float64bits(f))
+}
+
+// AppendReal32 appends a 4-byte real object for f to buf.
+func AppendReal32(buf []byte, f float32) []byte {
+	return AppendUint(append(buf, Real|2), 4, uint64(math.Float32bits(f)))
+}
+
+// AppendDate appends a date object to buf, where sec is the number of seconds
+// since the start of 1 January 2001 UTC.
+func AppendDate(buf []byte, sec float64) []byte {
+	return AppendUint(append(buf, Date), 8, math.Float64bits(sec))
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wire_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/creachadair/bplist/wire"
+)
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"Uint1", wire.AppendUint(nil, 1, 0x1234), []byte{0x34}},
+		{"Uint3", wire.AppendUint(nil, 3, 0x1234), []byte{0x00, 0x12, 0x34}},
+		{"Ref", wire.AppendRef([]byte{9}, 2, 5), []byte{9, 0, 5}},
+		{"Int0", wire.AppendInt(nil, 0), []byte{0x10, 0}},
+		{"Int255", wire.AppendInt(nil, 255), []byte{0x10, 0xff}},
+		{"Int256", wire.AppendInt(nil, 256), []byte{0x11, 1, 0}},
+		{"Int65536", wire.AppendInt(nil, 65536), []byte{0x12, 0, 1, 0, 0}},
+		{"IntNeg", wire.AppendInt(nil, math.MaxUint64), []byte{0x13, 255, 255, 255, 255, 255, 255, 255, 255}},
+		{"IntWidth4", wire.AppendIntWidth(nil, 4, 5), []byte{0x12, 0, 0, 0, 5}},
+		{"IntWidth2Large", wire.AppendIntWidth(nil, 2, 65536), []byte{0x12, 0, 1, 0, 0}},
+		{"UID1", wire.AppendUID(nil, 1, 5), []byte{0x80, 5}},
+		{"UID3", wire.AppendUID(nil, 1, 65536), []byte{0x83, 0, 1, 0, 0}},
+		{"UIDWidth2", wire.AppendUID(nil, 2, 5), []byte{0x81, 0, 5}},
+		{"UIDWidth8", wire.AppendUID(nil, 8, 5), []byte{0x87, 0, 0, 0, 0, 0, 0, 0, 5}},
+		{"Int128", wire.AppendInt128(nil, 1, 2), []byte{0x14, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2}},
+		{"HeaderSmall", wire.AppendHeader(nil, wire.Array, 3), []byte{0xa3}},
+		{"HeaderLarge", wire.AppendHeader(nil, wire.Data, 300), []byte{0x4f, 0x11, 0x01, 0x2c}},
+		{"Real", wire.AppendReal(nil, 0), []byte{0x23, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"Real32", wire.AppendReal32(nil, 1), []byte{0x22, 0x3f, 0x80, 0, 0}},
+		{"Date", wire.AppendDate(nil, 1), []byte{0x33, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tc := range tests {
+		if !bytes.Equal(tc.got, tc.want) {
+			t.Errorf("%s: got %x, want %x", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestWidth(t *testing.T) {
+	tests := []struct {
+		input uint64
+		want  int
+	}{
+		{0, 1}, {255, 1}, {256, 2}, {65535, 2}, {65536, 3},
+		{1<<32 - 1, 4}, {1 << 32, 5}, {math.MaxUint64, 8},
+	}
+	for _, tc := range tests {
+		if got := wire.Width(tc.input); got != tc.want {
+			t.Errorf("Width(%d): got %d, want %d", tc.input, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,897 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/creachadair/bplist/wire"
+)
+
+const (
+	xmlDecl    = `<?xml version="1.0" encoding="UTF-8"?>`
+	xmlDocType = `<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" ` +
+		`"http://www.apple.com/DTDs/PropertyList-1.0.dtd">`
+	xmlStart  = `<plist version="1.0">` + "\n"
+	xmlFooter = "</plist>\n"
+
+	gnustepDocType = `<!DOCTYPE plist PUBLIC "-//GNUstep//DTD plist 0.9//EN" ` +
+		`"http://www.gnustep.org/plist-0_9.dtd">`
+	gnustepStart = `<plist version="0.9">` + "\n"
+
+	// xmlDataWidth is the default length of the lines of base64 text in a
+	// data element, as written by CoreFoundation.
+	xmlDataWidth = 76
+)
+
+// An XMLEncoder is a Handler that writes the property list whose events are
+// delivered to it as an XML property list, by default in the format written
+// by Apple's tools. Parsing a binary property list with an XMLEncoder
+// converts it to XML. An XMLEncoder is also a KeyHandler.
+//
+// The XML format has no representation for null values or for objects of
+// unknown type, and reports an error for these. Sets and ordered sets are
+// written as arrays, and a UID is written as a dictionary with the single key
+// "CF$UID", following the convention of NSKeyedArchiver. Dates are truncated
+// to whole seconds, as Apple's tools write them.
+//
+// The document is complete once the root value has been written. An encoder
+// writes one document; after that, it reports an error for further values.
+type XMLEncoder struct {
+	w       io.Writer
+	opts    XMLOptions
+	buf     []byte     // output pending for the current event
+	stk     []xmlFrame // the open collections
+	started bool       // the header has been written
+	done    bool       // the root value is complete
+	err     error      // the first error writing to w
+}
+
+type xmlFrame struct {
+	coll  Collection
+	n     int  // the number of elements written, counting keys
+	empty bool // the collection was written as a single empty element
+}
+
+// XMLDialect enumerates the dialects of the XML property list format.
+type XMLDialect int
+
+// Constants defining the XML dialects.
+const (
+	// AppleXML is the dialect of Apple's tools, per PropertyList-1.0.dtd.
+	AppleXML XMLDialect = iota
+
+	// GNUstepXML is the dialect of the GNUstep tools, per plist-0_9.dtd. It
+	// differs from AppleXML in its document type declaration and version,
+	// and in that the text of a string or key may contain escapes: \UXXXX
+	// for the character with the hexadecimal code XXXX, and \\ for a
+	// backslash. The escapes allow control characters, which XML 1.0 does
+	// not permit, to be represented.
+	GNUstepXML
+)
+
+func (d XMLDialect) String() string {
+	switch d {
+	case AppleXML:
+		return "apple"
+	case GNUstepXML:
+		return "gnustep"
+	}
+	return "unknown"
+}
+
+// XMLOptions are settings for the layout of XML output. A zero value
+// provides defaults matching the output of Apple's tools. The options do not
+// affect the content of the property list.
+type XMLOptions struct {
+	// Indent is written once per level of nesting at the start of each line.
+	// If empty, a tab is used.
+	Indent string
+
+	// DataWidth is the length of the lines of base64 text in a data element.
+	// If zero, 76 is used. If negative, the text is written on one line.
+	DataWidth int
+
+	// If ExpandEmpty is true, elements without content, such as Booleans and
+	// empty collections, are written with separate start and end tags, as
+	// <true></true>, rather than as self-closing tags, as <true/>.
+	ExpandEmpty bool
+
+	// Declaration and DocType replace the XML declaration and the document
+	// type declaration written before the plist element. If empty, those
+	// written by Apple's tools are used. Each is written on its own line.
+	Declaration string
+	DocType     string
+
+	// If OmitDeclaration or OmitDocType is true, the corresponding
+	// declaration is not written. The DTD named by Apple's document type
+	// declaration is not needed to parse the output.
+	OmitDeclaration bool
+	OmitDocType     bool
+
+	// Dialect selects the dialect of the output. The default is AppleXML.
+	// With GNUstepXML, the default document type declaration is the one
+	// written by the GNUstep tools.
+	Dialect XMLDialect
+}
+
+// NewXMLEncoder returns an XMLEncoder that writes its output to w. It uses
+// default options; see XMLOptions.NewXMLEncoder for other settings.
+func NewXMLEncoder(w io.Writer) *XMLEncoder { return XMLOptions{}.NewXMLEncoder(w) }
+
+// NewXMLEncoder returns an XMLEncoder that writes its output to w, using the
+// options in o.
+func (o XMLOptions) NewXMLEncoder(w io.Writer) *XMLEncoder {
+	if o.Indent == "" {
+		o.Indent = "\t"
+	}
+	if o.DataWidth == 0 {
+		o.DataWidth = xmlDataWidth
+	}
+	if o.Declaration == "" {
+		o.Declaration = xmlDecl
+	}
+	if o.DocType == "" && o.Dialect == GNUstepXML {
+		o.DocType = gnustepDocType
+	} else if o.DocType == "" {
+		o.DocType = xmlDocType
+	}
+	return &XMLEncoder{w: w, opts: o}
+}
+
+// WriteXML encodes the property list as an XML property list, as described
+// for XMLEncoder, and writes it to w, using the XML options of b. Unlike
+// WriteTo, an error that occurs only because of the limitations of the XML
+// format does not cause b to fail.
+func (b *Builder) WriteXML(w io.Writer) (int64, error) {
+	if err := b.Err(); err != nil {
+		return 0, err
+	}
+	top, _, err := b.root()
+	if err != nil {
+		return 0, b.fail(err)
+	}
+	cw := &countWriter{w: w}
+	return cw.n, b.opts.XML.NewXMLEncoder(cw).entry(top)
+}
+
+// entry delivers the events for e and its contents to x.
+func (x *XMLEncoder) entry(e entry) error {
+	if e.coll == 0 {
+		return x.Value(e.elt, e.datum)
+	}
+	n := len(e.content)
+	if e.coll == Dict {
+		n /= 2
+	}
+	if err := x.Open(e.coll, n); err != nil {
+		return err
+	}
+	for _, c := range e.content {
+		if err := x.entry(c); err != nil {
+			return err
+		}
+	}
+	return x.Close(e.coll)
+}
+
+// Version implements part of the Handler interface. The version of the input
+// is not recorded in the XML format.
+func (x *XMLEncoder) Version(string) error { return x.err }
+
+// Key implements the KeyHandler interface.
+func (x *XMLEncoder) Key(typ Type, datum any) error { return x.Value(typ, datum) }
+
+// Value implements part of the Handler interface.
+func (x *XMLEncoder) Value(typ Type, datum any) error {
+	if err := x.begin(); err != nil {
+		return err
+	}
+	if x.isKey() {
+		s, ok := xmlString(typ, datum)
+		if !ok {
+			return fmt.Errorf("dictionary key of type %v cannot be written as XML", typ)
+		}
+		x.element("key", s)
+		x.stk[len(x.stk)-1].n++
+		return x.flush()
+	}
+	if err := x.value(typ, datum); err != nil {
+		return err
+	}
+	x.advance()
+	return x.flush()
+}
+
+// Open implements part of the Handler interface.
+func (x *XMLEncoder) Open(coll Collection, n int) error {
+	if err := x.begin(); err != nil {
+		return err
+	} else if x.isKey() {
+		return fmt.Errorf("dictionary key of type %v cannot be written as XML", coll)
+	}
+	tag := "array"
+	if coll == Dict {
+		tag = "dict"
+	}
+	if n == 0 {
+		x.empty(tag)
+	} else {
+		x.indent()
+		x.buf = append(x.buf, "<"+tag+">\n"...)
+	}
+	x.stk = append(x.stk, xmlFrame{coll: coll, empty: n == 0})
+	return x.flush()
+}
+
+// Close implements part of the Handler interface.
+func (x *XMLEncoder) Close(coll Collection) error {
+	if x.err != nil {
+		return x.err
+	} else if len(x.stk) == 0 {
+		return fmt.Errorf("close of unopened %v", coll)
+	}
+	top := x.stk[len(x.stk)-1]
+	x.stk = x.stk[:len(x.stk)-1]
+	if top.empty && top.n != 0 {
+		return fmt.Errorf("%v declared empty has elements", coll)
+	} else if !top.empty {
+		tag := "array"
+		if top.coll == Dict {
+			tag = "dict"
+		}
+		x.indent()
+		x.buf = append(x.buf, "</"+tag+">\n"...)
+	}
+	x.advance()
+	return x.flush()
+}
+
+// begin writes the document header if it has not been written, and reports
+// an error if the document is already complete.
+func (x *XMLEncoder) begin() error {
+	if x.err != nil {
+		return x.err
+	} else if x.done {
+		return errors.New("XML property list is already complete")
+	} else if !x.started {
+		if !x.opts.OmitDeclaration {
+			x.buf = append(x.buf, x.opts.Declaration+"\n"...)
+		}
+		if !x.opts.OmitDocType {
+			x.buf = append(x.buf, x.opts.DocType+"\n"...)
+		}
+		if x.opts.Dialect == GNUstepXML {
+			x.buf = append(x.buf, gnustepStart...)
+		} else {
+			x.buf = append(x.buf, xmlStart...)
+		}
+		x.started = true
+	}
+	return nil
+}
+
+// isKey reports whether the next element is a dictionary key.
+func (x *XMLEncoder) isKey() bool {
+	n := len(x.stk)
+	return n != 0 && x.stk[n-1].coll == Dict && x.stk[n-1].n%2 == 0
+}
+
+// advance records that an element is complete, and completes the document
+// if the element was the root.
+func (x *XMLEncoder) advance() {
+	if n := len(x.stk); n != 0 {
+		x.stk[n-1].n++
+		return
+	}
+	x.buf = append(x.buf, xmlFooter...)
+	x.done = true
+}
+
+// flush writes the pending output.
+func (x *XMLEncoder) flush() error {
+	if x.err == nil && len(x.buf) != 0 {
+		_, x.err = x.w.Write(x.buf)
+	}
+	x.buf = x.buf[:0]
+	return x.err
+}
+
+// indent adds the indentation for the current depth to the pending output.
+func (x *XMLEncoder) indent() {
+	for range x.stk {
+		x.buf = append(x.buf, x.opts.Indent...)
+	}
+}
+
+// empty adds an element with the given tag and no content to the pending
+// output.
+func (x *XMLEncoder) empty(tag string) {
+	x.indent()
+	if x.opts.ExpandEmpty {
+		x.buf = append(x.buf, "<"+tag+"></"+tag+">\n"...)
+	} else {
+		x.buf = append(x.buf, "<"+tag+"/>\n"...)
+	}
+}
+
+// element adds an element with the given tag and text to the pending output.
+func (x *XMLEncoder) element(tag, text string) {
+	x.indent()
+	x.buf = append(x.buf, "<"+tag+">"...)
+	if x.opts.Dialect == GNUstepXML {
+		text = gnustepEscape(text)
+	}
+	x.buf = appendXMLText(x.buf, text)
+	x.buf = append(x.buf, "</"+tag+">\n"...)
+}
+
+// value adds the element for a primitive value to the pending output.
+func (x *XMLEncoder) value(typ Type, datum any) error {
+	switch typ {
+	case TBool:
+		if v, ok := datum.(bool); ok {
+			x.empty(strconv.FormatBool(v))
+			return nil
+		}
+	case TInteger:
+		switch datum.(type) {
+		case int64, uint64, *big.Int:
+			x.element("integer", fmt.Sprint(datum))
+			return nil
+		}
+	case TFloat:
+		switch v := datum.(type) {
+		case float64:
+			x.element("real", formatXMLReal(v, 64))
+			return nil
+		case float32:
+			x.element("real", formatXMLReal(float64(v), 32))
+			return nil
+		}
+	case TTime:
+		if v, ok := datum.(time.Time); ok {
+			x.element("date", v.UTC().Format(xmlDateFormat))
+			return nil
+		}
+	case TBytes:
+		if data, ok := xmlBytes(datum); ok {
+			x.data(data)
+			return nil
+		}
+	case TString, TUnicode:
+		if s, ok := xmlString(typ, datum); ok {
+			x.element("string", s)
+			return nil
+		}
+	case TUID:
+		if id, ok := xmlBytes(datum); ok {
+			x.indent()
+			x.buf = append(x.buf, "<dict>\n"...)
+			x.stk = append(x.stk, xmlFrame{coll: Dict})
+			x.element("key", uidKey)
+			x.element("integer", new(big.Int).SetBytes(id).String())
+			x.stk = x.stk[:len(x.stk)-1]
+			x.indent()
+			x.buf = append(x.buf, "</dict>\n"...)
+			return nil
+		}
+	case TNull:
+		return errors.New("null cannot be written as XML")
+	default:
+		return fmt.Errorf("%v cannot be written as XML", typ)
+	}
+	return fmt.Errorf("invalid datum %T for %v", datum, typ)
+}
+
+// data adds a data element for the given bytes to the pending output.
+func (x *XMLEncoder) data(data []byte) {
+	x.indent()
+	x.buf = append(x.buf, "<data>\n"...)
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) != 0 {
+		n := len(enc)
+		if x.opts.DataWidth > 0 {
+			n = min(n, x.opts.DataWidth)
+		}
+		x.indent()
+		x.buf = append(x.buf, enc[:n]...)
+		x.buf = append(x.buf, '\n')
+		enc = enc[n:]
+	}
+	x.indent()
+	x.buf = append(x.buf, "</data>\n"...)
+}
+
+// xmlString returns the text of a string datum of the given type, as it may
+// be delivered by the parser or stored by a Builder.
+func xmlString(typ Type, datum any) (string, bool) {
+	switch t := datum.(type) {
+	case string:
+		return t, typ == TString || typ == TUnicode
+	case []rune:
+		return string(t), typ == TUnicode
+	case []byte:
+		switch typ {
+		case TString: // ZeroCopy ASCII or UTF-8
+			return string(t), true
+		case TUnicode: // ZeroCopy UTF-16
+			u16 := make([]uint16, len(t)/2)
+			for i := range u16 {
+				u16[i] = uint16(t[2*i])<<8 | uint16(t[2*i+1])
+			}
+			return string(utf16.Decode(u16)), true
+		}
+	}
+	return "", false
+}
+
+// xmlBytes returns the contents of a data or UID datum, as it may be
+// delivered by the parser or stored by a Builder.
+func xmlBytes(datum any) ([]byte, bool) {
+	switch t := datum.(type) {
+	case []byte:
+		return t, true
+	case string:
+		return []byte(t), true
+	}
+	return nil, false
+}
+
+// formatXMLReal formats f for a real element, using the spellings of
+// CoreFoundation for infinite and NaN values.
+func formatXMLReal(f float64, bits int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+infinity"
+	case math.IsInf(f, -1):
+		return "-infinity"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+// xmlEscaper escapes the characters that may not appear literally in the
+// text of an element.
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func appendXMLText(buf []byte, s string) []byte {
+	return append(buf, xmlEscaper.Replace(s)...)
+}
+
+// gnustepEscape returns s with backslashes and the control characters that
+// XML does not permit replaced by the escapes of the GNUstep dialect.
+func gnustepEscape(s string) string {
+	if !strings.ContainsFunc(s, needsGNUstepEscape) {
+		return s
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		if r == '\\' {
+			sb.WriteString(`\\`)
+		} else if needsGNUstepEscape(r) {
+			fmt.Fprintf(&sb, `\U%04x`, r)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func needsGNUstepEscape(r rune) bool {
+	return r == '\\' || (r < ' ' && r != '\t' && r != '\n' && r != '\r')
+}
+
+// gnustepUnescape returns s with the escapes of the GNUstep dialect replaced
+// by the characters they represent. A backslash that does not begin a valid
+// escape is kept literally.
+func gnustepUnescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for len(s) != 0 {
+		i := strings.IndexByte(s, '\\')
+		if i < 0 {
+			sb.WriteString(s)
+			break
+		}
+		sb.WriteString(s[:i])
+		s = s[i:]
+		if strings.HasPrefix(s, `\\`) {
+			sb.WriteByte('\\')
+			s = s[2:]
+		} else if r, ok := gnustepRune(s); ok {
+			sb.WriteRune(rune(r))
+			s = s[6:]
+		} else {
+			sb.WriteByte('\\')
+			s = s[1:]
+		}
+	}
+	return sb.String()
+}
+
+// gnustepRune decodes the \UXXXX escape at the start of s, if there is one.
+func gnustepRune(s string) (rune, bool) {
+	if len(s) < 6 || s[1] != 'U' {
+		return 0, false
+	}
+	r, err := strconv.ParseUint(s[2:6], 16, 16)
+	return rune(r), err == nil
+}
+
+// ParseXML parses an XML property list from data, and delivers its contents
+// to the methods of h in the same order as Parse does for a binary property
+// list, so that a handler need not depend on the format of its input. Since
+// XML property lists do not record a binary format version, h.Version is
+// called with "00". It uses default options; see ParseOptions.ParseXML for
+// other settings.
+//
+// A dict with the single key "CF$UID" whose value is an integer is delivered
+// as a TUID, following the convention of NSKeyedArchiver, and a dict key is
+// delivered to a KeyHandler by its Key method. Integers and reals are
+// delivered with the same datum types as Parse uses. As for Parse, an error
+// from h is wrapped in a *HandlerError, whose Offset is -1, unless it is
+// ErrStop.
+func ParseXML(data []byte, h Handler) error { return ParseOptions{}.ParseXML(data, h) }
+
+// ParseXML parses an XML property list from data, using the options in o, as
+// described for ParseXML. Of the options, only Transform, MaxDepth, Strict,
+// LenientXML, and XMLDialect apply to XML input.
+func (o ParseOptions) ParseXML(data []byte, h Handler) error {
+	root, err := o.readXML(data)
+	if err != nil {
+		return err
+	}
+	if err := h.Version("00"); errors.Is(err, ErrStop) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	p := &parser{opts: o, h: h}
+	if err := p.emitXML(root, nil); err != nil && !errors.Is(err, ErrStop) {
+		return err
+	}
+	return nil
+}
+
+// An xmlNode is an element of an XML property list.
+type xmlNode struct {
+	name string
+	text string     // for a primitive value
+	elts []*xmlNode // for an array or dict
+	line int        // the line where the element begins
+}
+
+func (n *xmlNode) errorf(msg string, args ...any) error {
+	return fmt.Errorf("line %d: <%s>: %s", n.line, n.name, fmt.Sprintf(msg, args...))
+}
+
+// readXML reads the root value of the XML property list in data.
+func (o ParseOptions) readXML(data []byte) (*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root *xmlNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			line, _ := dec.InputPos()
+			if t.Name.Local != "plist" {
+				return nil, fmt.Errorf("line %d: unexpected <%s>, want <plist>", line, t.Name.Local)
+			} else if root != nil {
+				return nil, fmt.Errorf("line %d: more than one <plist>", line)
+			}
+			elts, err := o.readXMLChildren(dec, 1)
+			if err != nil {
+				return nil, err
+			} else if len(elts) != 1 {
+				return nil, fmt.Errorf("line %d: <plist> has %d values, want 1", line, len(elts))
+			}
+			root = elts[0]
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) != 0 {
+				line, _ := dec.InputPos()
+				return nil, fmt.Errorf("line %d: unexpected text outside <plist>", line)
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("no <plist> element found")
+	}
+	return root, nil
+}
+
+// readXMLChildren reads the elements of a collection from dec, up to the end
+// of the collection, which is at the given nesting depth.
+func (o ParseOptions) readXMLChildren(dec *xml.Decoder, depth int) ([]*xmlNode, error) {
+	limit := o.MaxDepth
+	if limit <= 0 {
+		limit = maxDepth
+	}
+	var elts []*xmlNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			line, _ := dec.InputPos()
+			n := &xmlNode{name: t.Name.Local, line: line}
+			switch n.name {
+			case "array", "dict":
+				if depth > limit {
+					return nil, n.errorf("collections nested more than %d deep", limit)
+				}
+				n.elts, err = o.readXMLChildren(dec, depth+1)
+			default:
+				n.text, err = readXMLText(dec, n)
+			}
+			if err != nil {
+				return nil, err
+			}
+			elts = append(elts, n)
+		case xml.EndElement:
+			return elts, nil
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) != 0 {
+				line, _ := dec.InputPos()
+				return nil, fmt.Errorf("line %d: unexpected text %q", line, bytes.TrimSpace(t))
+			}
+		}
+	}
+}
+
+// readXMLText reads the text content of the primitive element n from dec, up
+// to the end of the element.
+func readXMLText(dec *xml.Decoder, n *xmlNode) (string, error) {
+	var sb strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		} else if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return "", n.errorf("unexpected element <%s>", t.Name.Local)
+		case xml.EndElement:
+			return sb.String(), nil
+		case xml.CharData:
+			sb.Write(t)
+		}
+	}
+}
+
+// emitXML delivers the value of n and its contents to the handler. The path
+// of n is path, as for PathTracker.Path.
+func (p *parser) emitXML(n *xmlNode, path []any) error {
+	switch n.name {
+	case "array":
+		if err := p.h.Open(Array, len(n.elts)); err != nil {
+			return xmlHandlerErr(path, err)
+		}
+		for i, elt := range n.elts {
+			if err := p.emitXML(elt, append(path, i)); err != nil {
+				return err
+			}
+		}
+		return xmlHandlerErr(path, p.h.Close(Array))
+
+	case "dict":
+		if id, ok := n.uid(); ok {
+			return xmlHandlerErr(path, p.value(TUID, id))
+		} else if len(n.elts)%2 != 0 {
+			return n.errorf("key without a value")
+		}
+		if err := p.h.Open(Dict, len(n.elts)/2); err != nil {
+			return xmlHandlerErr(path, err)
+		}
+		for i := 0; i < len(n.elts); i += 2 {
+			key, val := n.elts[i], n.elts[i+1]
+			if key.name != "key" {
+				return key.errorf("dict key is not a <key>")
+			}
+			text := p.xmlText(key.text)
+			p.isKey = true
+			err := p.value(TString, text)
+			p.isKey = false
+			if err != nil {
+				return xmlHandlerErr(path, err)
+			}
+			if err := p.emitXML(val, append(path, text)); err != nil {
+				return err
+			}
+		}
+		return xmlHandlerErr(path, p.h.Close(Dict))
+
+	case "string":
+		return xmlHandlerErr(path, p.value(TString, p.xmlText(n.text)))
+
+	case "integer":
+		z, err := parseXMLInt(strings.TrimSpace(n.text), p.opts.LenientXML && !p.opts.Strict)
+		if err != nil {
+			return n.errorf("%v", err)
+		}
+		return xmlHandlerErr(path, p.value(TInteger, z))
+
+	case "real":
+		f, err := parseXMLReal(strings.TrimSpace(n.text))
+		if err != nil {
+			return n.errorf("%v", err)
+		}
+		return xmlHandlerErr(path, p.value(TFloat, f))
+
+	case "true", "false":
+		switch {
+		case p.opts.Strict && n.text != "":
+			return n.errorf("unexpected text")
+		case p.opts.LenientXML && !p.opts.Strict:
+			// Ignore any content.
+		case strings.TrimSpace(n.text) != "":
+			return n.errorf("unexpected text")
+		}
+		return xmlHandlerErr(path, p.value(TBool, n.name == "true"))
+
+	case "date":
+		t, err := p.opts.parseXMLDate(strings.TrimSpace(n.text))
+		if err != nil {
+			return n.errorf("invalid date: %v", err)
+		}
+		return xmlHandlerErr(path, p.value(TTime, t.UTC()))
+
+	case "data":
+		enc, text := base64.StdEncoding, strings.Map(dropSpace, n.text)
+		if p.opts.LenientXML && !p.opts.Strict {
+			enc, text = base64.RawStdEncoding, strings.TrimRight(text, "=")
+		}
+		data, err := enc.DecodeString(text)
+		if err != nil {
+			return n.errorf("invalid data: %v", err)
+		}
+		return xmlHandlerErr(path, p.value(TBytes, data))
+
+	case "key":
+		return n.errorf("key outside a dict")
+	}
+	return n.errorf("unknown element")
+}
+
+// xmlHandlerErr returns err annotated with path, as a *HandlerError, if it is
+// not nil. XML input has no byte offsets, so the offset is reported as -1.
+func xmlHandlerErr(path []any, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &HandlerError{Path: slices.Clone(path), Offset: -1, Err: err}
+}
+
+// xmlText returns the value of the text of a string or key element.
+func (p *parser) xmlText(s string) string {
+	if p.opts.XMLDialect == GNUstepXML {
+		return gnustepUnescape(s)
+	}
+	return s
+}
+
+// uid reports whether n is a dict representing a UID, and if so returns the
+// UID in minimal big-endian form.
+func (n *xmlNode) uid() ([]byte, bool) {
+	if len(n.elts) != 2 || n.elts[0].name != "key" || n.elts[0].text != uidKey || n.elts[1].name != "integer" {
+		return nil, false
+	}
+	z, err := strconv.ParseUint(strings.TrimSpace(n.elts[1].text), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return wire.AppendUint(nil, wire.Width(z), z), true
+}
+
+// xmlDateFormat is the format of a date element written by Apple's tools.
+const xmlDateFormat = "2006-01-02T15:04:05Z"
+
+// parseXMLDate parses the text of a date element, as permitted by o.
+func (o ParseOptions) parseXMLDate(s string) (time.Time, error) {
+	if o.Strict {
+		return time.Parse(xmlDateFormat, s)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil && o.LenientXML {
+		// A date without a zone is taken as UTC.
+		for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02"} {
+			if t, lerr := time.Parse(layout, s); lerr == nil {
+				return t, nil
+			}
+		}
+	}
+	return t, err
+}
+
+// parseXMLInt parses the text of an integer element, and returns it as an
+// int64, or as a uint64 or *big.Int if it is out of range for int64. If hex
+// is true, an integer with a "0x" prefix after its sign is hexadecimal.
+func parseXMLInt(s string, hex bool) (any, error) {
+	base, digits := 10, s
+	if hex {
+		sign := ""
+		if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+			sign = s[:1]
+		}
+		if rest, ok := strings.CutPrefix(strings.ToLower(s[len(sign):]), "0x"); ok {
+			base, digits = 16, sign+rest
+		}
+	}
+	if z, err := strconv.ParseInt(digits, base, 64); err == nil {
+		return z, nil
+	} else if u, err := strconv.ParseUint(digits, base, 64); err == nil {
+		return u, nil
+	}
+	z, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %q", s)
+	} else if z.Sign() < 0 || z.BitLen() > 128 {
+		return nil, fmt.Errorf("%w: %v", ErrOverflow, z)
+	}
+	return z, nil
+}
+
+// parseXMLReal parses the text of a real element, accepting the spellings of
+// CoreFoundation for infinite and NaN values.
+func parseXMLReal(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "+infinity", "infinity", "+inf", "inf":
+		return math.Inf(1), nil
+	case "-infinity", "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid real %q", s)
+	}
+	return f, nil
+}
+
+// dropSpace is a strings.Map function that removes white space.
+func dropSpace(r rune) rune {
+	if unicode.IsSpace(r) {
+		return -1
+	}
+	return r
+}
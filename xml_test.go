@@ -0,0 +1,398 @@
+// Copyright 2020 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bplist_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creachadair/bplist"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+func TestWriteXML(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Open(bplist.Dict, func(b *bplist.Builder) {
+		b.Pair("name", bplist.TString, "a < b & c")
+		b.Pair("count", bplist.TInteger, -3)
+		b.Pair("ratio", bplist.TFloat, 1.5)
+		b.Pair("ok", bplist.TBool, true)
+		b.Pair("when", bplist.TTime, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+		b.Pair("blob", bplist.TBytes, []byte("hello"))
+		b.Pair("uid", bplist.TUID, []byte{1, 0})
+		b.Key("list")
+		b.Open(bplist.Array, func(b *bplist.Builder) {
+			b.Value(bplist.TString, "x")
+			b.Open(bplist.Set, func(*bplist.Builder) {})
+		})
+	})
+	var buf strings.Builder
+	if _, err := b.WriteXML(&buf); err != nil {
+		t.Fatalf("WriteXML failed: %v", err)
+	}
+	const want = xmlHeader + `<dict>
+	<key>name</key>
+	<string>a &lt; b &amp; c</string>
+	<key>count</key>
+	<integer>-3</integer>
+	<key>ratio</key>
+	<real>1.5</real>
+	<key>ok</key>
+	<true/>
+	<key>when</key>
+	<date>2020-01-02T03:04:05Z</date>
+	<key>blob</key>
+	<data>
+	aGVsbG8=
+	</data>
+	<key>uid</key>
+	<dict>
+		<key>CF$UID</key>
+		<integer>256</integer>
+	</dict>
+	<key>list</key>
+	<array>
+		<string>x</string>
+		<array/>
+	</array>
+</dict>
+</plist>
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteXML:\n got %s\nwant %s", got, want)
+	}
+
+	// The same output results from converting the binary encoding.
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	var conv bytes.Buffer
+	if err := bplist.Parse(data, bplist.NewXMLEncoder(&conv)); err != nil {
+		t.Fatalf("Parse to XML failed: %v", err)
+	}
+	if got := conv.String(); got != want {
+		t.Errorf("Parse to XML:\n got %s\nwant %s", got, want)
+	}
+
+	// Null values cannot be written.
+	b = bplist.NewBuilder()
+	b.Value(bplist.TNull, nil)
+	if _, err := b.WriteXML(&buf); err == nil {
+		t.Error("WriteXML null: got nil, wanted an error")
+	} else if b.Err() != nil {
+		t.Errorf("Builder failed after WriteXML: %v", b.Err())
+	}
+}
+
+func TestParseXML(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.Open(bplist.Dict, func(b *bplist.Builder) {
+		b.Pair("name", bplist.TString, "a < b & c")
+		b.Pair("count", bplist.TInteger, -3)
+		b.Pair("big", bplist.TInteger, uint64(1)<<63)
+		b.Pair("ratio", bplist.TFloat, 1.5)
+		b.Pair("ok", bplist.TBool, true)
+		b.Pair("when", bplist.TTime, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+		b.Pair("blob", bplist.TBytes, bytes.Repeat([]byte("hello"), 20))
+		b.Pair("uid", bplist.TUID, []byte{1, 0})
+		b.Key("list")
+		b.Open(bplist.Array, func(b *bplist.Builder) {
+			b.Value(bplist.TString, "x")
+			b.Open(bplist.Array, func(*bplist.Builder) {})
+		})
+	})
+	want, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	var xbuf bytes.Buffer
+	if _, err := b.WriteXML(&xbuf); err != nil {
+		t.Fatalf("WriteXML failed: %v", err)
+	}
+
+	// Parsing the XML output reproduces the original binary encoding.
+	rb := bplist.NewBuilder()
+	if err := bplist.ParseXML(xbuf.Bytes(), bplist.BuilderHandler(rb)); err != nil {
+		t.Fatalf("ParseXML failed: %v", err)
+	}
+	if got, err := rb.Bytes(); err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	} else if !bytes.Equal(got, want) {
+		t.Errorf("Round trip:\n got %q\nwant %q", got, want)
+	}
+
+	t.Run("Events", func(t *testing.T) {
+		var buf strings.Builder
+		const input = xmlHeader + `<array><string> a </string><integer> 5 </integer>
+<real>-infinity</real><false/><dict/></array></plist>`
+		if err := bplist.ParseXML([]byte(input), testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Fatalf("ParseXML failed: %v", err)
+		}
+		const want = `V"00"<array size=5>(string= a )(int=5)(float=-Inf)(bool=false)<dict size=0></dict></array>`
+		if got := buf.String(); got != want {
+			t.Errorf("ParseXML:\n got %s\nwant %s", got, want)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, input := range []string{
+			``,
+			`<array/>`,
+			`<plist></plist>`,
+			`<plist><string/><string/></plist>`,
+			`<plist><integer>x</integer></plist>`,
+			`<plist><integer>-1</integer>`,
+			`<plist><real>1.2.3</real></plist>`,
+			`<plist><date>yesterday</date></plist>`,
+			`<plist><data>!!</data></plist>`,
+			`<plist><dict><key>a</key></dict></plist>`,
+			`<plist><dict><string>a</string><true/></dict></plist>`,
+			`<plist><key>a</key></plist>`,
+			`<plist><string>a<b/></string></plist>`,
+			`<plist><widget/></plist>`,
+			`<plist><array>text</array></plist>`,
+		} {
+			if err := bplist.ParseXML([]byte(input), nopHandler{}); err == nil {
+				t.Errorf("ParseXML(%q): got nil, wanted an error", input)
+			}
+		}
+	})
+}
+
+func TestParseXMLHandlerError(t *testing.T) {
+	input := xmlHeader + `<dict><key>Items</key><array><dict><key>Name</key><string>x</string></dict><integer>5</integer></array></dict></plist>`
+	errBad := errors.New("bad")
+	tests := []struct {
+		name string
+		h    bplist.HandlerFuncs
+		want string
+	}{
+		{"Value", bplist.HandlerFuncs{ValueFunc: func(_ bplist.Type, datum any) error {
+			if datum == "x" {
+				return errBad
+			}
+			return nil
+		}}, "at Items[0].Name: bad"},
+		{"Key", bplist.HandlerFuncs{ValueFunc: func(_ bplist.Type, datum any) error {
+			if datum == "Name" {
+				return errBad
+			}
+			return nil
+		}}, "at Items[0]: bad"},
+		{"Open", bplist.HandlerFuncs{OpenFunc: func(coll bplist.Collection, _ int) error {
+			if coll == bplist.Array {
+				return errBad
+			}
+			return nil
+		}}, "at Items: bad"},
+		{"Close", bplist.HandlerFuncs{CloseFunc: func(coll bplist.Collection) error {
+			if coll == bplist.Array {
+				return errBad
+			}
+			return nil
+		}}, "at Items: bad"},
+		{"Root", bplist.HandlerFuncs{OpenFunc: func(bplist.Collection, int) error {
+			return errBad
+		}}, "at root: bad"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bplist.ParseXML([]byte(input), test.h)
+			var he *bplist.HandlerError
+			if !errors.As(err, &he) || !errors.Is(err, errBad) {
+				t.Fatalf("ParseXML: got %v, wanted a *HandlerError wrapping %v", err, errBad)
+			}
+			if he.Offset != -1 {
+				t.Errorf("Offset: got %d, want -1", he.Offset)
+			}
+			if got := err.Error(); got != test.want {
+				t.Errorf("Error: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestXMLOptions(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.SetOptions(&bplist.BuilderOptions{XML: bplist.XMLOptions{
+		Indent:      "  ",
+		DataWidth:   8,
+		ExpandEmpty: true,
+	}})
+	b.Open(bplist.Array, func(b *bplist.Builder) {
+		b.Value(bplist.TBool, false)
+		b.Value(bplist.TBytes, []byte("hello, world"))
+		b.Open(bplist.Dict, func(*bplist.Builder) {})
+	})
+	var buf strings.Builder
+	if _, err := b.WriteXML(&buf); err != nil {
+		t.Fatalf("WriteXML failed: %v", err)
+	}
+	const want = xmlHeader + `<array>
+  <false></false>
+  <data>
+  aGVsbG8s
+  IHdvcmxk
+  </data>
+  <dict></dict>
+</array>
+</plist>
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteXML:\n got %s\nwant %s", got, want)
+	}
+
+	// A negative width puts the data on one line.
+	buf.Reset()
+	enc := bplist.XMLOptions{DataWidth: -1}.NewXMLEncoder(&buf)
+	if err := bplist.ParseXML([]byte(want), enc); err != nil {
+		t.Fatalf("ParseXML failed: %v", err)
+	}
+	const want2 = xmlHeader + `<array>
+	<false/>
+	<data>
+	aGVsbG8sIHdvcmxk
+	</data>
+	<dict/>
+</array>
+</plist>
+`
+	if got := buf.String(); got != want2 {
+		t.Errorf("XMLEncoder:\n got %s\nwant %s", got, want2)
+	}
+}
+
+func TestXMLHeader(t *testing.T) {
+	const body = `<plist version="1.0">
+<true/>
+</plist>
+`
+	tests := []struct {
+		opts bplist.XMLOptions
+		want string
+	}{
+		{bplist.XMLOptions{}, xmlHeader + "<true/>\n</plist>\n"},
+		{bplist.XMLOptions{OmitDeclaration: true, OmitDocType: true}, body},
+		{bplist.XMLOptions{OmitDocType: true},
+			`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + body},
+		{bplist.XMLOptions{
+			Declaration: `<?xml version="1.0"?>`,
+			DocType:     `<!DOCTYPE plist SYSTEM "plist.dtd">`,
+		}, `<?xml version="1.0"?>` + "\n" + `<!DOCTYPE plist SYSTEM "plist.dtd">` + "\n" + body},
+	}
+	for _, tc := range tests {
+		var buf strings.Builder
+		if err := tc.opts.NewXMLEncoder(&buf).Value(bplist.TBool, true); err != nil {
+			t.Fatalf("Value failed: %v", err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("Options %+v:\n got %s\nwant %s", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestParseXMLLenient(t *testing.T) {
+	tests := []struct {
+		input         string
+		want          string // default options, or "" for an error
+		strict, loose string // Strict and LenientXML, or "" for an error
+	}{
+		{"<true/>", "(bool=true)", "(bool=true)", "(bool=true)"},
+		{"<false> </false>", "(bool=false)", "", "(bool=false)"},
+		{"<true>yes</true>", "", "", "(bool=true)"},
+		{"<date>2020-01-02T03:04:05Z</date>", "(time=2020-01-02 03:04:05 +0000 UTC)",
+			"(time=2020-01-02 03:04:05 +0000 UTC)", "(time=2020-01-02 03:04:05 +0000 UTC)"},
+		{"<date>2020-01-02T03:04:05+01:00</date>", "(time=2020-01-02 02:04:05 +0000 UTC)",
+			"", "(time=2020-01-02 02:04:05 +0000 UTC)"},
+		{"<date>2020-01-02T03:04:05</date>", "", "", "(time=2020-01-02 03:04:05 +0000 UTC)"},
+		{"<date>2020-01-02</date>", "", "", "(time=2020-01-02 00:00:00 +0000 UTC)"},
+		{"<data>\n\taGVs\n\tbG8=\n</data>", "(bytes=5 bytes)", "(bytes=5 bytes)", "(bytes=5 bytes)"},
+		{"<data>aGVsbG8</data>", "", "", "(bytes=5 bytes)"},
+		{"<integer>-0x1F</integer>", "", "", "(int=-31)"},
+	}
+	parse := func(opts bplist.ParseOptions, input string) string {
+		var buf strings.Builder
+		input = "<plist>" + input + "</plist>"
+		if err := opts.ParseXML([]byte(input), testHandler{log: t.Logf, buf: &buf}); err != nil {
+			t.Logf("ParseXML(%q): %v", input, err)
+			return ""
+		}
+		return strings.TrimPrefix(buf.String(), `V"00"`)
+	}
+	for _, tc := range tests {
+		if got := parse(bplist.ParseOptions{}, tc.input); got != tc.want {
+			t.Errorf("ParseXML(%q): got %q, want %q", tc.input, got, tc.want)
+		}
+		if got := parse(bplist.ParseOptions{Strict: true, LenientXML: true}, tc.input); got != tc.strict {
+			t.Errorf("ParseXML(%q) strict: got %q, want %q", tc.input, got, tc.strict)
+		}
+		if got := parse(bplist.ParseOptions{LenientXML: true}, tc.input); got != tc.loose {
+			t.Errorf("ParseXML(%q) lenient: got %q, want %q", tc.input, got, tc.loose)
+		}
+	}
+}
+
+func TestGNUstepXML(t *testing.T) {
+	b := bplist.NewBuilder()
+	b.SetOptions(&bplist.BuilderOptions{XML: bplist.XMLOptions{Dialect: bplist.GNUstepXML}})
+	b.Open(bplist.Dict, func(b *bplist.Builder) {
+		b.Pair("a\\b", bplist.TString, "bell\a\tU")
+	})
+	var buf bytes.Buffer
+	if _, err := b.WriteXML(&buf); err != nil {
+		t.Fatalf("WriteXML failed: %v", err)
+	}
+	const want = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//GNUstep//DTD plist 0.9//EN" "http://www.gnustep.org/plist-0_9.dtd">
+<plist version="0.9">
+<dict>
+	<key>a\\b</key>
+	<string>bell\U0007	U</string>
+</dict>
+</plist>
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteXML:\n got %s\nwant %s", got, want)
+	}
+
+	// Parsing in the GNUstep dialect decodes the escapes.
+	var out strings.Builder
+	opts := bplist.ParseOptions{XMLDialect: bplist.GNUstepXML}
+	if err := opts.ParseXML(buf.Bytes(), testHandler{log: t.Logf, buf: &out}); err != nil {
+		t.Fatalf("ParseXML failed: %v", err)
+	}
+	const wantEvents = `V"00"<dict size=1>(string=a\b)(string=bell` + "\a\t" + `U)</dict>`
+	if got := out.String(); got != wantEvents {
+		t.Errorf("ParseXML: got %q, want %q", got, wantEvents)
+	}
+
+	// The Apple dialect leaves backslashes alone.
+	out.Reset()
+	if err := bplist.ParseXML(buf.Bytes(), testHandler{log: t.Logf, buf: &out}); err != nil {
+		t.Fatalf("ParseXML failed: %v", err)
+	}
+	const wantApple = `V"00"<dict size=1>(string=a\\b)(string=bell\U0007` + "\t" + `U)</dict>`
+	if got := out.String(); got != wantApple {
+		t.Errorf("ParseXML: got %q, want %q", got, wantApple)
+	}
+}